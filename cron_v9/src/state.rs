@@ -22,3 +22,32 @@ pub struct Entry {
 }
 
 impl Cbor for State {}
+
+#[cfg(test)]
+mod tests {
+    use fil_actors_runtime_v9::{STORAGE_MARKET_ACTOR_ADDR, STORAGE_POWER_ACTOR_ADDR};
+    use fvm_ipld_encoding::{from_slice, to_vec};
+
+    use super::*;
+
+    #[test]
+    fn builtin_entries_round_trip() {
+        // The entries installed at genesis: power OnEpochTickEnd, then market CronTick.
+        let st = State {
+            entries: vec![
+                Entry {
+                    receiver: STORAGE_POWER_ACTOR_ADDR,
+                    method_num: 5,
+                },
+                Entry {
+                    receiver: STORAGE_MARKET_ACTOR_ADDR,
+                    method_num: 9,
+                },
+            ],
+        };
+        let decoded: State = from_slice(&to_vec(&st).unwrap()).unwrap();
+        let receivers: Vec<_> = decoded.entries.iter().map(|e| e.receiver).collect();
+        assert_eq!(vec![Address::new_id(4), Address::new_id(5)], receivers);
+        assert_eq!(st.entries, decoded.entries);
+    }
+}
@@ -0,0 +1,60 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! Checks run by the fuzz targets, shared with the regression tests that replay the inputs
+//! fuzzing has found.
+
+use fvm_ipld_encoding::Cbor;
+
+/// The strict decoder must accept exactly the inputs that are the canonical encoding of the
+/// decoded value, otherwise we accept inputs that go-state-types rejects (or hashes to a
+/// different CID).
+macro_rules! assert_canonical {
+    ($ty:ty, $strict:path, $data:expr, $desc:expr) => {
+        let canonical = match <$ty>::unmarshal_cbor($data) {
+            Ok(value) => {
+                let encoded = value
+                    .marshal_cbor()
+                    .expect(concat!("failed to re-encode ", $desc));
+                encoded == $data
+            }
+            Err(_) => false,
+        };
+        let strict: Result<$ty, _> = $strict($data, $desc);
+        assert_eq!(
+            canonical,
+            strict.is_ok(),
+            "strict decode disagrees with re-encoding"
+        );
+    };
+}
+
+pub fn check_deal_proposal(data: &[u8]) {
+    assert_canonical!(
+        fil_actor_market_v8::DealProposal,
+        fil_actors_runtime_v8::cbor::deserialize_strict,
+        data,
+        "deal proposal"
+    );
+    assert_canonical!(
+        fil_actor_market_v9::DealProposal,
+        fil_actors_runtime_v9::cbor::deserialize_strict,
+        data,
+        "deal proposal"
+    );
+}
+
+pub fn check_miner_info(data: &[u8]) {
+    assert_canonical!(
+        fil_actor_miner_v8::MinerInfo,
+        fil_actors_runtime_v8::cbor::deserialize_strict,
+        data,
+        "miner info"
+    );
+    assert_canonical!(
+        fil_actor_miner_v9::MinerInfo,
+        fil_actors_runtime_v9::cbor::deserialize_strict,
+        data,
+        "miner info"
+    );
+}
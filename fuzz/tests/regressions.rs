@@ -0,0 +1,39 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! Replays inputs fuzzing has found through the checks of their fuzz target, so fixed decoder
+//! bugs stay fixed. Each target has a directory under `regressions/` named after it, and every
+//! file in it is run: to add a case, copy the crash or divergence input from `artifacts/` there.
+
+use std::fs;
+use std::panic;
+use std::path::Path;
+
+fn replay(target: &str, check: fn(&[u8])) {
+    let dir = Path::new(env!("CARGO_MANIFEST_DIR"))
+        .join("regressions")
+        .join(target);
+    let mut count = 0;
+    for entry in fs::read_dir(&dir).unwrap() {
+        let path = entry.unwrap().path();
+        let data = fs::read(&path).unwrap();
+        if panic::catch_unwind(|| check(&data)).is_err() {
+            panic!("{} fails on {}", target, path.display());
+        }
+        count += 1;
+    }
+    assert!(count > 0, "no inputs in {}", dir.display());
+}
+
+#[test]
+fn deal_proposal_cbor() {
+    replay(
+        "deal_proposal_cbor",
+        fil_actor_states_fuzz::check_deal_proposal,
+    );
+}
+
+#[test]
+fn miner_info_cbor() {
+    replay("miner_info_cbor", fil_actor_states_fuzz::check_miner_info);
+}
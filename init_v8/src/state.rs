@@ -50,6 +50,12 @@ impl State {
         Ok(id)
     }
 
+    /// Returns the ID address the next actor created will be assigned, such as the miner created
+    /// by a pending `CreateMiner` message if no other actor is created before it.
+    pub fn next_id_address(&self) -> Address {
+        Address::new_id(self.next_id)
+    }
+
     /// ResolveAddress resolves an address to an ID-address, if possible.
     /// If the provided address is an ID address, it is returned as-is.
     /// This means that mapped ID-addresses (which should only appear as values, not keys) and
@@ -73,6 +79,46 @@ impl State {
 
         Ok(map.get(&addr.to_bytes())?.copied().map(Address::new_id))
     }
+
+    /// Calls `f` with every robust address in the address map and the actor ID it maps to.
+    pub fn for_each_address<BS, F>(&self, store: &BS, mut f: F) -> anyhow::Result<()>
+    where
+        BS: Blockstore,
+        F: FnMut(Address, ActorID) -> anyhow::Result<()>,
+    {
+        let map = make_map_with_root_and_bitwidth(&self.address_map, store, HAMT_BIT_WIDTH)?;
+        map.for_each(|key, id: &ActorID| {
+            let addr = Address::from_bytes(key)?;
+            f(addr, *id)
+        })?;
+        Ok(())
+    }
 }
 
 impl Cbor for State {}
+
+#[cfg(test)]
+mod tests {
+    use fvm_ipld_blockstore::MemoryBlockstore;
+
+    use super::*;
+
+    #[test]
+    fn for_each_address_visits_all_mappings() {
+        let store = MemoryBlockstore::new();
+        let mut st = State::new(&store, "test".to_string()).unwrap();
+        let secp = Address::new_secp256k1(&[0x4; 65]).unwrap();
+        let actor = Address::new_actor(b"actor");
+        let secp_id = st.map_address_to_new_id(&store, &secp).unwrap();
+        let actor_id = st.map_address_to_new_id(&store, &actor).unwrap();
+
+        let mut found = Vec::new();
+        st.for_each_address(&store, |addr, id| {
+            found.push((addr, id));
+            Ok(())
+        })
+        .unwrap();
+        found.sort_by_key(|(_, id)| *id);
+        assert_eq!(vec![(secp, secp_id), (actor, actor_id)], found);
+    }
+}
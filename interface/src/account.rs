@@ -0,0 +1,91 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use fvm_shared::address::{Address, Protocol};
+use fvm_shared::crypto::signature::SignatureType;
+
+/// Read access to the account actor state common to all versions.
+pub trait AccountStateExt {
+    /// The public key (`f1` secp256k1 or `f3` BLS) address the account was created for.
+    fn pubkey_address(&self) -> Address;
+}
+
+macro_rules! impl_account_state_ext {
+    ($($state:ty),+) => {
+        $(
+            impl AccountStateExt for $state {
+                fn pubkey_address(&self) -> Address {
+                    self.address
+                }
+            }
+        )+
+    };
+}
+
+impl_account_state_ext!(fil_actor_account_v8::State, fil_actor_account_v9::State);
+
+/// Returns the type of signature that an account with the given public key address signs
+/// messages with, or `None` if the address is not a public key address.
+pub fn address_signature_type(addr: &Address) -> Option<SignatureType> {
+    match addr.protocol() {
+        Protocol::Secp256k1 => Some(SignatureType::Secp256k1),
+        Protocol::BLS => Some(SignatureType::BLS),
+        Protocol::ID | Protocol::Actor => None,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn pubkey_address_parity() {
+        let secp = Address::new_secp256k1(&[0x4; 65]).unwrap();
+        let bls = Address::new_bls(&[0x3; 48]).unwrap();
+        for addr in [secp, bls] {
+            let v8 = fil_actor_account_v8::State { address: addr };
+            let v9 = fil_actor_account_v9::State { address: addr };
+            let states: [&dyn AccountStateExt; 2] = [&v8, &v9];
+            for state in states {
+                assert_eq!(addr, state.pubkey_address());
+            }
+        }
+    }
+
+    #[test]
+    fn signature_type_by_protocol() {
+        let secp = Address::new_secp256k1(&[0x4; 65]).unwrap();
+        let bls = Address::new_bls(&[0x3; 48]).unwrap();
+        assert_eq!(
+            Some(SignatureType::Secp256k1),
+            address_signature_type(&secp)
+        );
+        assert_eq!(Some(SignatureType::BLS), address_signature_type(&bls));
+        assert_eq!(None, address_signature_type(&Address::new_id(100)));
+        assert_eq!(None, address_signature_type(&Address::new_actor(b"actor")));
+    }
+
+    #[test]
+    fn address_string_round_trip() {
+        let secp = Address::new_secp256k1(&[0x4; 65]).unwrap();
+        let bls = Address::new_bls(&[0x3; 48]).unwrap();
+        for (addr, expected) in [
+            (Address::new_id(100), "f0100"),
+            (secp, "f134buwikwmjplvsoy67d26ypp6q5ekhu7qap6hwq"),
+            (bls, "f3ambqgaydambqgaydambqgaydambqgaydambqgaydambqgaydambqgaydambqgaydambqgaydambqhij4rrbq"),
+        ] {
+            assert_eq!(expected, addr.to_string());
+            assert_eq!(addr, expected.parse().unwrap());
+        }
+
+        // Testnet addresses keep their prefix through a round trip.
+        for s in ["t0100", "t2v33d7lvwzzbi4gc4godxslx7bljd7eb2pye74ga"] {
+            assert_eq!(s, s.parse::<Address>().unwrap().to_string());
+        }
+
+        // A corrupted checksum is rejected.
+        assert!("f134buwikwmjplvsoy67d26ypp6q5ekhu7qap6hwa"
+            .parse::<Address>()
+            .is_err());
+    }
+}
@@ -0,0 +1,389 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::collections::HashMap;
+
+use cid::Cid;
+use fvm_ipld_blockstore::Blockstore;
+use fvm_shared::version::NetworkVersion;
+
+use crate::error::{load_cbor, StateError};
+
+/// Version of the builtin actors bundle a state tree was produced with.
+#[derive(Clone, Copy, Debug, PartialEq, Eq)]
+pub enum ActorVersion {
+    V8,
+    V9,
+}
+
+/// Returns the actors version (as used in Lotus' `actorstypes.Version`) that the network runs at
+/// the given network version.
+pub fn actors_version_for_network(nv: NetworkVersion) -> Result<u32, StateError> {
+    Ok(match nv as u32 {
+        0..=3 => 0,
+        4..=9 => 2,
+        10 | 11 => 3,
+        12 => 4,
+        13 => 5,
+        14 => 6,
+        15 => 7,
+        16 => 8,
+        17 => 9,
+        v => return Err(StateError::UnknownNetworkVersion(v)),
+    })
+}
+
+macro_rules! actor_states {
+    ($($name:literal => { $($variant:ident($version:ident, $state:ty)),+ }),+ $(,)?) => {
+        /// The decoded state of any builtin actor shipped in this workspace.
+        pub enum ActorState {
+            $($($variant($state),)+)+
+        }
+
+        pub(crate) fn decode_state<BS: Blockstore>(
+            store: &BS,
+            version: ActorVersion,
+            name: &str,
+            head: &Cid,
+        ) -> Result<ActorState, StateError> {
+            match (name, version) {
+                $($(
+                    ($name, ActorVersion::$version) => {
+                        Ok(ActorState::$variant(load_cbor(store, head)?))
+                    }
+                )+)+
+                _ => Err(StateError::UnsupportedVersion {
+                    name: name.to_string(),
+                    version,
+                }),
+            }
+        }
+    };
+}
+
+actor_states! {
+    "system" => {
+        SystemV8(V8, fil_actor_system_v8::State),
+        SystemV9(V9, fil_actor_system_v9::State)
+    },
+    "init" => {
+        InitV8(V8, fil_actor_init_v8::State),
+        InitV9(V9, fil_actor_init_v9::State)
+    },
+    "cron" => {
+        CronV8(V8, fil_actor_cron_v8::State),
+        CronV9(V9, fil_actor_cron_v9::State)
+    },
+    "account" => {
+        AccountV8(V8, fil_actor_account_v8::State),
+        AccountV9(V9, fil_actor_account_v9::State)
+    },
+    "storagepower" => {
+        PowerV8(V8, fil_actor_power_v8::State),
+        PowerV9(V9, fil_actor_power_v9::State)
+    },
+    "storageminer" => {
+        MinerV8(V8, fil_actor_miner_v8::State),
+        MinerV9(V9, fil_actor_miner_v9::State)
+    },
+    "storagemarket" => {
+        MarketV8(V8, fil_actor_market_v8::State),
+        MarketV9(V9, fil_actor_market_v9::State)
+    },
+    "paymentchannel" => {
+        PaychV8(V8, fil_actor_paych_v8::State),
+        PaychV9(V9, fil_actor_paych_v9::State)
+    },
+    "multisig" => {
+        MultisigV8(V8, fil_actor_multisig_v8::State),
+        MultisigV9(V9, fil_actor_multisig_v9::State)
+    },
+    "reward" => {
+        RewardV8(V8, fil_actor_reward_v8::State),
+        RewardV9(V9, fil_actor_reward_v9::State)
+    },
+    "verifiedregistry" => {
+        VerifregV8(V8, fil_actor_verifreg_v8::State),
+        VerifregV9(V9, fil_actor_verifreg_v9::State)
+    },
+}
+
+/// Decodes the state at `head` of the named builtin actor without knowing its actors version, by
+/// trying each shipped version newest first. Returns the first version whose state type decodes
+/// the block, together with the decoded state.
+///
+/// This is a heuristic for when the actor's code CID is unavailable, e.g. when inspecting a raw
+/// block. A state whose layout did not change between versions decodes under each of them and is
+/// reported as the newest: miner states are laid out identically in v8 and v9, so are always
+/// detected as v9. Market states gained a field in v9, so their version is unambiguous.
+pub fn detect_actor_state<BS: Blockstore>(
+    store: &BS,
+    name: &str,
+    head: &Cid,
+) -> Result<(ActorVersion, ActorState), StateError> {
+    let mut last_err = None;
+    for version in [ActorVersion::V9, ActorVersion::V8] {
+        match decode_state(store, version, name, head) {
+            Ok(state) => return Ok((version, state)),
+            Err(e @ StateError::Decode { .. }) | Err(e @ StateError::UnsupportedVersion { .. }) => {
+                last_err = Some(e)
+            }
+            Err(e) => return Err(e),
+        }
+    }
+    Err(last_err.expect("at least one version is tried"))
+}
+
+/// Maps the code CIDs of a builtin actors bundle to the actors they implement.
+///
+/// Code CIDs differ between networks, so they are taken from the bundle manifest (as stored in
+/// the system actor's `builtin_actors` registry) rather than hard-coded.
+pub struct Manifest {
+    version: ActorVersion,
+    names: HashMap<Cid, String>,
+}
+
+impl Manifest {
+    pub fn new(version: ActorVersion, entries: Vec<(String, Cid)>) -> Self {
+        Self {
+            version,
+            names: entries
+                .into_iter()
+                .map(|(name, code)| (code, name))
+                .collect(),
+        }
+    }
+
+    /// Loads the manifest from the system actor's `builtin_actors` root, see
+    /// [`SystemStateExt::builtin_actors`](crate::SystemStateExt::builtin_actors).
+    pub fn load<BS: Blockstore>(
+        store: &BS,
+        version: ActorVersion,
+        builtin_actors: &Cid,
+    ) -> Result<Self, StateError> {
+        let entries = load_cbor(store, builtin_actors)?;
+        Ok(Self::new(version, entries))
+    }
+
+    /// Returns the name of the builtin actor with the given code CID, if it is in the bundle.
+    pub fn actor_name(&self, code: &Cid) -> Option<&str> {
+        self.names.get(code).map(String::as_str)
+    }
+
+    /// Decodes the state at `head` of an actor with the given code CID.
+    pub fn load_actor_state<BS: Blockstore>(
+        &self,
+        store: &BS,
+        code: &Cid,
+        head: &Cid,
+    ) -> Result<ActorState, StateError> {
+        let name = self
+            .actor_name(code)
+            .ok_or(StateError::UnknownCode(*code))?;
+        decode_state(store, self.version, name, head)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use cid::multihash::{Code, MultihashDigest};
+    use fil_actors_runtime_v9::runtime::Policy;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_ipld_encoding::{CborStore, DAG_CBOR};
+
+    use super::*;
+
+    const IPLD_RAW: u64 = 0x55;
+
+    fn code(name: &str) -> Cid {
+        Cid::new_v1(IPLD_RAW, Code::Blake2b256.digest(name.as_bytes()))
+    }
+
+    fn manifest(version: ActorVersion) -> Manifest {
+        let names = ["storageminer", "storagemarket", "storagepower"];
+        Manifest::new(
+            version,
+            names.iter().map(|n| (n.to_string(), code(n))).collect(),
+        )
+    }
+
+    #[test]
+    fn load_actor_state_by_code() {
+        let store = MemoryBlockstore::default();
+        let v8 = manifest(ActorVersion::V8);
+        let v9 = manifest(ActorVersion::V9);
+
+        let info = store.put_cbor(&"info", Code::Blake2b256).unwrap();
+        let miner = fil_actor_miner_v9::State::new(&Policy::default(), &store, info, 0, 0).unwrap();
+        let head = store.put_cbor(&miner, Code::Blake2b256).unwrap();
+        assert!(matches!(
+            v9.load_actor_state(&store, &code("storageminer"), &head),
+            Ok(ActorState::MinerV9(st)) if st.info == info
+        ));
+
+        let market = fil_actor_market_v8::State::new(&store).unwrap();
+        let head = store.put_cbor(&market, Code::Blake2b256).unwrap();
+        assert!(matches!(
+            v8.load_actor_state(&store, &code("storagemarket"), &head),
+            Ok(ActorState::MarketV8(_))
+        ));
+
+        let power = fil_actor_power_v9::State::new(&store).unwrap();
+        let head = store.put_cbor(&power, Code::Blake2b256).unwrap();
+        assert!(matches!(
+            v9.load_actor_state(&store, &code("storagepower"), &head),
+            Ok(ActorState::PowerV9(_))
+        ));
+    }
+
+    /// A blockstore whose reads always fail.
+    struct FailingStore;
+
+    impl Blockstore for FailingStore {
+        fn get(&self, _: &Cid) -> anyhow::Result<Option<Vec<u8>>> {
+            Err(anyhow::anyhow!("store unavailable"))
+        }
+
+        fn put_keyed(&self, _: &Cid, _: &[u8]) -> anyhow::Result<()> {
+            Err(anyhow::anyhow!("store unavailable"))
+        }
+    }
+
+    #[test]
+    fn load_actor_state_errors() {
+        let store = MemoryBlockstore::default();
+        let power = store
+            .put_cbor(
+                &fil_actor_power_v9::State::new(&store).unwrap(),
+                Code::Blake2b256,
+            )
+            .unwrap();
+        let v9 = manifest(ActorVersion::V9);
+
+        let missing = Cid::new_v1(DAG_CBOR, Code::Blake2b256.digest(b"missing"));
+        assert!(matches!(
+            v9.load_actor_state(&store, &code("storagepower"), &missing),
+            Err(StateError::NotFound(cid)) if cid == missing
+        ));
+
+        // The power state is not a valid miner state.
+        assert!(matches!(
+            v9.load_actor_state(&store, &code("storageminer"), &power),
+            Err(StateError::Decode { cid, .. }) if cid == power
+        ));
+
+        assert!(matches!(
+            v9.load_actor_state(&store, &code("evm"), &power),
+            Err(StateError::UnknownCode(c)) if c == code("evm")
+        ));
+
+        let bundle = Manifest::new(ActorVersion::V9, vec![("evm".to_string(), code("evm"))]);
+        assert!(matches!(
+            bundle.load_actor_state(&store, &code("evm"), &power),
+            Err(StateError::UnsupportedVersion { name, version: ActorVersion::V9 }) if name == "evm"
+        ));
+
+        assert!(matches!(
+            v9.load_actor_state(&FailingStore, &code("storagepower"), &power),
+            Err(StateError::Store(_))
+        ));
+
+        // Callers using anyhow keep working.
+        let res: anyhow::Result<()> = (|| {
+            v9.load_actor_state(&store, &code("storagepower"), &missing)?;
+            Ok(())
+        })();
+        assert!(res.unwrap_err().downcast_ref::<StateError>().is_some());
+    }
+
+    #[test]
+    fn load_manifest() {
+        let store = MemoryBlockstore::default();
+        let entries = vec![("storagepower".to_string(), code("storagepower"))];
+        let root = store.put_cbor(&entries, Code::Blake2b256).unwrap();
+        let manifest = Manifest::load(&store, ActorVersion::V9, &root).unwrap();
+        assert_eq!(
+            Some("storagepower"),
+            manifest.actor_name(&code("storagepower"))
+        );
+        assert_eq!(None, manifest.actor_name(&code("storageminer")));
+    }
+
+    #[test]
+    fn detect_market_and_miner_version() {
+        let store = MemoryBlockstore::default();
+
+        let market = fil_actor_market_v8::State::new(&store).unwrap();
+        let v8_market = store.put_cbor(&market, Code::Blake2b256).unwrap();
+        assert!(matches!(
+            detect_actor_state(&store, "storagemarket", &v8_market),
+            Ok((ActorVersion::V8, ActorState::MarketV8(_)))
+        ));
+        let market = fil_actor_market_v9::State::new(&store).unwrap();
+        let v9_market = store.put_cbor(&market, Code::Blake2b256).unwrap();
+        assert!(matches!(
+            detect_actor_state(&store, "storagemarket", &v9_market),
+            Ok((ActorVersion::V9, ActorState::MarketV9(_)))
+        ));
+
+        // Neither market is mistaken for a miner, nor a power state for a market.
+        assert!(matches!(
+            detect_actor_state(&store, "storageminer", &v8_market),
+            Err(StateError::Decode { .. })
+        ));
+        let power = fil_actor_power_v9::State::new(&store).unwrap();
+        let power = store.put_cbor(&power, Code::Blake2b256).unwrap();
+        assert!(matches!(
+            detect_actor_state(&store, "storagemarket", &power),
+            Err(StateError::Decode { .. })
+        ));
+
+        // Miner states share a layout, so a v8 miner is reported as the newest version.
+        let info = store.put_cbor(&"info", Code::Blake2b256).unwrap();
+        let miner = fil_actor_miner_v8::State::new(&Policy::default(), &store, info, 0, 0).unwrap();
+        let head = store.put_cbor(&miner, Code::Blake2b256).unwrap();
+        assert!(matches!(
+            detect_actor_state(&store, "storageminer", &head),
+            Ok((ActorVersion::V9, ActorState::MinerV9(st))) if st.info == info
+        ));
+
+        let missing = Cid::new_v1(DAG_CBOR, Code::Blake2b256.digest(b"missing"));
+        assert!(matches!(
+            detect_actor_state(&store, "storagemarket", &missing),
+            Err(StateError::NotFound(_))
+        ));
+    }
+
+    #[test]
+    fn actors_version_by_network_version() {
+        use NetworkVersion::*;
+        let cases = [
+            (V0, 0),
+            (V1, 0),
+            (V2, 0),
+            (V3, 0),
+            (V4, 2),
+            (V5, 2),
+            (V6, 2),
+            (V7, 2),
+            (V8, 2),
+            (V9, 2),
+            (V10, 3),
+            (V11, 3),
+            (V12, 4),
+            (V13, 5),
+            (V14, 6),
+            (V15, 7),
+            (V16, 8),
+            (V17, 9),
+        ];
+        for (nv, expected) in cases {
+            assert_eq!(
+                expected,
+                actors_version_for_network(nv).unwrap(),
+                "{:?}",
+                nv
+            );
+        }
+    }
+}
@@ -0,0 +1,152 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use anyhow::anyhow;
+use fvm_ipld_bitfield::iter::Ranges;
+use fvm_ipld_bitfield::BitField;
+
+/// Returns the bits set in `new` but not in `old` (added) and the bits set in `old` but not
+/// in `new` (removed), e.g. the sectors that entered or left a deadline between two states.
+pub fn bitfield_diff(old: &BitField, new: &BitField) -> (BitField, BitField) {
+    (new - old, old - new)
+}
+
+/// Conversion of bitfields to and from their runs of set bits, as `(start, length)` pairs in
+/// ascending order. The runs are read from and written to the RLE+ form without expanding the
+/// bits, and serialize to JSON as `[[start, length], ...]`.
+pub trait BitFieldRunsExt: Sized {
+    fn to_runs(&self) -> Vec<(u64, u64)>;
+    /// Builds a bitfield from runs, which must be non-empty, in ascending order and
+    /// non-overlapping. Adjacent runs are merged.
+    fn from_runs(runs: &[(u64, u64)]) -> anyhow::Result<Self>;
+}
+
+impl BitFieldRunsExt for BitField {
+    fn to_runs(&self) -> Vec<(u64, u64)> {
+        self.ranges().map(|r| (r.start, r.end - r.start)).collect()
+    }
+
+    fn from_runs(runs: &[(u64, u64)]) -> anyhow::Result<Self> {
+        let mut ranges: Vec<std::ops::Range<u64>> = Vec::with_capacity(runs.len());
+        for &(start, len) in runs {
+            if len == 0 {
+                return Err(anyhow!("empty run at {}", start));
+            }
+            let end = start
+                .checked_add(len)
+                .ok_or_else(|| anyhow!("run of {} at {} overflows", len, start))?;
+            match ranges.last_mut() {
+                Some(last) if start < last.end => {
+                    return Err(anyhow!(
+                        "run at {} overlaps or precedes run ending at {}",
+                        start,
+                        last.end
+                    ));
+                }
+                Some(last) if start == last.end => last.end = end,
+                _ => ranges.push(start..end),
+            }
+        }
+        Ok(BitField::from_ranges(Ranges::new(ranges)))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn bf<I: IntoIterator<Item = u64>>(bits: I) -> BitField {
+        BitField::try_from_bits(bits).unwrap()
+    }
+
+    fn assert_canonical(expected: &[u64], actual: &BitField) {
+        assert_eq!(expected, actual.iter().collect::<Vec<_>>());
+        // The diff must serialize to the same RLE+ bytes as a bitfield built from the bits.
+        assert_eq!(
+            fvm_ipld_encoding::to_vec(&bf(expected.iter().copied())).unwrap(),
+            fvm_ipld_encoding::to_vec(actual).unwrap()
+        );
+    }
+
+    #[test]
+    fn overlapping_ranges() {
+        let old = bf((0..10).chain(20..30));
+        let new = bf(5..25);
+        let (added, removed) = bitfield_diff(&old, &new);
+        assert_canonical(&(10..20).collect::<Vec<_>>(), &added);
+        assert_canonical(&(0..5).chain(25..30).collect::<Vec<_>>(), &removed);
+    }
+
+    #[test]
+    fn disjoint_ranges() {
+        let old = bf([1, 2, 3]);
+        let new = bf([7, 8]);
+        let (added, removed) = bitfield_diff(&old, &new);
+        assert_canonical(&[7, 8], &added);
+        assert_canonical(&[1, 2, 3], &removed);
+    }
+
+    #[test]
+    fn identical_bitfields() {
+        let old = bf([0, 4, 5, 6, 100]);
+        let (added, removed) = bitfield_diff(&old, &old.clone());
+        assert_canonical(&[], &added);
+        assert_canonical(&[], &removed);
+    }
+
+    #[test]
+    fn runs_round_trip() {
+        let bits = bf((0..3).chain(10..11).chain(100..200));
+        let runs = bits.to_runs();
+        assert_eq!(vec![(0, 3), (10, 1), (100, 100)], runs);
+        assert_canonical(
+            &bits.iter().collect::<Vec<_>>(),
+            &BitField::from_runs(&runs).unwrap(),
+        );
+
+        assert!(BitField::new().to_runs().is_empty());
+        assert_canonical(&[], &BitField::from_runs(&[]).unwrap());
+
+        // Adjacent runs are merged into the canonical encoding.
+        assert_canonical(
+            &[4, 5, 6, 7],
+            &BitField::from_runs(&[(4, 2), (6, 2)]).unwrap(),
+        );
+    }
+
+    #[test]
+    fn invalid_runs() {
+        assert!(BitField::from_runs(&[(0, 0)]).is_err());
+        assert!(BitField::from_runs(&[(0, 5), (3, 5)]).is_err());
+        assert!(BitField::from_runs(&[(10, 1), (0, 1)]).is_err());
+        assert!(BitField::from_runs(&[(u64::MAX, 2)]).is_err());
+    }
+
+    #[test]
+    fn rle_encoding_matches_go() {
+        // CBOR byte strings holding the RLE+ encodings go-bitfield writes for the same bits.
+        let cases: [(&[u64], &str); 3] = [
+            (&[], "40"),
+            (&[0, 2, 4, 6, 8, 10, 12, 14, 16, 18], "43fcff3f"),
+            (&[0, 1, 2, 10], "4274bc"),
+        ];
+        for (bits, expected) in cases {
+            let encoded = fvm_ipld_encoding::to_vec(&bf(bits.iter().copied())).unwrap();
+            assert_eq!(expected, hex::encode(encoded), "{:?}", bits);
+        }
+        let long_runs = [
+            (bf(0..1000), "4204fd"),
+            (bf((1 << 20)..(1 << 20) + 100_000), "4700101008d04603"),
+            (bf((0..3).chain(10..11).chain(100..200)), "4574bc644106"),
+        ];
+        for (bits, expected) in long_runs {
+            assert_eq!(
+                expected,
+                hex::encode(fvm_ipld_encoding::to_vec(&bits).unwrap())
+            );
+            let decoded: BitField =
+                fvm_ipld_encoding::from_slice(&hex::decode(expected).unwrap()).unwrap();
+            assert_eq!(bits, decoded);
+        }
+    }
+}
@@ -0,0 +1,137 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::fmt;
+
+use cid::Cid;
+use serde::de::{self, MapAccess, Visitor};
+use serde::ser::SerializeMap;
+use serde::{Deserialize, Deserializer, Serialize, Serializer};
+
+/// Returns whether two CIDs address the same content with the same codec, regardless of whether
+/// either is a CIDv0 or CIDv1. A CIDv0 is equal to the `dag-pb` CIDv1 with the same multihash.
+pub fn cid_equal_ignoring_version(a: &Cid, b: &Cid) -> bool {
+    a.codec() == b.codec() && a.hash() == b.hash()
+}
+
+/// A CID that serializes as Lotus' JSON API and DAG-JSON encode links, `{"/": "bafy..."}`, for
+/// CIDs exposed through JSON. A bare [`Cid`] serializes as an IPLD link, which JSON formats render
+/// differently.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Hash)]
+pub struct LotusCid(pub Cid);
+
+impl From<Cid> for LotusCid {
+    fn from(cid: Cid) -> Self {
+        Self(cid)
+    }
+}
+
+impl From<LotusCid> for Cid {
+    fn from(cid: LotusCid) -> Self {
+        cid.0
+    }
+}
+
+impl Serialize for LotusCid {
+    fn serialize<S: Serializer>(&self, serializer: S) -> Result<S::Ok, S::Error> {
+        let mut map = serializer.serialize_map(Some(1))?;
+        map.serialize_entry("/", &self.0.to_string())?;
+        map.end()
+    }
+}
+
+impl<'de> Deserialize<'de> for LotusCid {
+    fn deserialize<D: Deserializer<'de>>(deserializer: D) -> Result<Self, D::Error> {
+        struct LinkVisitor;
+
+        impl<'de> Visitor<'de> for LinkVisitor {
+            type Value = LotusCid;
+
+            fn expecting(&self, f: &mut fmt::Formatter) -> fmt::Result {
+                f.write_str(r#"a link {"/": "<cid>"}"#)
+            }
+
+            fn visit_map<A: MapAccess<'de>>(self, mut map: A) -> Result<Self::Value, A::Error> {
+                let (key, cid): (String, String) = map
+                    .next_entry()?
+                    .ok_or_else(|| de::Error::invalid_length(0, &self))?;
+                if key != "/" {
+                    return Err(de::Error::unknown_field(&key, &["/"]));
+                }
+                if map.next_key::<String>()?.is_some() {
+                    return Err(de::Error::invalid_length(2, &self));
+                }
+                Cid::try_from(cid.as_str())
+                    .map(LotusCid)
+                    .map_err(|e| de::Error::custom(format!("invalid cid {}: {}", cid, e)))
+            }
+        }
+
+        deserializer.deserialize_map(LinkVisitor)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::BTreeMap;
+    use std::str::FromStr;
+
+    use libipld_core::ipld::Ipld;
+    use libipld_core::serde::{from_ipld, to_ipld};
+
+    use super::*;
+
+    const IPLD_RAW: u64 = 0x55;
+
+    #[test]
+    fn v0_and_v1_of_same_block() {
+        // The empty UnixFS directory.
+        let v0 = Cid::from_str("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn").unwrap();
+        let v1 =
+            Cid::from_str("bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354").unwrap();
+        assert_ne!(v0, v1);
+        assert!(cid_equal_ignoring_version(&v0, &v1));
+        assert!(cid_equal_ignoring_version(&v1, &v0));
+        assert!(cid_equal_ignoring_version(&v1, &v1));
+    }
+
+    #[test]
+    fn different_codec_or_hash() {
+        let v0 = Cid::from_str("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn").unwrap();
+        let raw = Cid::new_v1(IPLD_RAW, *v0.hash());
+        assert!(!cid_equal_ignoring_version(&v0, &raw));
+
+        let other = Cid::from_str("bafy2bzacedijw74yui7otvo63nfl3hdq2vdzuy7wx2tnptwed6zml4vvz7wee")
+            .unwrap();
+        assert!(!cid_equal_ignoring_version(&v0, &other));
+    }
+
+    #[test]
+    fn lotus_cid_json() {
+        let cid = Cid::from_str("bafy2bzaceamp42wmmgr2g2ymg46euououzfyck7szknvfacqscohrvaikwfay")
+            .unwrap();
+        let key = BTreeMap::from([("Cids".to_string(), vec![LotusCid(cid), LotusCid(cid)])]);
+        let json = serde_json::to_string(&key).unwrap();
+        // As the Cids of a tipset in the JSON of Lotus' ChainHead.
+        assert_eq!(
+            r#"{"Cids":[{"/":"bafy2bzaceamp42wmmgr2g2ymg46euououzfyck7szknvfacqscohrvaikwfay"},{"/":"bafy2bzaceamp42wmmgr2g2ymg46euououzfyck7szknvfacqscohrvaikwfay"}]}"#,
+            json
+        );
+        assert_eq!(
+            key,
+            serde_json::from_str::<BTreeMap<String, Vec<LotusCid>>>(&json).unwrap()
+        );
+        let node = to_ipld(&key).unwrap();
+        assert_eq!(
+            key,
+            from_ipld::<BTreeMap<String, Vec<LotusCid>>>(node).unwrap()
+        );
+
+        let link = |key: &str, value: &str| {
+            Ipld::Map([(key.to_string(), Ipld::String(value.to_string()))].into())
+        };
+        assert!(from_ipld::<LotusCid>(link("cid", &cid.to_string())).is_err());
+        assert!(from_ipld::<LotusCid>(link("/", "not a cid")).is_err());
+        assert!(from_ipld::<LotusCid>(Ipld::Link(cid)).is_err());
+    }
+}
@@ -0,0 +1,155 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! Mainnet network parameters, scoped by actor version so that callers pick up the values in
+//! force at the version they are inspecting.
+
+use fvm_shared::clock::ChainEpoch;
+use fvm_shared::version::NetworkVersion;
+
+use crate::actor_state::actors_version_for_network;
+use crate::error::StateError;
+
+/// Parameters of the v8 actors.
+pub mod v8 {
+    pub use fil_actor_miner_v8::{
+        INITIAL_PLEDGE_FACTOR, INITIAL_PLEDGE_PROJECTION_PERIOD,
+        PRE_COMMIT_DEPOSIT_PROJECTION_PERIOD, TERMINATION_LIFETIME_CAP,
+    };
+    pub use fil_actors_runtime_v8::network::{EPOCHS_IN_DAY, EPOCHS_IN_HOUR, EPOCHS_IN_YEAR};
+    // The v8 runtime shares its policy with v9.
+    pub use fil_actors_runtime_v9::runtime::policy_constants::{
+        CHAIN_FINALITY, MAX_SECTOR_EXPIRATION_EXTENSION, MIN_SECTOR_EXPIRATION,
+        WPOST_CHALLENGE_WINDOW, WPOST_PERIOD_DEADLINES, WPOST_PROVING_PERIOD,
+    };
+    pub use fvm_shared::clock::EPOCH_DURATION_SECONDS;
+}
+
+/// Parameters of the v9 actors.
+pub mod v9 {
+    pub use fil_actor_miner_v9::{
+        INITIAL_PLEDGE_FACTOR, INITIAL_PLEDGE_PROJECTION_PERIOD,
+        PRE_COMMIT_DEPOSIT_PROJECTION_PERIOD, TERMINATION_LIFETIME_CAP,
+    };
+    pub use fil_actors_runtime_v9::network::{EPOCHS_IN_DAY, EPOCHS_IN_HOUR, EPOCHS_IN_YEAR};
+    pub use fil_actors_runtime_v9::runtime::policy_constants::{
+        CHAIN_FINALITY, MAX_SECTOR_EXPIRATION_EXTENSION, MIN_SECTOR_EXPIRATION,
+        WPOST_CHALLENGE_WINDOW, WPOST_PERIOD_DEADLINES, WPOST_PROVING_PERIOD,
+    };
+    pub use fvm_shared::clock::EPOCH_DURATION_SECONDS;
+}
+
+/// The parameters of [`v8`] or [`v9`] in force at a network version, for helpers that take
+/// several of them.
+#[derive(Clone, Copy, Debug, PartialEq, Eq)]
+pub struct NetworkParams {
+    pub actors_version: u32,
+    pub epoch_duration_seconds: i64,
+    pub wpost_proving_period: ChainEpoch,
+    pub wpost_challenge_window: ChainEpoch,
+    pub wpost_period_deadlines: u64,
+    pub chain_finality: ChainEpoch,
+    pub min_sector_expiration: ChainEpoch,
+    pub max_sector_expiration_extension: ChainEpoch,
+    /// Days of projected block reward taken as the storage pledge of a sector.
+    pub initial_pledge_factor: u64,
+    /// Epochs of projected block reward taken as a pre-commit deposit.
+    pub pre_commit_deposit_projection_period: ChainEpoch,
+    /// Epochs of projected block reward taken as the storage pledge of a sector.
+    pub initial_pledge_projection_period: ChainEpoch,
+    /// Maximum age in days of a sector counted towards its termination fee.
+    pub termination_lifetime_cap: ChainEpoch,
+}
+
+macro_rules! network_params {
+    ($version:expr, $consts:ident) => {
+        NetworkParams {
+            actors_version: $version,
+            epoch_duration_seconds: $consts::EPOCH_DURATION_SECONDS,
+            wpost_proving_period: $consts::WPOST_PROVING_PERIOD,
+            wpost_challenge_window: $consts::WPOST_CHALLENGE_WINDOW,
+            wpost_period_deadlines: $consts::WPOST_PERIOD_DEADLINES,
+            chain_finality: $consts::CHAIN_FINALITY,
+            min_sector_expiration: $consts::MIN_SECTOR_EXPIRATION,
+            max_sector_expiration_extension: $consts::MAX_SECTOR_EXPIRATION_EXTENSION,
+            initial_pledge_factor: $consts::INITIAL_PLEDGE_FACTOR,
+            pre_commit_deposit_projection_period: $consts::PRE_COMMIT_DEPOSIT_PROJECTION_PERIOD,
+            initial_pledge_projection_period: $consts::INITIAL_PLEDGE_PROJECTION_PERIOD,
+            termination_lifetime_cap: $consts::TERMINATION_LIFETIME_CAP,
+        }
+    };
+}
+
+impl NetworkParams {
+    /// Returns the parameters of the actors version the network runs at `nv`, which must be
+    /// one shipped in this workspace.
+    pub fn for_version(nv: NetworkVersion) -> Result<Self, StateError> {
+        match actors_version_for_network(nv)? {
+            8 => Ok(network_params!(8, v8)),
+            9 => Ok(network_params!(9, v9)),
+            v => Err(StateError::UnknownVersion(v)),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use fil_actors_runtime_v9::runtime::Policy;
+
+    use super::*;
+
+    #[test]
+    fn v9_matches_spec() {
+        assert_eq!(30, v9::EPOCH_DURATION_SECONDS);
+        assert_eq!(2880, v9::EPOCHS_IN_DAY);
+        assert_eq!(2880, v9::WPOST_PROVING_PERIOD);
+        assert_eq!(60, v9::WPOST_CHALLENGE_WINDOW);
+        assert_eq!(48, v9::WPOST_PERIOD_DEADLINES);
+        assert_eq!(900, v9::CHAIN_FINALITY);
+        assert_eq!(180 * 2880, v9::MIN_SECTOR_EXPIRATION);
+        assert_eq!(540 * 2880, v9::MAX_SECTOR_EXPIRATION_EXTENSION);
+    }
+
+    #[test]
+    fn network_params_for_version() {
+        // Mainnet has run actors v9 since network version 17.
+        let params = NetworkParams::for_version(NetworkVersion::V17).unwrap();
+        assert_eq!(9, params.actors_version);
+        assert_eq!(30, params.epoch_duration_seconds);
+        assert_eq!(2880, params.wpost_proving_period);
+        assert_eq!(60, params.wpost_challenge_window);
+        assert_eq!(48, params.wpost_period_deadlines);
+        assert_eq!(900, params.chain_finality);
+        assert_eq!(20, params.initial_pledge_factor);
+        assert_eq!(20 * 2880, params.pre_commit_deposit_projection_period);
+        assert_eq!(20 * 2880, params.initial_pledge_projection_period);
+        assert_eq!(140, params.termination_lifetime_cap);
+
+        let v8 = NetworkParams::for_version(NetworkVersion::V16).unwrap();
+        assert_eq!(8, v8.actors_version);
+        assert_eq!(
+            NetworkParams {
+                actors_version: 9,
+                ..v8
+            },
+            params
+        );
+        assert!(matches!(
+            NetworkParams::for_version(NetworkVersion::V15),
+            Err(StateError::UnknownVersion(7))
+        ));
+    }
+
+    #[test]
+    fn v9_matches_mainnet_policy() {
+        let policy = Policy::mainnet();
+        assert_eq!(policy.wpost_proving_period, v9::WPOST_PROVING_PERIOD);
+        assert_eq!(policy.wpost_challenge_window, v9::WPOST_CHALLENGE_WINDOW);
+        assert_eq!(policy.wpost_period_deadlines, v9::WPOST_PERIOD_DEADLINES);
+        assert_eq!(policy.min_sector_expiration, v9::MIN_SECTOR_EXPIRATION);
+        assert_eq!(
+            policy.max_sector_expiration_extension,
+            v9::MAX_SECTOR_EXPIRATION_EXTENSION
+        );
+    }
+}
@@ -0,0 +1,230 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::collections::HashSet;
+
+use anyhow::anyhow;
+use cid::multihash::{Code, MultihashDigest};
+use cid::Cid;
+use fvm_ipld_blockstore::Blockstore;
+use fvm_ipld_encoding::{from_slice, to_vec, DAG_CBOR};
+use libipld_core::ipld::Ipld;
+use serde::Serialize;
+
+/// Walks the DAG of blocks linked from an actor state.
+pub trait StateDagExt: Serialize {
+    /// Returns the size in bytes of the encoded state and of every distinct block reachable from
+    /// it, such as the nodes of its HAMTs and AMTs.
+    ///
+    /// Each block is counted once however many times it is linked, but blocks shared with other
+    /// actors' states (the empty HAMT, for one) are counted in each, so summing the estimates of
+    /// several actors overstates their combined footprint.
+    fn estimate_serialized_size<BS: Blockstore>(&self, store: &BS) -> anyhow::Result<u64> {
+        let state = to_vec(self)?;
+        let mut size = state.len() as u64;
+        for_each_linked_block(store, &from_slice(&state)?, |_, block| {
+            size += block.len() as u64;
+            Ok(())
+        })?;
+        Ok(size)
+    }
+
+    /// Returns the CID of the encoded state followed by that of every distinct block reachable
+    /// from it, each once, which is the set of blocks a snapshot must include for the state.
+    fn referenced_cids<BS: Blockstore>(&self, store: &BS) -> anyhow::Result<Vec<Cid>> {
+        let state = to_vec(self)?;
+        let mut cids = vec![Cid::new_v1(DAG_CBOR, Code::Blake2b256.digest(&state))];
+        for_each_linked_block(store, &from_slice(&state)?, |cid, _| {
+            cids.push(*cid);
+            Ok(())
+        })?;
+        Ok(cids)
+    }
+}
+
+macro_rules! impl_state_dag_ext {
+    ($($state:ty),+ $(,)?) => {
+        $(impl StateDagExt for $state {})+
+    };
+}
+
+impl_state_dag_ext!(
+    fil_actor_account_v8::State,
+    fil_actor_account_v9::State,
+    fil_actor_cron_v8::State,
+    fil_actor_cron_v9::State,
+    fil_actor_init_v8::State,
+    fil_actor_init_v9::State,
+    fil_actor_market_v8::State,
+    fil_actor_market_v9::State,
+    fil_actor_miner_v8::State,
+    fil_actor_miner_v9::State,
+    fil_actor_multisig_v8::State,
+    fil_actor_multisig_v9::State,
+    fil_actor_paych_v8::State,
+    fil_actor_paych_v9::State,
+    fil_actor_power_v8::State,
+    fil_actor_power_v9::State,
+    fil_actor_reward_v8::State,
+    fil_actor_reward_v9::State,
+    fil_actor_system_v8::State,
+    fil_actor_system_v9::State,
+    fil_actor_verifreg_v8::State,
+    fil_actor_verifreg_v9::State,
+);
+
+/// Calls `f` once with every distinct block reachable through the links in `value`.
+///
+/// Only DAG-CBOR links are followed. Links of other codecs in actor state, such as sealed sector
+/// and piece commitments, do not refer to blocks in the store.
+fn for_each_linked_block<BS, F>(store: &BS, value: &Ipld, mut f: F) -> anyhow::Result<()>
+where
+    BS: Blockstore,
+    F: FnMut(&Cid, &[u8]) -> anyhow::Result<()>,
+{
+    let mut seen = HashSet::new();
+    let mut pending = Vec::new();
+    push_links(value, &mut pending);
+    while let Some(cid) = pending.pop() {
+        if cid.codec() != DAG_CBOR || !seen.insert(cid) {
+            continue;
+        }
+        let block = store
+            .get(&cid)?
+            .ok_or_else(|| anyhow!("block {} not found", cid))?;
+        f(&cid, &block)?;
+        push_links(&from_slice(&block)?, &mut pending);
+    }
+    Ok(())
+}
+
+fn push_links(value: &Ipld, links: &mut Vec<Cid>) {
+    match value {
+        Ipld::Link(cid) => links.push(*cid),
+        Ipld::List(values) => values.iter().for_each(|v| push_links(v, links)),
+        Ipld::Map(entries) => entries.values().for_each(|v| push_links(v, links)),
+        _ => {}
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::BTreeSet;
+
+    use fil_actor_market_v9::{DealArray, DealProposal, Label, State};
+    use fil_actors_runtime_v9::runtime::Policy;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_ipld_encoding::CborStore;
+    use fvm_shared::address::Address;
+    use fvm_shared::commcid::data_commitment_v1_to_cid;
+    use fvm_shared::econ::TokenAmount;
+    use fvm_shared::piece::PaddedPieceSize;
+
+    use super::*;
+
+    #[test]
+    fn market_state_size() {
+        let store = MemoryBlockstore::default();
+        let mut st = State::new(&store).unwrap();
+        let mut proposals = DealArray::load(&st.proposals, &store).unwrap();
+        proposals
+            .set(
+                0,
+                DealProposal {
+                    // A piece commitment, which is not a block in the store.
+                    piece_cid: data_commitment_v1_to_cid(&[1; 32]).unwrap(),
+                    piece_size: PaddedPieceSize(2048),
+                    verified_deal: false,
+                    client: Address::new_id(100),
+                    provider: Address::new_id(101),
+                    label: Label::String("label".to_string()),
+                    start_epoch: 10,
+                    end_epoch: 1000,
+                    storage_price_per_epoch: TokenAmount::from_atto(1),
+                    provider_collateral: TokenAmount::from_atto(2),
+                    client_collateral: TokenAmount::from_atto(3),
+                },
+            )
+            .unwrap();
+        st.proposals = proposals.flush().unwrap();
+
+        // Each field is a single block, and several share the empty HAMT or AMT.
+        let blocks: BTreeSet<_> = [
+            st.proposals,
+            st.states,
+            st.pending_proposals,
+            st.escrow_table,
+            st.locked_table,
+            st.deal_ops_by_epoch,
+        ]
+        .into_iter()
+        .collect();
+        assert!(blocks.len() < 6);
+        let expected = to_vec(&st).unwrap().len()
+            + blocks
+                .iter()
+                .map(|c| store.get(c).unwrap().unwrap().len())
+                .sum::<usize>();
+        assert_eq!(
+            expected as u64,
+            st.estimate_serialized_size(&store).unwrap()
+        );
+
+        // A missing block is an error.
+        let empty = MemoryBlockstore::default();
+        assert!(st.estimate_serialized_size(&empty).is_err());
+    }
+
+    #[test]
+    fn market_state_referenced_cids() {
+        let store = MemoryBlockstore::default();
+        let st = State::new(&store).unwrap();
+        let root = store.put_cbor(&st, Code::Blake2b256).unwrap();
+
+        let cids = st.referenced_cids(&store).unwrap();
+        assert_eq!(root, cids[0]);
+        let expected: BTreeSet<_> = [
+            root,
+            st.proposals,
+            st.states,
+            st.pending_proposals,
+            st.escrow_table,
+            st.locked_table,
+            st.deal_ops_by_epoch,
+        ]
+        .into_iter()
+        .collect();
+        assert_eq!(expected.len(), cids.len());
+        assert_eq!(expected, cids.into_iter().collect());
+    }
+
+    #[test]
+    fn miner_state_referenced_cids() {
+        let policy = Policy::default();
+        let store = MemoryBlockstore::default();
+        let info = store.put_cbor(&[0_u8; 0], Code::Blake2b256).unwrap();
+        let st = fil_actor_miner_v9::State::new(&policy, &store, info, 0, 0).unwrap();
+        let cids = st.referenced_cids(&store).unwrap();
+
+        // Every deadline starts out as the same empty deadline, linking empty collections.
+        let deadlines: fil_actor_miner_v9::Deadlines =
+            store.get_cbor(&st.deadlines).unwrap().unwrap();
+        let deadline: fil_actor_miner_v9::Deadline =
+            store.get_cbor(&deadlines.due[0]).unwrap().unwrap();
+        assert!(deadlines.due.iter().all(|c| *c == deadlines.due[0]));
+        for cid in [
+            info,
+            st.vesting_funds,
+            st.allocated_sectors,
+            st.sectors,
+            st.deadlines,
+            deadlines.due[0],
+            deadline.partitions,
+            deadline.expirations_epochs,
+        ] {
+            assert!(cids.contains(&cid), "{}", cid);
+        }
+        let distinct: BTreeSet<_> = cids.iter().collect();
+        assert_eq!(distinct.len(), cids.len());
+    }
+}
@@ -0,0 +1,216 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! State of the datacap actor, introduced with actors v9, which holds verified clients' datacap
+//! as an FRC-46 token. No actor crate in this workspace provides it.
+
+use anyhow::anyhow;
+use cid::Cid;
+use fil_actors_runtime_v9::fvm_ipld_hamt::BytesKey;
+use fil_actors_runtime_v9::{make_map_with_root_and_bitwidth, parse_uint_key, u64_key, Map};
+use fvm_ipld_blockstore::Blockstore;
+use fvm_ipld_encoding::tuple::*;
+use fvm_shared::address::Address;
+use fvm_shared::econ::TokenAmount;
+use fvm_shared::ActorID;
+
+/// The FRC-46 granularity of datacap, in atto units: datacap is only minted, transferred and
+/// burned in whole tokens, each standing for one byte of verified storage.
+pub const DATACAP_GRANULARITY: u64 = TokenAmount::PRECISION;
+
+/// The key of an actor in the FRC-46 balances and allowances HAMTs: its ID as an unsigned
+/// varint.
+pub fn actor_id_key(id: ActorID) -> BytesKey {
+    u64_key(id)
+}
+
+/// The state of an FRC-46 token, as kept by the datacap actor.
+#[derive(Clone, Debug, PartialEq, Eq, Serialize_tuple, Deserialize_tuple)]
+pub struct TokenState {
+    /// Total supply as recorded by the token.
+    pub supply: TokenAmount,
+    /// HAMT of balances, keyed by the owner's actor ID.
+    pub balances: Cid,
+    /// HAMT of allowances, keyed by owner and then by operator.
+    pub allowances: Cid,
+    pub hamt_bit_width: u32,
+}
+
+#[derive(Clone, Debug, PartialEq, Eq, Serialize_tuple, Deserialize_tuple)]
+pub struct DatacapState {
+    /// The verified registry actor, which alone may mint and destroy datacap.
+    pub governor: Address,
+    pub token: TokenState,
+}
+
+impl DatacapState {
+    fn load_balances<'bs, BS: Blockstore>(
+        &self,
+        store: &'bs BS,
+    ) -> anyhow::Result<Map<'bs, BS, TokenAmount>> {
+        make_map_with_root_and_bitwidth(&self.token.balances, store, self.token.hamt_bit_width)
+            .map_err(|e| anyhow!("failed to load datacap balances: {}", e))
+    }
+
+    /// Returns the datacap held by `addr`, which must be an ID address, or zero if it holds
+    /// none.
+    pub fn balance<BS: Blockstore>(
+        &self,
+        store: &BS,
+        addr: &Address,
+    ) -> anyhow::Result<TokenAmount> {
+        let id = addr
+            .id()
+            .map_err(|_| anyhow!("datacap balances are keyed by actor ID, not {}", addr))?;
+        let balance = self
+            .load_balances(store)?
+            .get(&actor_id_key(id))
+            .map_err(|e| anyhow!("failed to get datacap balance of {}: {}", addr, e))?;
+        Ok(balance.cloned().unwrap_or_default())
+    }
+
+    /// Iterates over the actors holding datacap and their balances, without collecting them.
+    pub fn balances_iter<BS, F>(&self, store: &BS, mut f: F) -> anyhow::Result<()>
+    where
+        BS: Blockstore,
+        F: FnMut(ActorID, &TokenAmount) -> anyhow::Result<()>,
+    {
+        self.load_balances(store)?
+            .for_each(|key, balance| {
+                let id = parse_uint_key(&key.0)?;
+                if actor_id_key(id) != *key {
+                    return Err(anyhow!("malformed datacap balance key {:?}", key));
+                }
+                f(id, balance)
+            })
+            .map_err(|e| anyhow!("failed to iterate datacap balances: {}", e))
+    }
+
+    /// Returns the datacap in circulation, the sum of all balances. This walks the balances
+    /// HAMT rather than trusting the supply the token records.
+    pub fn total_supply<BS: Blockstore>(&self, store: &BS) -> anyhow::Result<TokenAmount> {
+        let mut total = TokenAmount::default();
+        self.balances_iter(store, |_, balance| {
+            total += balance;
+            Ok(())
+        })?;
+        Ok(total)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use cid::multihash::Code;
+    use fil_actors_runtime_v9::make_empty_map;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_ipld_encoding::CborStore;
+    use fvm_shared::HAMT_BIT_WIDTH;
+
+    use super::*;
+
+    #[test]
+    fn datacap_total_supply() {
+        let store = MemoryBlockstore::default();
+        let mut balances = make_empty_map::<_, TokenAmount>(&store, HAMT_BIT_WIDTH);
+        let empty = balances.flush().unwrap();
+        for (id, bytes) in [(1000, 1_u64 << 40), (1001, 2048), (1002, 1)] {
+            balances
+                .set(actor_id_key(id), TokenAmount::from_whole(bytes))
+                .unwrap();
+        }
+        let st = DatacapState {
+            governor: Address::new_id(6),
+            token: TokenState {
+                supply: TokenAmount::from_whole((1_u64 << 40) + 2049),
+                balances: balances.flush().unwrap(),
+                allowances: empty,
+                hamt_bit_width: HAMT_BIT_WIDTH,
+            },
+        };
+        let root = store.put_cbor(&st, Code::Blake2b256).unwrap();
+        let st: DatacapState = store.get_cbor(&root).unwrap().unwrap();
+        assert_eq!(st.token.supply, st.total_supply(&store).unwrap());
+
+        let none = DatacapState {
+            token: TokenState {
+                balances: empty,
+                ..st.token.clone()
+            },
+            ..st
+        };
+        assert_eq!(TokenAmount::default(), none.total_supply(&store).unwrap());
+    }
+
+    #[test]
+    fn datacap_balances() {
+        let store = MemoryBlockstore::default();
+        let verifreg = Address::new_id(6);
+        let clients = [Address::new_id(1000), Address::new_id(1001)];
+        let mut balances = make_empty_map::<_, TokenAmount>(&store, HAMT_BIT_WIDTH);
+        let empty = balances.flush().unwrap();
+        for (addr, bytes) in [(verifreg, 1_u64 << 35), (clients[0], 2048), (clients[1], 1)] {
+            balances
+                .set(
+                    actor_id_key(addr.id().unwrap()),
+                    TokenAmount::from_whole(bytes),
+                )
+                .unwrap();
+        }
+        let st = DatacapState {
+            governor: verifreg,
+            token: TokenState {
+                supply: TokenAmount::from_whole((1_u64 << 35) + 2049),
+                balances: balances.flush().unwrap(),
+                allowances: empty,
+                hamt_bit_width: HAMT_BIT_WIDTH,
+            },
+        };
+
+        // Keys are the actor ID as an unsigned varint, as the FRC-46 token writes them.
+        assert_eq!(BytesKey(vec![0xe8, 0x07]), actor_id_key(1000));
+        assert_eq!(
+            TokenAmount::from_whole(1_u64 << 35),
+            st.balance(&store, &verifreg).unwrap()
+        );
+        assert_eq!(
+            TokenAmount::from_whole(2048),
+            st.balance(&store, &clients[0]).unwrap()
+        );
+        assert_eq!(
+            TokenAmount::from_whole(1),
+            st.balance(&store, &clients[1]).unwrap()
+        );
+        assert_eq!(
+            TokenAmount::default(),
+            st.balance(&store, &Address::new_id(1002)).unwrap()
+        );
+        assert!(st.balance(&store, &Address::new_actor(b"robust")).is_err());
+
+        let mut all = Vec::new();
+        st.balances_iter(&store, |id, balance| {
+            all.push((id, balance.clone()));
+            Ok(())
+        })
+        .unwrap();
+        all.sort_by_key(|(id, _)| *id);
+        assert_eq!(
+            vec![
+                (6, TokenAmount::from_whole(1_u64 << 35)),
+                (1000, TokenAmount::from_whole(2048)),
+                (1001, TokenAmount::from_whole(1)),
+            ],
+            all
+        );
+    }
+
+    #[test]
+    fn datacap_granularity() {
+        // FRC-46 requires every amount to be a multiple of the granularity, and the datacap
+        // actor sets it to one whole token.
+        assert_eq!(1_000_000_000_000_000_000, DATACAP_GRANULARITY);
+        assert_eq!(
+            TokenAmount::from_whole(1),
+            TokenAmount::from_atto(DATACAP_GRANULARITY)
+        );
+    }
+}
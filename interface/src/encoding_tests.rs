@@ -0,0 +1,312 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! Deterministic encoding of actor state: decoding a canonical encoding of each state type and
+//! re-encoding it must reproduce the input bytes.
+
+use cid::multihash::{Code, MultihashDigest};
+use cid::Cid;
+use fvm_ipld_blockstore::MemoryBlockstore;
+use fvm_ipld_encoding::{from_slice, to_vec, CborStore, DAG_CBOR};
+use fvm_shared::address::Address;
+use fvm_shared::bigint::BigInt;
+use fvm_shared::econ::TokenAmount;
+use fvm_shared::sector::{RegisteredPoStProof, SectorSize, StoragePower};
+use serde::de::DeserializeOwned;
+use serde::Serialize;
+
+/// Decodes `bytes` as a `T` and asserts that it re-encodes to the same bytes.
+fn assert_round_trip<T: Serialize + DeserializeOwned>(name: &str, bytes: &[u8]) {
+    let decoded: T = from_slice(bytes).unwrap_or_else(|e| panic!("{}: {}", name, e));
+    assert_eq!(
+        hex::encode(bytes),
+        hex::encode(to_vec(&decoded).unwrap()),
+        "{} did not re-encode to its input",
+        name
+    );
+}
+
+fn round_trip<T: Serialize + DeserializeOwned>(name: &str, value: &T) {
+    assert_round_trip::<T>(name, &to_vec(value).unwrap());
+}
+
+macro_rules! check_round_trips {
+    ($test:ident, $runtime:ident, $miner:ident, $market:ident, $power:ident, $verifreg:ident, $multisig:ident) => {
+        #[test]
+        fn $test() {
+            let store = MemoryBlockstore::default();
+            let policy = $runtime::runtime::Policy::default();
+
+            let info = $miner::MinerInfo::new(
+                Address::new_id(100),
+                Address::new_id(101),
+                vec![Address::new_id(102), Address::new_id(103)],
+                b"peer".to_vec(),
+                vec![],
+                RegisteredPoStProof::StackedDRGWindow32GiBV1,
+            )
+            .unwrap();
+            round_trip("miner info", &info);
+            let info = store.put_cbor(&info, Code::Blake2b256).unwrap();
+            let mut miner = $miner::State::new(&policy, &store, info, 1234, 5).unwrap();
+            miner.initial_pledge = TokenAmount::from_atto(10u128.pow(18));
+            miner.fee_debt = TokenAmount::from_atto(1);
+            round_trip("miner", &miner);
+            let deadlines: $miner::Deadlines = store.get_cbor(&miner.deadlines).unwrap().unwrap();
+            round_trip("miner deadlines", &deadlines);
+
+            let mut market = $market::State::new(&store).unwrap();
+            market.next_id = 42;
+            market.total_client_locked_collateral = TokenAmount::from_atto(7);
+            round_trip("market", &market);
+
+            let mut power = $power::State::new(&store).unwrap();
+            power.miner_count = 3;
+            power.total_pledge_collateral = TokenAmount::from_atto(u64::MAX);
+            round_trip("power", &power);
+
+            let verifreg = $verifreg::State::new(&store, Address::new_id(80)).unwrap();
+            round_trip("verifreg", &verifreg);
+
+            let multisig = $multisig::State {
+                signers: vec![Address::new_id(100), Address::new_id(101)],
+                num_approvals_threshold: 2,
+                next_tx_id: $multisig::TxnID(3),
+                initial_balance: TokenAmount::from_atto(10u128.pow(19)),
+                start_epoch: -1,
+                unlock_duration: 1000,
+                pending_txs: market.proposals,
+            };
+            round_trip("multisig", &multisig);
+        }
+    };
+}
+
+check_round_trips!(
+    round_trips_v8,
+    fil_actors_runtime_v8,
+    fil_actor_miner_v8,
+    fil_actor_market_v8,
+    fil_actor_power_v8,
+    fil_actor_verifreg_v8,
+    fil_actor_multisig_v8
+);
+check_round_trips!(
+    round_trips_v9,
+    fil_actors_runtime_v9,
+    fil_actor_miner_v9,
+    fil_actor_market_v9,
+    fil_actor_power_v9,
+    fil_actor_verifreg_v9,
+    fil_actor_multisig_v9
+);
+
+#[test]
+fn multisig_fixture_round_trip() {
+    // Hand-encoded multisig state: signers f0100 and f0101, threshold 2, next txn 3, 10 FIL
+    // vesting from epoch 100 over 1000 epochs, and the empty HAMT as pending transactions.
+    let fixture = hex::decode(concat!(
+        "8782420064420065020349008ac7230489e8000018641903e8",
+        "d82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0c",
+    ))
+    .unwrap();
+    assert_round_trip::<fil_actor_multisig_v8::State>("multisig v8", &fixture);
+    assert_round_trip::<fil_actor_multisig_v9::State>("multisig v9", &fixture);
+    let st: fil_actor_multisig_v9::State = from_slice(&fixture).unwrap();
+    assert_eq!(TokenAmount::from_atto(10u128.pow(19)), st.initial_balance);
+    assert_eq!(1000, st.unlock_duration);
+}
+
+// The fixtures below are DAG-CBOR blocks encoded by hand, field by field, from the go-state-types
+// layout of each state. Links point at the empty HAMT root, empty AMT roots or, where noted, a
+// placeholder block; decoding a state does not follow them.
+
+/// f0100 owns, f0101 works and f0102, f0103 control, with a key change to f0104 pending at
+/// epoch 2000, peer ID "peer", one multiaddr, 32GiB window PoSt and an owner change to f0105.
+const MINER_INFO_V8: &str = concat!(
+    "8b42006442006582420066420067824200681907d0447065657281480401020304060fa1081b000000080000",
+    "000019092d20420069",
+);
+
+/// As v8 without pending changes, plus beneficiary f0106 with a 100 FIL quota, 25 of it used,
+/// and a change to f0107 approved only by the current beneficiary.
+const MINER_INFO_V9: &str = concat!(
+    "8e42006442006582420066420067f6447065657280081b000000080000000019092d20f642006a834a00056b",
+    "c75e2d631000004a00015af1d78b58c400001a002dc6c08542006b4a0002b5e3af16b18800001a003d0900f5",
+    "f4",
+);
+
+/// Info links to [`MINER_INFO_V9`] and deadlines to a placeholder block.
+const MINER: &str = concat!(
+    "8fd82a5827000171a0e40220f30ea3d1158c17eed2da175c4908a696361f667ff661a30585d382703abeb52c",
+    "49001bc16d674ec80000490006f05b59d3b20000d82a5827000171a0e4022016187a5f7cc741f8d578b58af7",
+    "8e7d9d36892b826ecc8750d45a9bd7fddc6c3142000149000de0b6b3a7640000d82a5827000171a0e4022018",
+    "fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0cd82a5827000171a0e4022001a4",
+    "b71f328c3b3503e2a8b1829e0b6a6c4a5d0cb2ee531ea215168aa3a7582dd82a5827000171a0e4022039df02",
+    "4ac52722fe8ae4c1a8740e4c5624a38c3820e504a059aae8728421f8bdd82a5827000171a0e40220054de1cd",
+    "03c0741eec69f34aabfec51f64b304c307a5f5beb965d94fba91d9e01904d205d82a5827000171a0e40220cb",
+    "ecfcb581a5601406550bebd2c1b790f883ed057b46e17990c7ee498148dd7040f5",
+);
+
+/// Next deal 42, last cron at epoch -1, and non-zero locked collateral and storage fee.
+const MARKET_V8: &str = concat!(
+    "8bd82a5827000171a0e40220054de1cd03c0741eec69f34aabfec51f64b304c307a5f5beb965d94fba91d9e0",
+    "d82a5827000171a0e4022001a4b71f328c3b3503e2a8b1829e0b6a6c4a5d0cb2ee531ea215168aa3a7582dd8",
+    "2a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0cd82a",
+    "5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0cd82a58",
+    "27000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0c182ad82a",
+    "5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0c204200",
+    "0749000de0b6b3a76400004500075bcd15",
+);
+
+/// [`MARKET_V8`] followed by the pending deal allocation IDs HAMT.
+const MARKET_V9: &str = concat!(
+    "8cd82a5827000171a0e40220054de1cd03c0741eec69f34aabfec51f64b304c307a5f5beb965d94fba91d9e0",
+    "d82a5827000171a0e4022001a4b71f328c3b3503e2a8b1829e0b6a6c4a5d0cb2ee531ea215168aa3a7582dd8",
+    "2a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0cd82a",
+    "5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0cd82a58",
+    "27000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0c182ad82a",
+    "5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a0c204200",
+    "0749000de0b6b3a76400004500075bcd15d82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b",
+    "812bf2ca9b528050909c78d408558a0c",
+);
+
+/// 10 32GiB sectors of raw power, 25 of QA power, and a smoothed estimate with negative velocity.
+const POWER: &str = concat!(
+    "8f46005000000000460050000000004600c8000000004600c8000000004900ffffffffffffffff4600480000",
+    "00004600a00000000049000de0b6b3a76400008256002800000000000000000000000000000000000000004e",
+    "01300000000000000000000000000301d82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b81",
+    "2bf2ca9b528050909c78d408558a0c20d82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b81",
+    "2bf2ca9b528050909c78d408558a0cf6",
+);
+
+/// All-zero powers, and a pending proof validation batch.
+const POWER_BATCH: &str = concat!(
+    "8f40404040404040408240400000d82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2",
+    "ca9b528050909c78d408558a0c1864d82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812b",
+    "f2ca9b528050909c78d408558a0cd82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2",
+    "ca9b528050909c78d408558a0c",
+);
+
+/// Root key f080 and empty verifier, client and removal proposal HAMTs.
+const VERIFREG_V8: &str = concat!(
+    "84420050d82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408",
+    "558a0cd82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d40855",
+    "8a0cd82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a",
+    "0c",
+);
+
+/// Root key f080, next allocation 17, and empty HAMTs.
+const VERIFREG_V9: &str = concat!(
+    "86420050d82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408",
+    "558a0cd82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d40855",
+    "8a0cd82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a",
+    "0c11d82a5827000171a0e4022018fe6acc61a3a36b0c373c4a3a8ea64b812bf2ca9b528050909c78d408558a",
+    "0c",
+);
+
+#[test]
+fn miner_info_fixture_round_trip() {
+    let v8 = hex::decode(MINER_INFO_V8).unwrap();
+    assert_round_trip::<fil_actor_miner_v8::MinerInfo>("miner info v8", &v8);
+    let info: fil_actor_miner_v8::MinerInfo = from_slice(&v8).unwrap();
+    let pending = info.pending_worker_key.unwrap();
+    assert_eq!(
+        (Address::new_id(104), 2000),
+        (pending.new_worker, pending.effective_at)
+    );
+    assert_eq!(SectorSize::_32GiB, info.sector_size);
+    assert_eq!(2349, info.window_post_partition_sectors);
+    assert_eq!(Some(Address::new_id(105)), info.pending_owner_address);
+
+    let v9 = hex::decode(MINER_INFO_V9).unwrap();
+    assert_round_trip::<fil_actor_miner_v9::MinerInfo>("miner info v9", &v9);
+    let info: fil_actor_miner_v9::MinerInfo = from_slice(&v9).unwrap();
+    assert_eq!(Address::new_id(106), info.beneficiary);
+    assert_eq!(TokenAmount::from_whole(100), info.beneficiary_term.quota);
+    assert_eq!(
+        TokenAmount::from_whole(25),
+        info.beneficiary_term.used_quota
+    );
+    let pending = info.pending_beneficiary_term.unwrap();
+    assert_eq!(Address::new_id(107), pending.new_beneficiary);
+    assert!(pending.approved_by_beneficiary && !pending.approved_by_nominee);
+}
+
+#[test]
+fn miner_state_fixture_round_trip() {
+    let fixture = hex::decode(MINER).unwrap();
+    assert_round_trip::<fil_actor_miner_v8::State>("miner v8", &fixture);
+    assert_round_trip::<fil_actor_miner_v9::State>("miner v9", &fixture);
+    let st: fil_actor_miner_v9::State = from_slice(&fixture).unwrap();
+    let info = hex::decode(MINER_INFO_V9).unwrap();
+    assert_eq!(
+        Cid::new_v1(DAG_CBOR, Code::Blake2b256.digest(&info)),
+        st.info
+    );
+    assert_eq!(TokenAmount::from_whole(2), st.pre_commit_deposits);
+    assert_eq!(TokenAmount::from_atto(1), st.fee_debt);
+    assert_eq!((1234, 5), (st.proving_period_start, st.current_deadline));
+    assert!(st.early_terminations.is_empty());
+    assert!(st.deadline_cron_active);
+}
+
+#[test]
+fn market_fixture_round_trip() {
+    let v8 = hex::decode(MARKET_V8).unwrap();
+    assert_round_trip::<fil_actor_market_v8::State>("market v8", &v8);
+    let st: fil_actor_market_v8::State = from_slice(&v8).unwrap();
+    assert_eq!((42, -1), (st.next_id, st.last_cron));
+    assert_eq!(TokenAmount::from_atto(7), st.total_client_locked_collateral);
+    assert_eq!(
+        TokenAmount::from_atto(123_456_789),
+        st.total_client_storage_fee
+    );
+
+    let v9 = hex::decode(MARKET_V9).unwrap();
+    assert_eq!(&v8[1..], &v9[1..v8.len()]);
+    assert_round_trip::<fil_actor_market_v9::State>("market v9", &v9);
+    assert!(from_slice::<fil_actor_market_v9::State>(&v8).is_err());
+}
+
+#[test]
+fn power_fixture_round_trip() {
+    let fixture = hex::decode(POWER).unwrap();
+    assert_round_trip::<fil_actor_power_v8::State>("power v8", &fixture);
+    assert_round_trip::<fil_actor_power_v9::State>("power v9", &fixture);
+    let st: fil_actor_power_v9::State = from_slice(&fixture).unwrap();
+    let sector = StoragePower::from(SectorSize::_32GiB as u64);
+    assert_eq!(&sector * 10, st.total_raw_byte_power);
+    assert_eq!(&sector * 25, st.total_quality_adj_power);
+    assert_eq!(TokenAmount::from_atto(u64::MAX), st.total_pledge_collateral);
+    assert_eq!(
+        -(BigInt::from(3) << 100),
+        st.this_epoch_qa_power_smoothed.velocity
+    );
+    assert_eq!((3, 1), (st.miner_count, st.miner_above_min_power_count));
+    assert_eq!(None, st.proof_validation_batch);
+
+    let fixture = hex::decode(POWER_BATCH).unwrap();
+    assert_round_trip::<fil_actor_power_v8::State>("power v8 batch", &fixture);
+    assert_round_trip::<fil_actor_power_v9::State>("power v9 batch", &fixture);
+    let st: fil_actor_power_v8::State = from_slice(&fixture).unwrap();
+    assert_eq!(StoragePower::default(), st.total_raw_byte_power);
+    assert_eq!(Some(st.claims), st.proof_validation_batch);
+}
+
+#[test]
+fn verifreg_fixture_round_trip() {
+    let v8 = hex::decode(VERIFREG_V8).unwrap();
+    assert_round_trip::<fil_actor_verifreg_v8::State>("verifreg v8", &v8);
+    let st: fil_actor_verifreg_v8::State = from_slice(&v8).unwrap();
+    assert_eq!(Address::new_id(80), st.root_key);
+
+    let v9 = hex::decode(VERIFREG_V9).unwrap();
+    assert_round_trip::<fil_actor_verifreg_v9::State>("verifreg v9", &v9);
+    let st: fil_actor_verifreg_v9::State = from_slice(&v9).unwrap();
+    assert_eq!(
+        (Address::new_id(80), 17),
+        (st.root_key, st.next_allocation_id)
+    );
+    assert!(from_slice::<fil_actor_verifreg_v9::State>(&v8).is_err());
+}
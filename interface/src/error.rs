@@ -0,0 +1,50 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use cid::Cid;
+use fvm_ipld_blockstore::Blockstore;
+use serde::de::DeserializeOwned;
+use thiserror::Error;
+
+use crate::ActorVersion;
+
+/// Errors loading actor state, distinguishing blocks missing from the store (which a caller may
+/// fetch and retry) from blocks that cannot be decoded.
+#[derive(Debug, Error)]
+pub enum StateError {
+    /// The block is not in the store.
+    #[error("block {0} not found")]
+    NotFound(Cid),
+    /// The block is in the store but is not a valid encoding of the expected type.
+    #[error("failed to decode block {cid}: {source}")]
+    Decode {
+        cid: Cid,
+        #[source]
+        source: fvm_ipld_encoding::Error,
+    },
+    /// The code CID is not one of the builtin actors of the bundle.
+    #[error("code {0} is not a builtin actor")]
+    UnknownCode(Cid),
+    /// The actors version is not one shipped in this workspace.
+    #[error("unsupported actors version {0}")]
+    UnknownVersion(u32),
+    /// The network version is not one fvm_shared defines.
+    #[error("unknown network version {0}")]
+    UnknownNetworkVersion(u32),
+    /// The actor has no state type for the bundle version.
+    #[error("unsupported builtin actor {name:?} for {version:?}")]
+    UnsupportedVersion { name: String, version: ActorVersion },
+    /// The blockstore failed to read the block.
+    #[error(transparent)]
+    Store(#[from] anyhow::Error),
+}
+
+/// Loads and decodes the CBOR block `cid` from the store.
+pub(crate) fn load_cbor<BS, T>(store: &BS, cid: &Cid) -> Result<T, StateError>
+where
+    BS: Blockstore,
+    T: DeserializeOwned,
+{
+    let block = store.get(cid)?.ok_or(StateError::NotFound(*cid))?;
+    fvm_ipld_encoding::from_slice(&block).map_err(|source| StateError::Decode { cid: *cid, source })
+}
@@ -0,0 +1,143 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use anyhow::anyhow;
+use fil_actors_runtime_v9::fvm_ipld_hamt::BytesKey;
+use fil_actors_runtime_v9::Keyer;
+use fvm_shared::address::Address;
+
+/// Prefix of an Ethereum address that embeds a Filecoin actor ID in its last 8 bytes.
+const MASKED_ID_PREFIX: [u8; 12] = [0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0];
+
+/// ID of the Ethereum address manager actor, the namespace of `f410` delegated addresses.
+pub const EAM_ACTOR_ID: u64 = 10;
+
+/// A 20 byte Ethereum address, as accepted by the Eth JSON-RPC API.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Hash)]
+pub struct EthAddress(pub [u8; 20]);
+
+impl EthAddress {
+    /// Parses a hex encoded address, with or without a `0x` prefix.
+    pub fn from_hex(s: &str) -> anyhow::Result<Self> {
+        let digits = s
+            .strip_prefix("0x")
+            .or_else(|| s.strip_prefix("0X"))
+            .unwrap_or(s);
+        let bytes = hex::decode(digits).map_err(|e| anyhow!("invalid eth address {}: {}", s, e))?;
+        let bytes: [u8; 20] = bytes
+            .try_into()
+            .map_err(|b: Vec<u8>| anyhow!("eth address must be 20 bytes, got {}", b.len()))?;
+        Ok(Self(bytes))
+    }
+
+    /// Returns the actor ID embedded in a masked-ID address (`0xff` followed by 11 zero bytes and
+    /// the big-endian ID), or `None` for any other address.
+    pub fn masked_id(&self) -> Option<u64> {
+        let (prefix, id) = self.0.split_at(MASKED_ID_PREFIX.len());
+        if prefix != MASKED_ID_PREFIX {
+            return None;
+        }
+        Some(u64::from_be_bytes(id.try_into().unwrap()))
+    }
+
+    /// Returns the byte encoding of the `f410` delegated address for this Ethereum address: the
+    /// delegated protocol byte, the varint namespace and the address. This is the key of the
+    /// address in the init actor's address map.
+    pub fn delegated_address_bytes(&self) -> Vec<u8> {
+        // The namespace is below 0x80, so its varint is the single byte.
+        let mut bytes = vec![4, EAM_ACTOR_ID as u8];
+        bytes.extend_from_slice(&self.0);
+        bytes
+    }
+
+    /// Converts the address to the Filecoin address it refers to. A masked-ID address is the `f0`
+    /// ID address of its actor.
+    ///
+    /// Any other address maps to an `f410` delegated address in the Ethereum address manager's
+    /// namespace, which the address type of `fvm_shared` 2 cannot represent, so it is an error.
+    pub fn to_filecoin_address(&self) -> anyhow::Result<Address> {
+        match self.masked_id() {
+            Some(id) => Ok(Address::new_id(id)),
+            None => Err(anyhow!(
+                "eth address 0x{} maps to a delegated address, which is not supported",
+                hex::encode(self.0)
+            )),
+        }
+    }
+}
+
+/// Keys maps by the bytes of the Filecoin address the Ethereum address refers to, as
+/// go-state-types' `abi.IdAddrKey` does for that address: the `f0` ID address of a masked-ID
+/// address, and the `f410` delegated address of any other.
+impl Keyer for EthAddress {
+    fn key(&self) -> BytesKey {
+        match self.masked_id() {
+            Some(id) => Address::new_id(id).to_bytes().into(),
+            None => self.delegated_address_bytes().into(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn masked_id_address() {
+        let addr = EthAddress::from_hex("0xff00000000000000000000000000000000000400").unwrap();
+        assert_eq!(Some(1024), addr.masked_id());
+        assert_eq!(Address::new_id(1024), addr.to_filecoin_address().unwrap());
+
+        let max = EthAddress::from_hex("0xff0000000000000000000000ffffffffffffffff").unwrap();
+        assert_eq!(Some(u64::MAX), max.masked_id());
+    }
+
+    #[test]
+    fn contract_address() {
+        let addr = EthAddress::from_hex("0xd4c5fb16488aa48081296299d54b0c648c9333da").unwrap();
+        assert_eq!(0xd4, addr.0[0]);
+        assert_eq!(0xda, addr.0[19]);
+        assert_eq!(None, addr.masked_id());
+        assert!(addr.to_filecoin_address().is_err());
+
+        // Only the exact masked-ID prefix embeds an ID.
+        let addr = EthAddress::from_hex("ff00000000000000000000010000000000000400").unwrap();
+        assert_eq!(None, addr.masked_id());
+    }
+
+    #[test]
+    fn delegated_address_bytes() {
+        let addr = EthAddress::from_hex("0xd4c5fb16488aa48081296299d54b0c648c9333da").unwrap();
+        assert_eq!(
+            "040ad4c5fb16488aa48081296299d54b0c648c9333da",
+            hex::encode(addr.delegated_address_bytes())
+        );
+        // As abi.IdAddrKey(addr).Key() in go-state-types: the raw address bytes.
+        assert_eq!(
+            "040ad4c5fb16488aa48081296299d54b0c648c9333da",
+            hex::encode(addr.key().0)
+        );
+    }
+
+    #[test]
+    fn key_by_referenced_address() {
+        // A masked-ID address is keyed as the ID address of its actor.
+        let addr = EthAddress::from_hex("0xff00000000000000000000000000000000000400").unwrap();
+        assert_eq!(Address::new_id(1024).key(), addr.key());
+        assert_eq!("008008", hex::encode(addr.key().0));
+
+        // Any other address by its delegated address.
+        let addr = EthAddress::from_hex("0xd4c5fb16488aa48081296299d54b0c648c9333da").unwrap();
+        assert_eq!(BytesKey(addr.delegated_address_bytes()), addr.key());
+    }
+
+    #[test]
+    fn invalid_address() {
+        // 19 bytes.
+        assert!(EthAddress::from_hex("0xd4c5fb16488aa48081296299d54b0c648c9333").is_err());
+        // 21 bytes.
+        assert!(EthAddress::from_hex("0xd4c5fb16488aa48081296299d54b0c648c9333da00").is_err());
+        assert!(EthAddress::from_hex("0xz4c5fb16488aa48081296299d54b0c648c9333da").is_err());
+        assert!(EthAddress::from_hex("").is_err());
+    }
+}
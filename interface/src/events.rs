@@ -0,0 +1,187 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! Actor events, which are not part of actor state but recorded in an AMT referenced from the
+//! receipt of the message that emitted them.
+
+use anyhow::anyhow;
+use cid::Cid;
+use fil_actors_runtime_v9::Array;
+use fvm_ipld_blockstore::Blockstore;
+use fvm_ipld_encoding::serde_bytes;
+use fvm_ipld_encoding::tuple::*;
+use fvm_shared::ActorID;
+
+/// The entry's key is indexed, so events can be queried by it.
+pub const FLAG_INDEXED_KEY: u64 = 0b01;
+/// The entry's value is indexed, so events can be queried by it.
+pub const FLAG_INDEXED_VALUE: u64 = 0b10;
+
+/// Multicodec of the raw bytes of an EVM log topic or data entry.
+const IPLD_RAW: u64 = 0x55;
+
+/// A key-value pair of an actor event.
+#[derive(Clone, Debug, PartialEq, Eq, Serialize_tuple, Deserialize_tuple)]
+pub struct EventEntry {
+    pub flags: u64,
+    pub key: String,
+    /// Multicodec of the value.
+    pub codec: u64,
+    #[serde(with = "serde_bytes")]
+    pub value: Vec<u8>,
+}
+
+/// An event together with the ID of the actor that emitted it, as stored in the events AMT.
+#[derive(Clone, Debug, PartialEq, Eq, Serialize_tuple, Deserialize_tuple)]
+pub struct StampedEvent {
+    pub emitter: ActorID,
+    pub entries: Vec<EventEntry>,
+}
+
+/// The topics and data of an event emitted as an EVM log.
+#[derive(Clone, Debug, PartialEq, Eq)]
+pub struct EthLog {
+    pub topics: Vec<[u8; 32]>,
+    pub data: Vec<u8>,
+}
+
+impl StampedEvent {
+    /// Returns the event as an EVM log, if it is one: raw entries keyed `t1` to `t4` for up to
+    /// four 32 byte topics, in order without gaps, and optionally `d` for the data.
+    pub fn eth_log(&self) -> Option<EthLog> {
+        let mut topics: [Option<[u8; 32]>; 4] = [None; 4];
+        let mut data = None;
+        for entry in &self.entries {
+            if entry.codec != IPLD_RAW {
+                return None;
+            }
+            match entry.key.as_str() {
+                "d" if data.is_none() => data = Some(entry.value.clone()),
+                "t1" | "t2" | "t3" | "t4" => {
+                    let slot = &mut topics[(entry.key.as_bytes()[1] - b'1') as usize];
+                    if slot.is_some() {
+                        return None;
+                    }
+                    *slot = Some(entry.value.as_slice().try_into().ok()?);
+                }
+                _ => return None,
+            }
+        }
+        let count = topics.iter().take_while(|t| t.is_some()).count();
+        if topics[count..].iter().any(Option::is_some) {
+            return None;
+        }
+        Some(EthLog {
+            topics: topics.into_iter().flatten().collect(),
+            data: data.unwrap_or_default(),
+        })
+    }
+}
+
+/// Calls `f` with the index and content of every event in the events AMT at `root`, in order.
+pub fn for_each_event<BS, F>(store: &BS, root: &Cid, mut f: F) -> anyhow::Result<()>
+where
+    BS: Blockstore,
+    F: FnMut(u64, &StampedEvent) -> anyhow::Result<()>,
+{
+    Array::<StampedEvent, _>::load(root, store)
+        .map_err(|e| anyhow!("failed to load events {}: {}", root, e))?
+        .for_each(|i, event| f(i, event))
+        .map_err(|e| anyhow!("failed to iterate events {}: {}", root, e))
+}
+
+/// Returns the events in the events AMT at `root`, in order.
+pub fn load_events<BS: Blockstore>(store: &BS, root: &Cid) -> anyhow::Result<Vec<StampedEvent>> {
+    let mut events = Vec::new();
+    for_each_event(store, root, |_, event| {
+        events.push(event.clone());
+        Ok(())
+    })?;
+    Ok(events)
+}
+
+#[cfg(test)]
+mod tests {
+    use fvm_ipld_blockstore::MemoryBlockstore;
+
+    use super::*;
+
+    fn entry(key: &str, value: &[u8]) -> EventEntry {
+        EventEntry {
+            flags: FLAG_INDEXED_KEY | FLAG_INDEXED_VALUE,
+            key: key.to_string(),
+            codec: IPLD_RAW,
+            value: value.to_vec(),
+        }
+    }
+
+    #[test]
+    fn decode_events_amt() {
+        let store = MemoryBlockstore::default();
+        let transfer = StampedEvent {
+            emitter: 1001,
+            entries: vec![
+                entry("t1", &[0xdd; 32]),
+                entry("t2", &[0x01; 32]),
+                entry("d", b"amount"),
+            ],
+        };
+        let other = StampedEvent {
+            emitter: 1002,
+            entries: vec![EventEntry {
+                flags: FLAG_INDEXED_KEY,
+                key: "status".to_string(),
+                codec: 0x71,
+                value: vec![0xf5],
+            }],
+        };
+        // The FVM writes the events of a receipt in an AMT of bit width 5.
+        let mut amt = Array::new_with_bit_width(&store, 5);
+        amt.set(0, transfer.clone()).unwrap();
+        amt.set(1, other.clone()).unwrap();
+        let root = amt.flush().unwrap();
+
+        let events = load_events(&store, &root).unwrap();
+        assert_eq!(vec![transfer, other], events);
+        assert_eq!(
+            Some(EthLog {
+                topics: vec![[0xdd; 32], [0x01; 32]],
+                data: b"amount".to_vec(),
+            }),
+            events[0].eth_log()
+        );
+        assert_eq!(None, events[1].eth_log());
+
+        let mut emitters = Vec::new();
+        for_each_event(&store, &root, |i, event| {
+            emitters.push((i, event.emitter));
+            Ok(())
+        })
+        .unwrap();
+        assert_eq!(vec![(0, 1001), (1, 1002)], emitters);
+    }
+
+    #[test]
+    fn eth_log_requires_contiguous_topics() {
+        let log = |entries| {
+            StampedEvent {
+                emitter: 1001,
+                entries,
+            }
+            .eth_log()
+        };
+        assert_eq!(
+            Some(EthLog {
+                topics: vec![],
+                data: vec![1, 2],
+            }),
+            log(vec![entry("d", &[1, 2])])
+        );
+        assert_eq!(None, log(vec![entry("t2", &[0; 32])]));
+        assert_eq!(None, log(vec![entry("t1", &[0; 31])]));
+        assert_eq!(
+            None,
+            log(vec![entry("t1", &[0; 32]), entry("t1", &[1; 32])])
+        );
+    }
+}
@@ -0,0 +1,81 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use anyhow::anyhow;
+
+/// Method numbers below this are reserved for builtin actors, so are never FRC-0042 hashes.
+const FIRST_EXPORTED_METHOD_NUMBER: u64 = 1 << 24;
+
+/// Returns the number of an exported method, as FRC-0042 derives it from the method name: the
+/// first four bytes of the Blake2b-512 digest of `"1|<name>"`, rehashed with an increasing
+/// counter (`"2|<name>"`, ...) while the result falls in the reserved range.
+///
+/// Names must start with an upper case letter or an underscore and contain only ASCII letters,
+/// digits and underscores.
+pub fn frc42_method_number(name: &str) -> anyhow::Result<u64> {
+    let mut chars = name.chars();
+    match chars.next() {
+        None => return Err(anyhow!("method name is empty")),
+        Some(c) if !c.is_ascii_uppercase() && c != '_' => {
+            return Err(anyhow!(
+                "method name {:?} must start with an upper case letter or underscore",
+                name
+            ))
+        }
+        _ => {}
+    }
+    if !chars.all(|c| c.is_ascii_alphanumeric() || c == '_') {
+        return Err(anyhow!(
+            "method name {:?} contains characters other than letters, digits and underscores",
+            name
+        ));
+    }
+
+    for counter in 1.. {
+        let digest = blake2b_simd::blake2b(format!("{}|{}", counter, name).as_bytes());
+        let number = u32::from_be_bytes(digest.as_bytes()[..4].try_into().unwrap()) as u64;
+        if number >= FIRST_EXPORTED_METHOD_NUMBER {
+            return Ok(number);
+        }
+    }
+    unreachable!()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    // As frc42_dispatch::method_hash! and the builtin actors' exported methods.
+    #[test]
+    fn known_method_numbers() {
+        assert_eq!(3726118371, frc42_method_number("Receive").unwrap());
+        assert_eq!(3844450837, frc42_method_number("InvokeEVM").unwrap());
+        assert_eq!(
+            2643134072,
+            frc42_method_number("AuthenticateMessage").unwrap()
+        );
+        assert_eq!(3316146672, frc42_method_number("Constructor").unwrap());
+        assert_eq!(
+            fil_actor_account_v9::Method::UniversalReceiverHook as u64,
+            frc42_method_number("Receive").unwrap()
+        );
+    }
+
+    #[test]
+    fn rehash_into_exported_range() {
+        // The first digest of "1|Method51" starts with a zero byte.
+        let first = blake2b_simd::blake2b(b"1|Method51");
+        assert_eq!(0, first.as_bytes()[0]);
+        assert_eq!(2928426655, frc42_method_number("Method51").unwrap());
+    }
+
+    #[test]
+    fn invalid_method_names() {
+        assert!(frc42_method_number("").is_err());
+        assert!(frc42_method_number("receive").is_err());
+        assert!(frc42_method_number("1Receive").is_err());
+        assert!(frc42_method_number("Re-ceive").is_err());
+        assert!(frc42_method_number("Récept").is_err());
+        frc42_method_number("_Receive_2").unwrap();
+    }
+}
@@ -0,0 +1,206 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use anyhow::anyhow;
+use cid::Cid;
+use fvm_ipld_blockstore::Blockstore;
+use libipld_core::ipld::Ipld;
+use serde_json::{json, Number, Value};
+
+use crate::actor_state::decode_state;
+use crate::error::{load_cbor, StateError};
+use crate::ActorVersion;
+
+/// Decodes the state at `head` of the named builtin actor at the given actors version, and
+/// returns it as DAG-JSON.
+///
+/// `actor` is a bundle manifest name such as `"storagemarket"`, or its short form (`"market"`,
+/// `"miner"`, `"power"`, `"paych"`, `"verifreg"`). The state is checked against the actor's state
+/// type before being dumped, so a head of the wrong actor or version is rejected. Tuple-encoded
+/// states dump as JSON arrays, in field order.
+pub fn decode_actor_head<BS: Blockstore>(
+    actor: &str,
+    version: u32,
+    head: &Cid,
+    store: &BS,
+) -> Result<String, StateError> {
+    let version = match version {
+        8 => ActorVersion::V8,
+        9 => ActorVersion::V9,
+        v => return Err(StateError::UnknownVersion(v)),
+    };
+    let name = match actor {
+        "market" => "storagemarket",
+        "miner" => "storageminer",
+        "power" => "storagepower",
+        "paych" => "paymentchannel",
+        "verifreg" => "verifiedregistry",
+        name => name,
+    };
+    decode_state(store, version, name, head)?;
+
+    let node: Ipld = load_cbor(store, head)?;
+    let json = ipld_to_json(&node).map_err(|e| StateError::Decode {
+        cid: *head,
+        source: fvm_ipld_encoding::Error {
+            description: e.to_string(),
+            protocol: fvm_ipld_encoding::CodecProtocol::Cbor,
+        },
+    })?;
+    Ok(json.to_string())
+}
+
+/// Decodes a DAG-CBOR block of any type and returns it as DAG-JSON, for inspecting blocks whose
+/// type is unknown. Links dump as `{"/":cid}` and bytes as `{"/":{"bytes":base64}}`, and
+/// tuple-encoded structures as JSON arrays, in field order.
+pub fn dump_cbor_as_json(bytes: &[u8]) -> anyhow::Result<Value> {
+    let node: Ipld = fvm_ipld_encoding::from_slice(bytes)
+        .map_err(|e| anyhow!("failed to decode block: {}", e))?;
+    ipld_to_json(&node)
+}
+
+/// Converts `node` to DAG-JSON. Floats stay floats, so 1.0 serializes as `1.0` rather than `1`.
+/// NaN or infinite floats, which JSON cannot represent, are an error, as are integers below
+/// `i64::MIN`.
+fn ipld_to_json(node: &Ipld) -> anyhow::Result<Value> {
+    Ok(match node {
+        Ipld::Null => Value::Null,
+        Ipld::Bool(b) => Value::Bool(*b),
+        Ipld::Integer(i) => {
+            if let Ok(i) = i64::try_from(*i) {
+                Value::from(i)
+            } else if let Ok(u) = u64::try_from(*i) {
+                Value::from(u)
+            } else {
+                return Err(anyhow!("integer {} cannot be written as JSON", i));
+            }
+        }
+        Ipld::Float(f) => Number::from_f64(*f)
+            .map(Value::Number)
+            .ok_or_else(|| anyhow!("float {} cannot be written as DAG-JSON", f))?,
+        Ipld::String(s) => Value::String(s.clone()),
+        Ipld::Bytes(b) => {
+            json!({ "/": { "bytes": base64::encode_config(b, base64::STANDARD_NO_PAD) } })
+        }
+        Ipld::List(items) => {
+            Value::Array(items.iter().map(ipld_to_json).collect::<Result<_, _>>()?)
+        }
+        Ipld::Map(entries) => Value::Object(
+            entries
+                .iter()
+                .map(|(key, value)| Ok((key.clone(), ipld_to_json(value)?)))
+                .collect::<anyhow::Result<_>>()?,
+        ),
+        Ipld::Link(cid) => json!({ "/": cid.to_string() }),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use cid::multihash::Code;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_ipld_encoding::{to_vec, CborStore};
+    use fvm_shared::address::Address;
+    use fvm_shared::commcid::data_commitment_v1_to_cid;
+    use fvm_shared::econ::TokenAmount;
+    use fvm_shared::piece::PaddedPieceSize;
+
+    use super::*;
+
+    #[test]
+    fn decode_account_head() {
+        let store = MemoryBlockstore::default();
+        let state = fil_actor_account_v9::State {
+            address: Address::new_id(100),
+        };
+        let head = store.put_cbor(&state, Code::Blake2b256).unwrap();
+        assert_eq!(
+            r#"[{"/":{"bytes":"AGQ"}}]"#,
+            decode_actor_head("account", 9, &head, &store).unwrap()
+        );
+    }
+
+    #[test]
+    fn decode_market_head() {
+        let store = MemoryBlockstore::default();
+        let state = fil_actor_market_v8::State::new(&store).unwrap();
+        let head = store.put_cbor(&state, Code::Blake2b256).unwrap();
+        let json = decode_actor_head("market", 8, &head, &store).unwrap();
+        assert!(json.starts_with(&format!(r#"[{{"/":"{}"}},"#, state.proposals)));
+        assert_eq!(
+            json,
+            decode_actor_head("storagemarket", 8, &head, &store).unwrap()
+        );
+
+        // The head is not a power state.
+        assert!(matches!(
+            decode_actor_head("power", 8, &head, &store),
+            Err(StateError::Decode { .. })
+        ));
+    }
+
+    #[test]
+    fn dump_deal_proposal_block() {
+        let proposal = fil_actor_market_v9::DealProposal {
+            piece_cid: data_commitment_v1_to_cid(&[1; 32]).unwrap(),
+            piece_size: PaddedPieceSize(2048),
+            verified_deal: false,
+            client: Address::new_id(100),
+            provider: Address::new_id(101),
+            label: fil_actor_market_v9::Label::String("label".to_string()),
+            start_epoch: 10,
+            end_epoch: 1000,
+            storage_price_per_epoch: TokenAmount::from_atto(1),
+            provider_collateral: TokenAmount::from_atto(2),
+            client_collateral: TokenAmount::from_atto(3),
+        };
+        let block = to_vec(&proposal).unwrap();
+        // Fields in order: piece CID, size, verified flag, client and provider address bytes,
+        // label, epochs, then the price and collaterals as big integer bytes.
+        assert_eq!(
+            format!(
+                concat!(
+                    r#"[{{"/":"{}"}},2048,false,{{"/":{{"bytes":"AGQ"}}}},{{"/":{{"bytes":"AGU"}}}},"#,
+                    r#""label",10,1000,{{"/":{{"bytes":"AAE"}}}},{{"/":{{"bytes":"AAI"}}}},"#,
+                    r#"{{"/":{{"bytes":"AAM"}}}}]"#
+                ),
+                proposal.piece_cid
+            ),
+            dump_cbor_as_json(&block).unwrap().to_string()
+        );
+        assert!(dump_cbor_as_json(&block[..block.len() - 1]).is_err());
+    }
+
+    #[test]
+    fn floats_keep_their_form() {
+        let json = |f: f64| ipld_to_json(&Ipld::Float(f)).map(|v| v.to_string());
+        assert_eq!("1.0", json(1.0).unwrap());
+        assert_eq!("-0.5", json(-0.5).unwrap());
+        assert_eq!("1e21", json(1e21).unwrap());
+        assert!(json(f64::NAN).is_err());
+        assert!(json(f64::INFINITY).is_err());
+        assert!(json(f64::NEG_INFINITY).is_err());
+
+        // Through a decoded block: [1.0] as a CBOR array of one double.
+        let block = to_vec(&vec![1.0_f64]).unwrap();
+        let value = dump_cbor_as_json(&block).unwrap();
+        assert!(value[0].is_f64());
+        assert_eq!("[1.0]", value.to_string());
+    }
+
+    #[test]
+    fn unknown_actor_or_version() {
+        let store = MemoryBlockstore::default();
+        let state = fil_actor_power_v9::State::new(&store).unwrap();
+        let head = store.put_cbor(&state, Code::Blake2b256).unwrap();
+        assert!(decode_actor_head("power", 9, &head, &store).is_ok());
+        assert!(matches!(
+            decode_actor_head("power", 10, &head, &store),
+            Err(StateError::UnknownVersion(10))
+        ));
+        assert!(matches!(
+            decode_actor_head("evm", 9, &head, &store),
+            Err(StateError::UnsupportedVersion { .. })
+        ));
+    }
+}
@@ -0,0 +1,60 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! Traits implemented by every shipped version of an actor's state, so callers can inspect
+//! state without matching on the concrete `vN` type, and decoding of any builtin actor's state
+//! from its code CID.
+
+pub use self::account::{address_signature_type, AccountStateExt};
+pub use self::actor_state::{
+    actors_version_for_network, detect_actor_state, ActorState, ActorVersion, Manifest,
+};
+pub use self::bitfield::{bitfield_diff, BitFieldRunsExt};
+pub use self::cids::{cid_equal_ignoring_version, LotusCid};
+pub use self::consts::NetworkParams;
+pub use self::dag::StateDagExt;
+pub use self::datacap::{actor_id_key, DatacapState, TokenState, DATACAP_GRANULARITY};
+pub use self::error::StateError;
+pub use self::eth::EthAddress;
+pub use self::events::{for_each_event, load_events, EthLog, EventEntry, StampedEvent};
+pub use self::frc42::frc42_method_number;
+pub use self::inspect::{decode_actor_head, dump_cbor_as_json};
+pub use self::market::{DealStateExt, MarketStateExt};
+pub use self::migration::{migrate_dry_run, ActorMigration, MigrationReport};
+pub use self::miner::{MinerInfoExt, MinerStateExt};
+pub use self::power::PowerStateExt;
+pub use self::reward::RewardStateExt;
+pub use self::state_tree::{
+    get_actor, normalize_address, resolve_eth_address, walk_state_tree, ActorEntry,
+};
+pub use self::store::{RawStore, StoreAdapter};
+pub use self::supply::{circulating_supply, CirculatingSupply};
+pub use self::system::SystemStateExt;
+pub use self::token::TokenAmountCborExt;
+
+pub mod account;
+pub mod actor_state;
+pub mod bitfield;
+pub mod cids;
+pub mod consts;
+pub mod dag;
+pub mod datacap;
+#[cfg(test)]
+mod encoding_tests;
+pub mod error;
+pub mod eth;
+pub mod events;
+pub mod frc42;
+pub mod inspect;
+pub mod market;
+#[cfg(test)]
+mod method_tests;
+pub mod migration;
+pub mod miner;
+pub mod power;
+pub mod reward;
+pub mod state_tree;
+pub mod store;
+pub mod supply;
+pub mod system;
+pub mod token;
@@ -0,0 +1,273 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::collections::BTreeMap;
+
+use cid::Cid;
+use fil_actor_market_v9::{deal_id_key, AllocationID, NO_ALLOCATION_ID, STATES_AMT_BITWIDTH};
+use fil_actors_runtime_v9::{make_empty_map, Array};
+use fvm_ipld_blockstore::Blockstore;
+use fvm_shared::clock::ChainEpoch;
+use fvm_shared::deal::DealID;
+use fvm_shared::econ::TokenAmount;
+use fvm_shared::sector::SectorNumber;
+use fvm_shared::HAMT_BIT_WIDTH;
+
+/// Read access to the market actor state roots that are common to all versions.
+pub trait MarketStateExt {
+    /// Root of the escrow balance table, `HAMT[Address]TokenAmount`.
+    fn escrow_table(&self) -> &Cid;
+    /// Root of the locked balance table, `HAMT[Address]TokenAmount`.
+    fn locked_table(&self) -> &Cid;
+    /// Root of the deal proposals, `AMT[DealID]DealProposal`.
+    fn deal_proposals(&self) -> &Cid;
+    /// Root of the deal states, `AMT[DealID]DealState`.
+    fn deal_states(&self) -> &Cid;
+    /// Client and provider collateral and client storage fees locked in deals.
+    fn total_locked(&self) -> TokenAmount;
+}
+
+macro_rules! impl_market_state_ext {
+    ($($state:ty),+) => {
+        $(
+            impl MarketStateExt for $state {
+                fn escrow_table(&self) -> &Cid {
+                    &self.escrow_table
+                }
+                fn locked_table(&self) -> &Cid {
+                    &self.locked_table
+                }
+                fn deal_proposals(&self) -> &Cid {
+                    &self.proposals
+                }
+                fn deal_states(&self) -> &Cid {
+                    &self.states
+                }
+                fn total_locked(&self) -> TokenAmount {
+                    <$state>::total_locked(self)
+                }
+            }
+        )+
+    };
+}
+
+impl_market_state_ext!(fil_actor_market_v8::State, fil_actor_market_v9::State);
+
+/// Read access to the fields of a deal's on-chain state, across versions.
+pub trait DealStateExt {
+    /// Epoch the sector holding the deal was proven, or -1 if it has not been.
+    fn sector_start_epoch(&self) -> ChainEpoch;
+    /// Epoch the deal's payments were last processed, or -1 if they never have been.
+    fn last_updated_epoch(&self) -> ChainEpoch;
+    /// Epoch the deal was slashed, or -1 if it has not been.
+    fn slash_epoch(&self) -> ChainEpoch;
+    /// Sector holding the deal, for versions that record it in the deal state (FIP-0076). No
+    /// version shipped here does, so this is `None` for all of them.
+    fn sector_number(&self) -> Option<SectorNumber>;
+}
+
+macro_rules! impl_deal_state_ext {
+    ($($state:ty),+) => {
+        $(
+            impl DealStateExt for $state {
+                fn sector_start_epoch(&self) -> ChainEpoch {
+                    self.sector_start_epoch
+                }
+                fn last_updated_epoch(&self) -> ChainEpoch {
+                    self.last_updated_epoch
+                }
+                fn slash_epoch(&self) -> ChainEpoch {
+                    self.slash_epoch
+                }
+                fn sector_number(&self) -> Option<SectorNumber> {
+                    None
+                }
+            }
+        )+
+    };
+}
+
+impl_deal_state_ext!(
+    fil_actor_market_v8::DealState,
+    fil_actor_market_v9::DealState
+);
+
+/// Migrates a v8 market state to v9 (FIP-0045).
+///
+/// `deal_allocations` maps each pending verified deal to the verified registry allocation created
+/// for it when migrating the verified registry. Deals activated before the migration keep no
+/// allocation, as their data cap was already spent.
+pub fn migrate_v8_to_v9<BS: Blockstore>(
+    store: &BS,
+    state: &fil_actor_market_v8::State,
+    deal_allocations: &BTreeMap<DealID, AllocationID>,
+) -> anyhow::Result<fil_actor_market_v9::State> {
+    let old_states = Array::<fil_actor_market_v8::DealState, _>::load(&state.states, store)?;
+    let mut new_states =
+        Array::<fil_actor_market_v9::DealState, _>::new_with_bit_width(store, STATES_AMT_BITWIDTH);
+    old_states.for_each(|deal_id, old| {
+        new_states.set(
+            deal_id,
+            fil_actor_market_v9::DealState {
+                sector_start_epoch: old.sector_start_epoch,
+                last_updated_epoch: old.last_updated_epoch,
+                slash_epoch: old.slash_epoch,
+                verified_claim: NO_ALLOCATION_ID,
+            },
+        )?;
+        Ok(())
+    })?;
+
+    let mut pending_deal_allocation_ids = make_empty_map::<_, AllocationID>(store, HAMT_BIT_WIDTH);
+    for (deal_id, allocation_id) in deal_allocations {
+        pending_deal_allocation_ids.set(deal_id_key(*deal_id), *allocation_id)?;
+    }
+
+    Ok(fil_actor_market_v9::State {
+        proposals: state.proposals,
+        states: new_states.flush()?,
+        pending_proposals: state.pending_proposals,
+        escrow_table: state.escrow_table,
+        locked_table: state.locked_table,
+        next_id: state.next_id,
+        deal_ops_by_epoch: state.deal_ops_by_epoch,
+        last_cron: state.last_cron,
+        total_client_locked_collateral: state.total_client_locked_collateral.clone(),
+        total_provider_locked_collateral: state.total_provider_locked_collateral.clone(),
+        total_client_storage_fee: state.total_client_storage_fee.clone(),
+        pending_deal_allocation_ids: pending_deal_allocation_ids.flush()?,
+    })
+}
+
+/// Returns the verified deals of a v8 market state that are published but not yet activated,
+/// each with the verified registry allocation created for it by the migration to v9. Allocation
+/// IDs are numbered from 1 in deal ID order.
+pub fn pending_deal_allocations<BS: Blockstore>(
+    store: &BS,
+    state: &fil_actor_market_v8::State,
+) -> anyhow::Result<BTreeMap<DealID, AllocationID>> {
+    let proposals = Array::<fil_actor_market_v8::DealProposal, _>::load(&state.proposals, store)?;
+    let states = Array::<fil_actor_market_v8::DealState, _>::load(&state.states, store)?;
+    let mut allocations = BTreeMap::new();
+    let mut next_id = NO_ALLOCATION_ID + 1;
+    proposals.for_each(|deal_id, proposal| {
+        if proposal.verified_deal && states.get(deal_id)?.is_none() {
+            allocations.insert(deal_id, next_id);
+            next_id += 1;
+        }
+        Ok(())
+    })?;
+    Ok(allocations)
+}
+
+#[cfg(test)]
+mod tests {
+    use fil_actors_runtime_v9::make_map_with_root_and_bitwidth;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_shared::address::Address;
+
+    use super::*;
+
+    #[test]
+    fn escrow_table_parity() {
+        let store = MemoryBlockstore::default();
+        let addr = Address::new_id(100);
+        let amount = TokenAmount::from_atto(42);
+
+        let mut v8 = fil_actor_market_v8::State::new(&store).unwrap();
+        let mut table = fil_actor_market_v8::balance_table::BalanceTable::new(&store);
+        table.add(&addr, &amount).unwrap();
+        v8.escrow_table = table.root().unwrap();
+
+        let mut v9 = fil_actor_market_v9::State::new(&store).unwrap();
+        let mut table = fil_actor_market_v9::balance_table::BalanceTable::new(&store);
+        table.add(&addr, &amount).unwrap();
+        v9.escrow_table = table.root().unwrap();
+
+        let states: [&dyn MarketStateExt; 2] = [&v8, &v9];
+        for state in states {
+            let table = fil_actor_market_v9::balance_table::BalanceTable::from_root(
+                &store,
+                state.escrow_table(),
+            )
+            .unwrap();
+            assert_eq!(table.get(&addr).unwrap(), amount);
+        }
+        assert_eq!(v8.escrow_table(), &v8.escrow_table);
+        assert_eq!(v9.locked_table(), &v9.locked_table);
+        assert_eq!(v9.deal_proposals(), &v9.proposals);
+        assert_eq!(v9.deal_states(), &v9.states);
+    }
+
+    #[test]
+    fn deal_state_fields() {
+        // [sector_start_epoch, last_updated_epoch, slash_epoch], and in v9 the verified claim.
+        let v8: fil_actor_market_v8::DealState =
+            fvm_ipld_encoding::from_slice(&[0x83, 0x0a, 0x14, 0x20]).unwrap();
+        let v9: fil_actor_market_v9::DealState =
+            fvm_ipld_encoding::from_slice(&[0x84, 0x0a, 0x14, 0x20, 0x07]).unwrap();
+        assert_eq!(7, v9.verified_claim);
+        let states: [&dyn DealStateExt; 2] = [&v8, &v9];
+        for state in states {
+            assert_eq!(10, state.sector_start_epoch());
+            assert_eq!(20, state.last_updated_epoch());
+            assert_eq!(-1, state.slash_epoch());
+            assert_eq!(None, state.sector_number());
+        }
+    }
+
+    #[test]
+    fn migrate_market_v8_to_v9() {
+        let store = MemoryBlockstore::default();
+        let mut v8 = fil_actor_market_v8::State::new(&store).unwrap();
+
+        let mut states = fil_actors_runtime_v8::Array::<fil_actor_market_v8::DealState, _>::load(
+            &v8.states, &store,
+        )
+        .unwrap();
+        states
+            .set(
+                1,
+                fil_actor_market_v8::DealState {
+                    sector_start_epoch: 10,
+                    last_updated_epoch: 20,
+                    slash_epoch: -1,
+                },
+            )
+            .unwrap();
+        v8.states = states.flush().unwrap();
+        v8.next_id = 4;
+        v8.total_client_storage_fee = TokenAmount::from_atto(100);
+
+        let deal_allocations = BTreeMap::from([(2, 10), (3, 11)]);
+        let v9 = migrate_v8_to_v9(&store, &v8, &deal_allocations).unwrap();
+
+        assert_eq!(v9.proposals, v8.proposals);
+        assert_eq!(v9.escrow_table, v8.escrow_table);
+        assert_eq!(v9.locked_table, v8.locked_table);
+        assert_eq!(v9.next_id, 4);
+        assert_eq!(v9.total_client_storage_fee, TokenAmount::from_atto(100));
+
+        let states = Array::<fil_actor_market_v9::DealState, _>::load(&v9.states, &store).unwrap();
+        assert_eq!(states.count(), 1);
+        assert_eq!(
+            states.get(1).unwrap(),
+            Some(&fil_actor_market_v9::DealState {
+                sector_start_epoch: 10,
+                last_updated_epoch: 20,
+                slash_epoch: -1,
+                verified_claim: NO_ALLOCATION_ID,
+            })
+        );
+
+        let pending = make_map_with_root_and_bitwidth::<_, AllocationID>(
+            &v9.pending_deal_allocation_ids,
+            &store,
+            HAMT_BIT_WIDTH,
+        )
+        .unwrap();
+        assert_eq!(pending.get(&deal_id_key(2)).unwrap(), Some(&10));
+        assert_eq!(pending.get(&deal_id_key(3)).unwrap(), Some(&11));
+        assert_eq!(pending.get(&deal_id_key(1)).unwrap(), None);
+    }
+}
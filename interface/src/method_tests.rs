@@ -0,0 +1,70 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! Method numbers of the builtin actors, as message decoders name them: each actor's `Method`
+//! enum must keep the numbers of the network's dispatch tables.
+
+/// FRC-0042 hash of `"Receive"`, the universal receiver hook of FRC-0046 tokens.
+const UNIVERSAL_RECEIVER_HOOK: u64 = 3726118371;
+
+macro_rules! check_methods {
+    ($test:ident, $market:ident, $miner:ident, $power:ident, $verifreg:ident, $multisig:ident) => {
+        #[test]
+        fn $test() {
+            assert_eq!(1, $market::Method::Constructor as u64);
+            assert_eq!(4, $market::Method::PublishStorageDeals as u64);
+            assert_eq!(9, $market::Method::CronTick as u64);
+
+            assert_eq!(5, $miner::Method::SubmitWindowedPoSt as u64);
+            assert_eq!(6, $miner::Method::PreCommitSector as u64);
+            assert_eq!(7, $miner::Method::ProveCommitSector as u64);
+            assert_eq!(27, $miner::Method::ProveReplicaUpdates as u64);
+
+            assert_eq!(2, $power::Method::CreateMiner as u64);
+            assert_eq!(4, $verifreg::Method::AddVerifiedClient as u64);
+            assert_eq!(2, $multisig::Method::Propose as u64);
+        }
+    };
+}
+
+check_methods!(
+    methods_v8,
+    fil_actor_market_v8,
+    fil_actor_miner_v8,
+    fil_actor_power_v8,
+    fil_actor_verifreg_v8,
+    fil_actor_multisig_v8
+);
+check_methods!(
+    methods_v9,
+    fil_actor_market_v9,
+    fil_actor_miner_v9,
+    fil_actor_power_v9,
+    fil_actor_verifreg_v9,
+    fil_actor_multisig_v9
+);
+
+#[test]
+fn methods_added_in_v9() {
+    assert_eq!(28, fil_actor_miner_v9::Method::PreCommitSectorBatch2 as u64);
+    assert_eq!(29, fil_actor_miner_v9::Method::ProveReplicaUpdates2 as u64);
+    assert_eq!(30, fil_actor_miner_v9::Method::ChangeBeneficiary as u64);
+    assert_eq!(
+        12,
+        fil_actor_verifreg_v9::Method::RemoveExpiredClaims as u64
+    );
+
+    // FRC-0042 hashed method numbers, for methods callable by any actor.
+    assert_eq!(
+        UNIVERSAL_RECEIVER_HOOK,
+        fil_actor_account_v9::Method::UniversalReceiverHook as u64
+    );
+    assert_eq!(
+        UNIVERSAL_RECEIVER_HOOK,
+        fil_actor_verifreg_v9::Method::UniversalReceiverHook as u64
+    );
+    assert_eq!(
+        UNIVERSAL_RECEIVER_HOOK,
+        fil_actor_multisig_v9::Method::UniversalReceiverHook as u64
+    );
+}
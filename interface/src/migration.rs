@@ -0,0 +1,246 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! Dry runs of the actor state migrations this crate implements.
+
+use cid::multihash::Code;
+use cid::Cid;
+use fil_actor_market_v9::AllocationID;
+use fil_actors_runtime_v9::{make_map_with_root_and_bitwidth, Array, STORAGE_MARKET_ACTOR_ADDR};
+use fvm_ipld_blockstore::{Blockstore, MemoryBlockstore};
+use fvm_ipld_encoding::CborStore;
+use fvm_shared::address::Address;
+use fvm_shared::HAMT_BIT_WIDTH;
+
+use crate::error::{load_cbor, StateError};
+use crate::market::{migrate_v8_to_v9, pending_deal_allocations};
+use crate::state_tree::{walk_state_tree, ActorEntry};
+use crate::ActorVersion;
+
+/// What [`migrate_dry_run`] would migrate in a state tree.
+#[derive(Clone, Debug, PartialEq, Eq)]
+pub struct MigrationReport {
+    /// Number of actors in the state tree.
+    pub actors: u64,
+    /// The actors whose state is migrated, in the order of the actors HAMT.
+    pub migrated: Vec<ActorMigration>,
+}
+
+/// The migration of one actor's state.
+#[derive(Clone, Debug, PartialEq, Eq)]
+pub struct ActorMigration {
+    pub address: Address,
+    pub old_head: Cid,
+    /// Head the migrated state would have. It is not written to the store.
+    pub new_head: Cid,
+    /// Number of deal states written in the new layout.
+    pub deal_states: u64,
+    /// Number of pending deal allocations written.
+    pub allocations: u64,
+}
+
+/// Walks the actors of the state tree at `old_root` and runs the migration to `target_version`
+/// of each actor that has one, without writing to `store`. Counts are of the entries the
+/// migrations write.
+///
+/// Only the market actor has a migration in this crate, from v8 to v9. Its pending verified deals
+/// are given the allocations of [`pending_deal_allocations`].
+pub fn migrate_dry_run<BS: Blockstore>(
+    old_root: &Cid,
+    store: &BS,
+    target_version: ActorVersion,
+) -> Result<MigrationReport, StateError> {
+    // Migrating to v8 would read v7 state, which is not shipped here.
+    if target_version != ActorVersion::V9 {
+        return Err(StateError::UnknownVersion(7));
+    }
+
+    let mut actors = 0;
+    let mut market = None;
+    walk_state_tree(store, old_root, |addr, entry| {
+        actors += 1;
+        if addr == STORAGE_MARKET_ACTOR_ADDR {
+            market = Some(entry.clone());
+        }
+        Ok(())
+    })?;
+
+    let overlay = OverlayStore {
+        base: store,
+        writes: MemoryBlockstore::default(),
+    };
+    let mut migrated = Vec::new();
+    if let Some(entry) = market {
+        migrated.push(migrate_market(&overlay, STORAGE_MARKET_ACTOR_ADDR, &entry)?);
+    }
+    Ok(MigrationReport { actors, migrated })
+}
+
+fn migrate_market<BS: Blockstore>(
+    overlay: &OverlayStore<'_, BS>,
+    address: Address,
+    entry: &ActorEntry,
+) -> anyhow::Result<ActorMigration> {
+    let state: fil_actor_market_v8::State = load_cbor(overlay, &entry.head)?;
+    let deal_allocations = pending_deal_allocations(overlay, &state)?;
+    let migrated = migrate_v8_to_v9(overlay, &state, &deal_allocations)?;
+
+    // Count from the overlay's own writes, so only what the migration wrote is counted.
+    let deal_states =
+        Array::<fil_actor_market_v9::DealState, _>::load(&migrated.states, &overlay.writes)?
+            .count();
+    let mut allocations = 0;
+    make_map_with_root_and_bitwidth::<_, AllocationID>(
+        &migrated.pending_deal_allocation_ids,
+        &overlay.writes,
+        HAMT_BIT_WIDTH,
+    )?
+    .for_each(|_, _| {
+        allocations += 1;
+        Ok(())
+    })?;
+
+    Ok(ActorMigration {
+        address,
+        old_head: entry.head,
+        new_head: overlay.put_cbor(&migrated, Code::Blake2b256)?,
+        deal_states,
+        allocations,
+    })
+}
+
+/// A blockstore that reads through to `base` and keeps its own writes in memory.
+struct OverlayStore<'a, BS> {
+    base: &'a BS,
+    writes: MemoryBlockstore,
+}
+
+impl<BS: Blockstore> Blockstore for OverlayStore<'_, BS> {
+    fn get(&self, k: &Cid) -> anyhow::Result<Option<Vec<u8>>> {
+        match self.writes.get(k)? {
+            Some(block) => Ok(Some(block)),
+            None => self.base.get(k),
+        }
+    }
+
+    fn put_keyed(&self, k: &Cid, block: &[u8]) -> anyhow::Result<()> {
+        self.writes.put_keyed(k, block)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::BTreeMap;
+
+    use fil_actor_market_v9::deal_id_key;
+    use fil_actors_runtime_v9::{make_empty_map, Keyer};
+    use fvm_shared::econ::TokenAmount;
+    use fvm_shared::piece::PaddedPieceSize;
+
+    use super::*;
+
+    fn proposal(verified_deal: bool) -> fil_actor_market_v8::DealProposal {
+        fil_actor_market_v8::DealProposal {
+            piece_cid: Cid::default(),
+            piece_size: PaddedPieceSize(2048),
+            verified_deal,
+            client: Address::new_id(101),
+            provider: Address::new_id(100),
+            label: fil_actor_market_v8::Label::String(String::new()),
+            start_epoch: 10,
+            end_epoch: 200,
+            storage_price_per_epoch: TokenAmount::from_atto(0),
+            provider_collateral: TokenAmount::from_atto(0),
+            client_collateral: TokenAmount::from_atto(0),
+        }
+    }
+
+    /// A v8 market with deals 1 and 5 active, and deals 2, 3, 4 (verified) and 6 pending.
+    fn market(store: &MemoryBlockstore) -> fil_actor_market_v8::State {
+        let mut state = fil_actor_market_v8::State::new(store).unwrap();
+        let mut proposals =
+            Array::<fil_actor_market_v8::DealProposal, _>::load(&state.proposals, store).unwrap();
+        let mut states =
+            Array::<fil_actor_market_v8::DealState, _>::load(&state.states, store).unwrap();
+        for deal_id in 1..=6 {
+            proposals.set(deal_id, proposal(deal_id != 6)).unwrap();
+        }
+        for deal_id in [1, 5] {
+            let state = fil_actor_market_v8::DealState {
+                sector_start_epoch: 10,
+                last_updated_epoch: 20,
+                slash_epoch: -1,
+            };
+            states.set(deal_id, state).unwrap();
+        }
+        state.proposals = proposals.flush().unwrap();
+        state.states = states.flush().unwrap();
+        state.next_id = 7;
+        state
+    }
+
+    /// A version 4 state tree with the market at `f05` and an account at `f0100`.
+    fn state_tree(store: &MemoryBlockstore, market: &Cid) -> Cid {
+        let account = store.put_cbor(&(), Code::Blake2b256).unwrap();
+        let mut actors = make_empty_map(store, HAMT_BIT_WIDTH);
+        for (addr, head) in [
+            (STORAGE_MARKET_ACTOR_ADDR, *market),
+            (Address::new_id(100), account),
+        ] {
+            let actor = (Cid::default(), head, 0u64, TokenAmount::from_atto(0));
+            actors.set(addr.key(), actor).unwrap();
+        }
+        let actors = actors.flush().unwrap();
+        let info = store.put_cbor(&(), Code::Blake2b256).unwrap();
+        store
+            .put_cbor(&(4u64, actors, info), Code::Blake2b256)
+            .unwrap()
+    }
+
+    #[test]
+    fn dry_run_market_v8_to_v9() {
+        let store = MemoryBlockstore::default();
+        let v8 = market(&store);
+        let head = store.put_cbor(&v8, Code::Blake2b256).unwrap();
+        let root = state_tree(&store, &head);
+
+        let report = migrate_dry_run(&root, &store, ActorVersion::V9).unwrap();
+        assert_eq!(2, report.actors);
+        assert_eq!(1, report.migrated.len());
+        let market = &report.migrated[0];
+        assert_eq!(STORAGE_MARKET_ACTOR_ADDR, market.address);
+        assert_eq!(head, market.old_head);
+        assert!(!store.has(&market.new_head).unwrap());
+
+        // The counts and head match those of the migration proper.
+        let deal_allocations = pending_deal_allocations(&store, &v8).unwrap();
+        assert_eq!(BTreeMap::from([(2, 1), (3, 2), (4, 3)]), deal_allocations);
+        let v9 = migrate_v8_to_v9(&store, &v8, &deal_allocations).unwrap();
+        let states = Array::<fil_actor_market_v9::DealState, _>::load(&v9.states, &store).unwrap();
+        assert_eq!(states.count(), market.deal_states);
+        let pending = make_map_with_root_and_bitwidth::<_, AllocationID>(
+            &v9.pending_deal_allocation_ids,
+            &store,
+            HAMT_BIT_WIDTH,
+        )
+        .unwrap();
+        let mut migrated_allocations = 0;
+        pending
+            .for_each(|_, _| {
+                migrated_allocations += 1;
+                Ok(())
+            })
+            .unwrap();
+        assert_eq!(migrated_allocations, market.allocations);
+        assert_eq!(Some(&1), pending.get(&deal_id_key(2)).unwrap());
+        assert_eq!(
+            market.new_head,
+            store.put_cbor(&v9, Code::Blake2b256).unwrap()
+        );
+
+        assert!(matches!(
+            migrate_dry_run(&root, &store, ActorVersion::V8),
+            Err(StateError::UnknownVersion(7))
+        ));
+    }
+}
@@ -0,0 +1,377 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::collections::BTreeSet;
+
+use anyhow::anyhow;
+use fil_actor_miner_v9::{BeneficiaryTerm, PendingBeneficiaryChange};
+use fvm_ipld_blockstore::Blockstore;
+use fvm_shared::address::Address;
+use fvm_shared::clock::EPOCH_UNDEFINED;
+use fvm_shared::deal::DealID;
+use fvm_shared::econ::TokenAmount;
+use fvm_shared::sector::SectorNumber;
+
+/// Read access to where a miner's rewards are directed, in all versions of the miner info.
+///
+/// Beneficiaries were introduced by FIP-0029 in v9. Before, withdrawals always went to the owner,
+/// which is reported as the beneficiary, with an empty term and no pending change.
+pub trait MinerInfoExt {
+    /// Address that withdrawals from the miner are sent to.
+    fn beneficiary(&self) -> Address;
+    /// Quota and expiration of the current beneficiary's rights.
+    fn beneficiary_term(&self) -> BeneficiaryTerm;
+    /// A proposed change of beneficiary awaiting approval, if any.
+    fn pending_beneficiary_change(&self) -> Option<&PendingBeneficiaryChange>;
+}
+
+impl MinerInfoExt for fil_actor_miner_v8::MinerInfo {
+    fn beneficiary(&self) -> Address {
+        self.owner
+    }
+    fn beneficiary_term(&self) -> BeneficiaryTerm {
+        BeneficiaryTerm::default_value()
+    }
+    fn pending_beneficiary_change(&self) -> Option<&PendingBeneficiaryChange> {
+        None
+    }
+}
+
+impl MinerInfoExt for fil_actor_miner_v9::MinerInfo {
+    fn beneficiary(&self) -> Address {
+        self.beneficiary
+    }
+    fn beneficiary_term(&self) -> BeneficiaryTerm {
+        self.beneficiary_term.clone()
+    }
+    fn pending_beneficiary_change(&self) -> Option<&PendingBeneficiaryChange> {
+        self.pending_beneficiary_term.as_ref()
+    }
+}
+
+/// Deal economics of miner sectors, from the deal proposals in the market state of the same
+/// actors version.
+pub trait MinerStateExt {
+    /// The market state of the same actors version.
+    type Market;
+
+    /// Returns the total padded piece size, in bytes, and the total provider collateral of the
+    /// deals stored in a sector. Both are zero for a committed-capacity sector. Deals whose
+    /// proposals are no longer in the market state are not counted. Errors if the sector is not
+    /// in the state.
+    fn sector_deal_summary<BS: Blockstore>(
+        &self,
+        store: &BS,
+        market: &Self::Market,
+        sector: SectorNumber,
+    ) -> anyhow::Result<(u64, TokenAmount)>;
+
+    /// Returns the deals of `provider`, this miner's address, that the market state records as
+    /// activated but that are in none of the miner's sectors, in deal ID order. Slashed deals
+    /// are skipped, as their sectors may already be terminated. Consistent states have none.
+    fn verify_deal_activation<BS: Blockstore>(
+        &self,
+        store: &BS,
+        market: &Self::Market,
+        provider: &Address,
+    ) -> anyhow::Result<Vec<DealID>>;
+}
+
+macro_rules! impl_miner_state_ext {
+    ($($miner:ident => $market:ident),+) => {
+        $(
+            impl MinerStateExt for $miner::State {
+                type Market = $market::State;
+
+                fn sector_deal_summary<BS: Blockstore>(
+                    &self,
+                    store: &BS,
+                    market: &Self::Market,
+                    sector: SectorNumber,
+                ) -> anyhow::Result<(u64, TokenAmount)> {
+                    let mut space = 0;
+                    let mut collateral = TokenAmount::default();
+                    for deal_id in self.sector_deal_ids(store, sector)? {
+                        let proposal = market.deal_proposal(store, deal_id).map_err(|e| {
+                            anyhow!("failed to load deal {} of sector {}: {}", deal_id, sector, e)
+                        })?;
+                        if let Some(proposal) = proposal {
+                            space += proposal.piece_size.0;
+                            collateral += proposal.provider_collateral;
+                        }
+                    }
+                    Ok((space, collateral))
+                }
+
+                fn verify_deal_activation<BS: Blockstore>(
+                    &self,
+                    store: &BS,
+                    market: &Self::Market,
+                    provider: &Address,
+                ) -> anyhow::Result<Vec<DealID>> {
+                    let mut sector_deals = BTreeSet::new();
+                    $miner::Sectors::load(store, &self.sectors)?
+                        .amt
+                        .for_each(|_, sector| {
+                            sector_deals.extend(sector.deal_ids.iter().copied());
+                            Ok(())
+                        })
+                        .map_err(|e| anyhow!("failed to iterate sectors: {}", e))?;
+
+                    let proposals = $market::DealArray::load(&market.proposals, store)
+                        .map_err(|e| anyhow!("failed to load deal proposals: {}", e))?;
+                    let mut orphans = Vec::new();
+                    $market::DealMetaArray::load(&market.states, store)
+                        .map_err(|e| anyhow!("failed to load deal states: {}", e))?
+                        .for_each(|deal_id, state| {
+                            if state.sector_start_epoch == EPOCH_UNDEFINED
+                                || state.slash_epoch != EPOCH_UNDEFINED
+                                || sector_deals.contains(&deal_id)
+                            {
+                                return Ok(());
+                            }
+                            let proposal = proposals.get(deal_id)?.ok_or_else(|| {
+                                anyhow!("no proposal for activated deal {}", deal_id)
+                            })?;
+                            if proposal.provider == *provider {
+                                orphans.push(deal_id);
+                            }
+                            Ok(())
+                        })
+                        .map_err(|e| anyhow!("failed to audit deal states: {}", e))?;
+                    Ok(orphans)
+                }
+            }
+        )+
+    };
+}
+
+impl_miner_state_ext!(
+    fil_actor_miner_v8 => fil_actor_market_v8,
+    fil_actor_miner_v9 => fil_actor_market_v9
+);
+
+#[cfg(test)]
+mod tests {
+    use cid::multihash::Code;
+    use cid::Cid;
+    use fil_actor_market_v9::{DealArray, DealMetaArray, DealProposal, DealState, Label};
+    use fil_actor_miner_v9::SectorOnChainInfo;
+    use fil_actors_runtime_v9::runtime::Policy;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_ipld_encoding::CborStore;
+    use fvm_shared::piece::PaddedPieceSize;
+    use fvm_shared::sector::RegisteredPoStProof;
+
+    use super::*;
+
+    macro_rules! miner_info {
+        ($miner:ident) => {
+            $miner::MinerInfo::new(
+                Address::new_id(100),
+                Address::new_id(101),
+                vec![],
+                b"peer".to_vec(),
+                vec![],
+                RegisteredPoStProof::StackedDRGWindow32GiBV1,
+            )
+            .unwrap()
+        };
+    }
+
+    #[test]
+    fn owner_is_beneficiary_before_v9() {
+        let info = miner_info!(fil_actor_miner_v8);
+        let ext: &dyn MinerInfoExt = &info;
+        assert_eq!(Address::new_id(100), ext.beneficiary());
+        assert_eq!(BeneficiaryTerm::default_value(), ext.beneficiary_term());
+        assert!(ext.pending_beneficiary_change().is_none());
+    }
+
+    #[test]
+    fn active_beneficiary() {
+        let mut info = miner_info!(fil_actor_miner_v9);
+        assert_eq!(Address::new_id(100), info.beneficiary());
+
+        let term = BeneficiaryTerm::new(
+            TokenAmount::from_whole(100),
+            TokenAmount::from_whole(40),
+            2880,
+        );
+        info.beneficiary = Address::new_id(200);
+        info.beneficiary_term = term.clone();
+        let ext: &dyn MinerInfoExt = &info;
+        assert_eq!(Address::new_id(200), ext.beneficiary());
+        assert_eq!(term, ext.beneficiary_term());
+        assert_eq!(
+            TokenAmount::from_whole(60),
+            ext.beneficiary_term().available(100)
+        );
+        assert!(ext.pending_beneficiary_change().is_none());
+    }
+
+    #[test]
+    fn pending_beneficiary_change() {
+        let mut info = miner_info!(fil_actor_miner_v9);
+        info.pending_beneficiary_term = Some(PendingBeneficiaryChange::new(
+            Address::new_id(300),
+            TokenAmount::from_whole(10),
+            5000,
+        ));
+        let pending = info.pending_beneficiary_change().unwrap();
+        assert_eq!(Address::new_id(300), pending.new_beneficiary);
+        assert_eq!(5000, pending.new_expiration);
+        assert!(!pending.approved_by_beneficiary);
+        // The current beneficiary is unchanged until the change is approved.
+        assert_eq!(Address::new_id(100), info.beneficiary());
+    }
+
+    fn proposal() -> DealProposal {
+        DealProposal {
+            piece_cid: Cid::default(),
+            piece_size: PaddedPieceSize(2048),
+            verified_deal: false,
+            client: Address::new_id(200),
+            provider: Address::new_id(100),
+            label: Label::String(String::new()),
+            start_epoch: 10,
+            end_epoch: 1000,
+            storage_price_per_epoch: TokenAmount::from_atto(1),
+            provider_collateral: TokenAmount::default(),
+            client_collateral: TokenAmount::default(),
+        }
+    }
+
+    #[test]
+    fn sector_deal_summary() {
+        let store = MemoryBlockstore::default();
+        let info = store
+            .put_cbor(&miner_info!(fil_actor_miner_v9), Code::Blake2b256)
+            .unwrap();
+        let mut miner =
+            fil_actor_miner_v9::State::new(&Policy::default(), &store, info, 0, 0).unwrap();
+        miner
+            .put_sectors(
+                &store,
+                vec![
+                    SectorOnChainInfo {
+                        sector_number: 1,
+                        deal_ids: vec![0, 2],
+                        ..Default::default()
+                    },
+                    SectorOnChainInfo {
+                        sector_number: 2,
+                        ..Default::default()
+                    },
+                ],
+            )
+            .unwrap();
+
+        let mut market = fil_actor_market_v9::State::new(&store).unwrap();
+        let mut proposals = DealArray::load(&market.proposals, &store).unwrap();
+        for (id, size, collateral) in [(0, 1 << 20, 100), (1, 1 << 30, 1000), (2, 2 << 20, 50)] {
+            proposals
+                .set(
+                    id,
+                    DealProposal {
+                        piece_size: PaddedPieceSize(size),
+                        provider_collateral: TokenAmount::from_atto(collateral),
+                        ..proposal()
+                    },
+                )
+                .unwrap();
+        }
+        market.proposals = proposals.flush().unwrap();
+        market.next_id = 3;
+
+        assert_eq!(
+            (3 << 20, TokenAmount::from_atto(150)),
+            miner.sector_deal_summary(&store, &market, 1).unwrap()
+        );
+        // A committed-capacity sector.
+        assert_eq!(
+            (0, TokenAmount::default()),
+            miner.sector_deal_summary(&store, &market, 2).unwrap()
+        );
+        assert!(miner.sector_deal_summary(&store, &market, 3).is_err());
+    }
+
+    #[test]
+    fn verify_deal_activation() {
+        let store = MemoryBlockstore::default();
+        let info = store
+            .put_cbor(&miner_info!(fil_actor_miner_v9), Code::Blake2b256)
+            .unwrap();
+        let mut miner =
+            fil_actor_miner_v9::State::new(&Policy::default(), &store, info, 0, 0).unwrap();
+        miner
+            .put_sectors(
+                &store,
+                vec![SectorOnChainInfo {
+                    sector_number: 1,
+                    deal_ids: vec![0, 1],
+                    ..Default::default()
+                }],
+            )
+            .unwrap();
+
+        // Deals 0 and 1 of this miner, in its sector, and deal 2 of another miner.
+        let mut market = fil_actor_market_v9::State::new(&store).unwrap();
+        let mut proposals = DealArray::load(&market.proposals, &store).unwrap();
+        let mut states = DealMetaArray::load(&market.states, &store).unwrap();
+        for (id, provider) in [(0, 100), (1, 100), (2, 101), (3, 100)] {
+            proposals
+                .set(
+                    id,
+                    DealProposal {
+                        provider: Address::new_id(provider),
+                        ..proposal()
+                    },
+                )
+                .unwrap();
+        }
+        let active = DealState {
+            sector_start_epoch: 5,
+            last_updated_epoch: EPOCH_UNDEFINED,
+            slash_epoch: EPOCH_UNDEFINED,
+            verified_claim: 0,
+        };
+        for id in [0, 1, 2] {
+            states.set(id, active).unwrap();
+        }
+        market.proposals = proposals.flush().unwrap();
+        market.states = states.flush().unwrap();
+        market.next_id = 4;
+
+        // Deal 3 is published but not activated, so it is not expected in a sector.
+        let provider = Address::new_id(100);
+        assert!(miner
+            .verify_deal_activation(&store, &market, &provider)
+            .unwrap()
+            .is_empty());
+
+        // Activating deal 3 without adding it to a sector leaves it orphaned.
+        let mut states = DealMetaArray::load(&market.states, &store).unwrap();
+        states.set(3, active).unwrap();
+        market.states = states.flush().unwrap();
+        assert_eq!(
+            vec![3],
+            miner
+                .verify_deal_activation(&store, &market, &provider)
+                .unwrap()
+        );
+
+        // Once slashed, its sector is gone and it is no longer expected in one.
+        let mut states = DealMetaArray::load(&market.states, &store).unwrap();
+        let slashed = DealState {
+            slash_epoch: 50,
+            ..active
+        };
+        states.set(3, slashed).unwrap();
+        market.states = states.flush().unwrap();
+        assert!(miner
+            .verify_deal_activation(&store, &market, &provider)
+            .unwrap()
+            .is_empty());
+    }
+}
@@ -0,0 +1,109 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use fvm_shared::econ::TokenAmount;
+use fvm_shared::sector::StoragePower;
+
+/// Read access to the network power totals tracked by the power actor in all versions.
+pub trait PowerStateExt {
+    /// Raw byte power of all miners above the consensus minimum, as updated by the latest
+    /// power changes.
+    fn total_raw_byte_power(&self) -> &StoragePower;
+    /// Quality-adjusted power of all miners above the consensus minimum, as updated by the
+    /// latest power changes.
+    fn total_quality_adj_power(&self) -> &StoragePower;
+    /// Raw byte power snapshotted at the start of the current epoch, as used for consensus.
+    fn this_epoch_raw_byte_power(&self) -> &StoragePower;
+    /// Quality-adjusted power snapshotted at the start of the current epoch, as used for
+    /// consensus and block reward.
+    fn this_epoch_quality_adj_power(&self) -> &StoragePower;
+    /// Number of miners with a power claim, whether or not they meet the consensus minimum.
+    fn miner_count(&self) -> i64;
+    /// Pledge collateral locked by all miners.
+    fn total_pledge_collateral(&self) -> &TokenAmount;
+}
+
+macro_rules! impl_power_state_ext {
+    ($($state:ty),+) => {
+        $(
+            impl PowerStateExt for $state {
+                fn total_raw_byte_power(&self) -> &StoragePower {
+                    &self.total_raw_byte_power
+                }
+                fn total_quality_adj_power(&self) -> &StoragePower {
+                    &self.total_quality_adj_power
+                }
+                fn this_epoch_raw_byte_power(&self) -> &StoragePower {
+                    &self.this_epoch_raw_byte_power
+                }
+                fn this_epoch_quality_adj_power(&self) -> &StoragePower {
+                    &self.this_epoch_quality_adj_power
+                }
+                fn miner_count(&self) -> i64 {
+                    self.miner_count
+                }
+                fn total_pledge_collateral(&self) -> &TokenAmount {
+                    &self.total_pledge_collateral
+                }
+            }
+        )+
+    };
+}
+
+impl_power_state_ext!(fil_actor_power_v8::State, fil_actor_power_v9::State);
+
+#[cfg(test)]
+mod tests {
+    use cid::multihash::Code;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_ipld_encoding::CborStore;
+
+    use super::*;
+
+    macro_rules! check_power_totals {
+        ($state:ty) => {{
+            let store = MemoryBlockstore::default();
+            let mut state = <$state>::new(&store).unwrap();
+            state.total_raw_byte_power = StoragePower::from(1_000);
+            state.total_quality_adj_power = StoragePower::from(2_000);
+            state.this_epoch_raw_byte_power = StoragePower::from(900);
+            state.this_epoch_quality_adj_power = StoragePower::from(1_800);
+            state.miner_count = 3;
+            state.total_pledge_collateral = TokenAmount::from_whole(70);
+            let head = store.put_cbor(&state, Code::Blake2b256).unwrap();
+
+            let loaded: $state = store.get_cbor(&head).unwrap().unwrap();
+            let ext: &dyn PowerStateExt = &loaded;
+            assert_eq!(&loaded.total_raw_byte_power, ext.total_raw_byte_power());
+            assert_eq!(
+                &loaded.total_quality_adj_power,
+                ext.total_quality_adj_power()
+            );
+            assert_eq!(
+                &loaded.this_epoch_raw_byte_power,
+                ext.this_epoch_raw_byte_power()
+            );
+            assert_eq!(
+                &loaded.this_epoch_quality_adj_power,
+                ext.this_epoch_quality_adj_power()
+            );
+            assert_eq!(loaded.miner_count, ext.miner_count());
+
+            assert_eq!(&StoragePower::from(1_000), ext.total_raw_byte_power());
+            assert_eq!(&StoragePower::from(2_000), ext.total_quality_adj_power());
+            assert_eq!(&StoragePower::from(900), ext.this_epoch_raw_byte_power());
+            assert_eq!(
+                &StoragePower::from(1_800),
+                ext.this_epoch_quality_adj_power()
+            );
+            assert_eq!(3, ext.miner_count());
+            assert_eq!(&TokenAmount::from_whole(70), ext.total_pledge_collateral());
+        }};
+    }
+
+    #[test]
+    fn power_totals_parity() {
+        check_power_totals!(fil_actor_power_v8::State);
+        check_power_totals!(fil_actor_power_v9::State);
+    }
+}
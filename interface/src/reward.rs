@@ -0,0 +1,72 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use fvm_shared::econ::TokenAmount;
+use fvm_shared::sector::Spacetime;
+
+/// Read access to the cumulative reward statistics tracked by the reward actor in all versions.
+pub trait RewardStateExt {
+    /// Total FIL awarded to block producers.
+    fn total_storage_power_reward(&self) -> &TokenAmount;
+    /// Cumulative baseline power the network is targeting, in byte-epochs. Effective network
+    /// time advances while the realized sum keeps up with it.
+    fn cumsum_baseline(&self) -> &Spacetime;
+    /// Cumulative network power, capped by the baseline at each epoch, in byte-epochs.
+    fn cumsum_realized(&self) -> &Spacetime;
+}
+
+macro_rules! impl_reward_state_ext {
+    ($($state:ty),+) => {
+        $(
+            impl RewardStateExt for $state {
+                fn total_storage_power_reward(&self) -> &TokenAmount {
+                    &self.total_storage_power_reward
+                }
+                fn cumsum_baseline(&self) -> &Spacetime {
+                    &self.cumsum_baseline
+                }
+                fn cumsum_realized(&self) -> &Spacetime {
+                    &self.cumsum_realized
+                }
+            }
+        )+
+    };
+}
+
+impl_reward_state_ext!(fil_actor_reward_v8::State, fil_actor_reward_v9::State);
+
+#[cfg(test)]
+mod tests {
+    use cid::multihash::Code;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_ipld_encoding::CborStore;
+    use fvm_shared::sector::StoragePower;
+
+    use super::*;
+
+    macro_rules! check_reward_totals {
+        ($state:ty) => {{
+            let store = MemoryBlockstore::default();
+            let mut state = <$state>::new(StoragePower::from(1 << 20));
+            state.total_storage_power_reward = TokenAmount::from_whole(1_000);
+            state.cumsum_baseline = Spacetime::from(5_000);
+            state.cumsum_realized = Spacetime::from(4_000);
+            let head = store.put_cbor(&state, Code::Blake2b256).unwrap();
+
+            let loaded: $state = store.get_cbor(&head).unwrap().unwrap();
+            let ext: &dyn RewardStateExt = &loaded;
+            assert_eq!(
+                &TokenAmount::from_whole(1_000),
+                ext.total_storage_power_reward()
+            );
+            assert_eq!(&Spacetime::from(5_000), ext.cumsum_baseline());
+            assert_eq!(&Spacetime::from(4_000), ext.cumsum_realized());
+        }};
+    }
+
+    #[test]
+    fn reward_totals_parity() {
+        check_reward_totals!(fil_actor_reward_v8::State);
+        check_reward_totals!(fil_actor_reward_v9::State);
+    }
+}
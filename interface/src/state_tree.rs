@@ -0,0 +1,515 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use anyhow::anyhow;
+use cid::Cid;
+use fil_actors_runtime_v9::fvm_ipld_hamt::BytesKey;
+use fil_actors_runtime_v9::{make_map_with_root_and_bitwidth, Keyer};
+use fvm_ipld_blockstore::Blockstore;
+use fvm_ipld_encoding::serde_bytes;
+use fvm_ipld_encoding::tuple::*;
+use fvm_shared::address::{Address, Protocol};
+use fvm_shared::econ::TokenAmount;
+use fvm_shared::{ActorID, HAMT_BIT_WIDTH};
+use libipld_core::ipld::Ipld;
+use serde::de::DeserializeOwned;
+use serde::Serialize;
+
+use crate::error::{load_cbor, StateError};
+use crate::EthAddress;
+
+/// ID of the init actor, which maps robust and delegated addresses to actor IDs.
+const INIT_ACTOR_ID: ActorID = 1;
+
+/// First state tree version whose actor entries record the actor's delegated address.
+const DELEGATED_ADDRESS_VERSION: u64 = 5;
+
+/// An actor's entry in the state tree, as Lotus' `types.Actor`, whichever layout the state tree
+/// version encodes it with.
+#[derive(Clone, Debug, PartialEq, Eq)]
+pub struct ActorEntry {
+    /// Code CID of the actor, identifying its type and actors version.
+    pub code: Cid,
+    /// Root of the actor's state.
+    pub head: Cid,
+    /// Number of messages sent by the actor, the next message's `CallSeqNum`.
+    pub nonce: u64,
+    pub balance: TokenAmount,
+    /// Byte encoding of the actor's `f4` delegated address, if it has one. Only recorded from
+    /// state tree version 5, and kept as bytes as the `fvm_shared` 2 address type cannot
+    /// represent it.
+    pub delegated_address: Option<Vec<u8>>,
+}
+
+/// Layout of an actor entry up to state tree version 4.
+#[derive(Clone, Serialize_tuple, Deserialize_tuple)]
+struct ActorV4 {
+    code: Cid,
+    head: Cid,
+    nonce: u64,
+    balance: TokenAmount,
+}
+
+/// Layout of an actor entry from state tree version 5.
+#[derive(Clone, Serialize_tuple, Deserialize_tuple)]
+struct ActorV5 {
+    code: Cid,
+    head: Cid,
+    nonce: u64,
+    balance: TokenAmount,
+    #[serde(with = "serde_bytes")]
+    delegated_address: Option<Vec<u8>>,
+}
+
+impl From<ActorV4> for ActorEntry {
+    fn from(actor: ActorV4) -> Self {
+        Self {
+            code: actor.code,
+            head: actor.head,
+            nonce: actor.nonce,
+            balance: actor.balance,
+            delegated_address: None,
+        }
+    }
+}
+
+impl From<ActorV5> for ActorEntry {
+    fn from(actor: ActorV5) -> Self {
+        Self {
+            code: actor.code,
+            head: actor.head,
+            nonce: actor.nonce,
+            balance: actor.balance,
+            delegated_address: actor.delegated_address,
+        }
+    }
+}
+
+/// Calls `f` with the address and entry of every actor in the state tree at `root`, in the order
+/// of the actors HAMT.
+///
+/// `root` is either a versioned state root (`[version, actors, info]`) or, for state tree
+/// version 0, the actors HAMT itself.
+pub fn walk_state_tree<BS, F>(store: &BS, root: &Cid, f: F) -> Result<(), StateError>
+where
+    BS: Blockstore,
+    F: FnMut(Address, &ActorEntry) -> anyhow::Result<()>,
+{
+    let (version, actors) = actors_root(store, root)?;
+    if version < DELEGATED_ADDRESS_VERSION {
+        walk_actors::<_, ActorV4, _>(store, &actors, f)
+    } else {
+        walk_actors::<_, ActorV5, _>(store, &actors, f)
+    }
+}
+
+fn walk_actors<BS, V, F>(store: &BS, actors: &Cid, mut f: F) -> Result<(), StateError>
+where
+    BS: Blockstore,
+    V: DeserializeOwned + Serialize + Clone + Into<ActorEntry>,
+    F: FnMut(Address, &ActorEntry) -> anyhow::Result<()>,
+{
+    let map = make_map_with_root_and_bitwidth::<_, V>(actors, store, HAMT_BIT_WIDTH)
+        .map_err(|e| anyhow!("failed to load actors HAMT {}: {}", actors, e))?;
+    map.for_each(|key, actor| {
+        let addr = Address::from_bytes(&key.0)
+            .map_err(|e| anyhow!("invalid actor address {:?}: {}", key.0, e))?;
+        f(addr, &actor.clone().into())
+    })
+    .map_err(|e| anyhow!("failed to walk actors HAMT {}: {}", actors, e))?;
+    Ok(())
+}
+
+/// Returns the entry of the actor at `addr` in the state tree at `root`, or `None` if there is no
+/// actor at that address. Only the address the actor is keyed by (its ID address) is looked up.
+pub fn get_actor<BS: Blockstore>(
+    store: &BS,
+    root: &Cid,
+    addr: &Address,
+) -> Result<Option<ActorEntry>, StateError> {
+    let (version, actors) = actors_root(store, root)?;
+    if version < DELEGATED_ADDRESS_VERSION {
+        lookup_actor::<_, ActorV4>(store, &actors, addr)
+    } else {
+        lookup_actor::<_, ActorV5>(store, &actors, addr)
+    }
+}
+
+fn lookup_actor<BS, V>(
+    store: &BS,
+    actors: &Cid,
+    addr: &Address,
+) -> Result<Option<ActorEntry>, StateError>
+where
+    BS: Blockstore,
+    V: DeserializeOwned + Serialize + Clone + Into<ActorEntry>,
+{
+    let map = make_map_with_root_and_bitwidth::<_, V>(actors, store, HAMT_BIT_WIDTH)
+        .map_err(|e| anyhow!("failed to load actors HAMT {}: {}", actors, e))?;
+    let actor = map
+        .get(&addr.key())
+        .map_err(|e| anyhow!("failed to get actor {}: {}", addr, e))?;
+    Ok(actor.cloned().map(Into::into))
+}
+
+/// Resolves an Ethereum address to the ID of the actor it refers to in the state tree at `root`.
+///
+/// A masked-ID address resolves directly to its embedded ID. Any other address is looked up, as
+/// its `f410` delegated address, in the init actor's address map, and is `None` if no contract or
+/// account has been deployed at it.
+pub fn resolve_eth_address<BS: Blockstore>(
+    store: &BS,
+    root: &Cid,
+    addr: &EthAddress,
+) -> Result<Option<ActorID>, StateError> {
+    if let Some(id) = addr.masked_id() {
+        return Ok(Some(id));
+    }
+    lookup_init_address(store, root, &addr.key())
+}
+
+/// Resolves `addr` to the ID address of the actor it refers to in the state tree at `root`, as the
+/// state tree is keyed by. ID addresses are returned unchanged, and robust addresses are looked up
+/// in the init actor's address map. It is an error if no actor has the robust address.
+pub fn normalize_address<BS: Blockstore>(
+    store: &BS,
+    root: &Cid,
+    addr: &Address,
+) -> Result<Address, StateError> {
+    if addr.protocol() == Protocol::ID {
+        return Ok(*addr);
+    }
+    let id = lookup_init_address(store, root, &addr.key())?
+        .ok_or_else(|| anyhow!("address {} not found in state tree {}", addr, root))?;
+    Ok(Address::new_id(id))
+}
+
+/// Looks up the ID of the actor keyed by `key`, the bytes of one of its addresses, in the address
+/// map of the init actor in the state tree at `root`.
+fn lookup_init_address<BS: Blockstore>(
+    store: &BS,
+    root: &Cid,
+    key: &BytesKey,
+) -> Result<Option<ActorID>, StateError> {
+    let init = get_actor(store, root, &Address::new_id(INIT_ACTOR_ID))?
+        .ok_or_else(|| anyhow!("init actor not found in state tree {}", root))?;
+    let state: fil_actor_init_v9::State = load_cbor(store, &init.head)?;
+    let map =
+        make_map_with_root_and_bitwidth::<_, ActorID>(&state.address_map, store, HAMT_BIT_WIDTH)
+            .map_err(|e| {
+                anyhow!(
+                    "failed to load init address map {}: {}",
+                    state.address_map,
+                    e
+                )
+            })?;
+    let id = map
+        .get(key)
+        .map_err(|e| anyhow!("failed to look up address {:?}: {}", key.0, e))?;
+    Ok(id.copied())
+}
+
+/// Returns the version and the root of the actors HAMT of the state tree at `root`.
+fn actors_root<BS: Blockstore>(store: &BS, root: &Cid) -> Result<(u64, Cid), StateError> {
+    Ok(match load_cbor(store, root)? {
+        Ipld::List(fields) => match fields.as_slice() {
+            [Ipld::Integer(version), Ipld::Link(actors), Ipld::Link(_)] => {
+                let version = u64::try_from(*version)
+                    .map_err(|_| anyhow!("invalid state tree version {}", version))?;
+                (version, *actors)
+            }
+            _ => (0, *root),
+        },
+        _ => (0, *root),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashMap;
+
+    use cid::multihash::{Code, Multihash};
+    use fil_actors_runtime_v9::make_empty_map;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_ipld_encoding::CborStore;
+
+    use super::*;
+
+    const IPLD_RAW: u64 = 0x55;
+
+    fn actors(store: &MemoryBlockstore) -> (Cid, HashMap<Address, ActorEntry>) {
+        let code = Cid::default();
+        let head = store.put_cbor(&(), Code::Blake2b256).unwrap();
+        let entries: HashMap<_, _> = [
+            (Address::new_id(0), 0u128),
+            (Address::new_id(100), 1_000),
+            (
+                Address::new_secp256k1(&[7; 65]).unwrap(),
+                1_000_000_000_000_000_000,
+            ),
+        ]
+        .into_iter()
+        .enumerate()
+        .map(|(nonce, (addr, balance))| {
+            let actor = ActorEntry {
+                code,
+                head,
+                nonce: nonce as u64,
+                balance: TokenAmount::from_atto(balance),
+                delegated_address: None,
+            };
+            (addr, actor)
+        })
+        .collect();
+
+        (put_actors_v4(store, &entries), entries)
+    }
+
+    fn put_actors_v4(store: &MemoryBlockstore, entries: &HashMap<Address, ActorEntry>) -> Cid {
+        let mut map = make_empty_map(store, HAMT_BIT_WIDTH);
+        for (addr, actor) in entries {
+            let actor = ActorV4 {
+                code: actor.code,
+                head: actor.head,
+                nonce: actor.nonce,
+                balance: actor.balance.clone(),
+            };
+            map.set(addr.key(), actor).unwrap();
+        }
+        map.flush().unwrap()
+    }
+
+    fn put_actors_v5(store: &MemoryBlockstore, entries: &HashMap<Address, ActorEntry>) -> Cid {
+        let mut map = make_empty_map(store, HAMT_BIT_WIDTH);
+        for (addr, actor) in entries {
+            let actor = ActorV5 {
+                code: actor.code,
+                head: actor.head,
+                nonce: actor.nonce,
+                balance: actor.balance.clone(),
+                delegated_address: actor.delegated_address.clone(),
+            };
+            map.set(addr.key(), actor).unwrap();
+        }
+        map.flush().unwrap()
+    }
+
+    fn walk(store: &MemoryBlockstore, root: &Cid) -> HashMap<Address, ActorEntry> {
+        let mut found = HashMap::new();
+        walk_state_tree(store, root, |addr, actor| {
+            assert!(found.insert(addr, actor.clone()).is_none());
+            Ok(())
+        })
+        .unwrap();
+        found
+    }
+
+    #[test]
+    fn walk_versioned_state_root() {
+        let store = MemoryBlockstore::default();
+        let (actors, entries) = actors(&store);
+        let info = store.put_cbor(&(), Code::Blake2b256).unwrap();
+        let root = store
+            .put_cbor(&(4u64, actors, info), Code::Blake2b256)
+            .unwrap();
+        let found = walk(&store, &root);
+        assert_eq!(entries, found);
+        assert_eq!(
+            TokenAmount::from_atto(1_000),
+            found[&Address::new_id(100)].balance
+        );
+    }
+
+    #[test]
+    fn walk_version_zero_state_root() {
+        let store = MemoryBlockstore::default();
+        let (actors, entries) = actors(&store);
+        assert_eq!(entries, walk(&store, &actors));
+    }
+
+    #[test]
+    fn walk_version_five_state_root() {
+        let store = MemoryBlockstore::default();
+        let (_, mut entries) = actors(&store);
+        let contract = EthAddress::from_hex("0xd4c5fb16488aa48081296299d54b0c648c9333da").unwrap();
+        let head = store.put_cbor(&(), Code::Blake2b256).unwrap();
+        entries.insert(
+            Address::new_id(1234),
+            ActorEntry {
+                code: Cid::default(),
+                head,
+                nonce: 7,
+                balance: TokenAmount::from_atto(5),
+                delegated_address: Some(contract.delegated_address_bytes()),
+            },
+        );
+        let actors = put_actors_v5(&store, &entries);
+        let info = store.put_cbor(&(), Code::Blake2b256).unwrap();
+        let root = store
+            .put_cbor(&(5u64, actors, info), Code::Blake2b256)
+            .unwrap();
+        assert_eq!(entries, walk(&store, &root));
+        let actor = get_actor(&store, &root, &Address::new_id(1234))
+            .unwrap()
+            .unwrap();
+        assert_eq!(7, actor.nonce);
+        assert_eq!(
+            Some(contract.delegated_address_bytes()),
+            actor.delegated_address
+        );
+
+        // Version 5 entries have the extra field.
+        let v4 = store
+            .put_cbor(&(4u64, actors, info), Code::Blake2b256)
+            .unwrap();
+        assert!(walk_state_tree(&store, &v4, |_, _| Ok(())).is_err());
+    }
+
+    // Expected roots are the DAG-CBOR encodings of Lotus' state tree: go-hamt-ipld (v3) nodes
+    // holding types.ActorV4 or types.ActorV5 entries, under a StateRoot with a StateInfo0 info
+    // block. Code CIDs are identity CIDs, as actors versions used before bundles.
+    #[test]
+    fn state_tree_roots_match_go() {
+        let store = MemoryBlockstore::default();
+        let code = |name: &str| Cid::new_v1(IPLD_RAW, Multihash::wrap(0, name.as_bytes()).unwrap());
+        // An empty tuple, both as the actors' head and the StateInfo0 info block.
+        let empty = store.put_cbor(&[0u64; 0], Code::Blake2b256).unwrap();
+        let actor = |name: &str, nonce: u64, balance: u128| ActorEntry {
+            code: code(name),
+            head: empty,
+            nonce,
+            balance: TokenAmount::from_atto(balance),
+            delegated_address: None,
+        };
+        let mut entries = HashMap::from([
+            (Address::new_id(0), actor("fil/9/system", 0, 0)),
+            (
+                Address::new_id(100),
+                actor("fil/9/account", 3, 10_000_000_000_000_000_000),
+            ),
+        ]);
+        let root = |actors: Cid, version: u64| {
+            store
+                .put_cbor(&(version, actors, empty), Code::Blake2b256)
+                .unwrap()
+                .to_string()
+        };
+
+        let actors = put_actors_v4(&store, &entries);
+        assert_eq!(
+            "bafy2bzaceckc4p3utig7jv44j6l4albtc2ilryfbybe3ejvjiqee5hobmkr6o",
+            actors.to_string()
+        );
+        assert_eq!(
+            "bafy2bzacebkmgge7d62ycn4ae4nv3ebxmdkab45vyl3tezflylx3jetb5gvjy",
+            root(actors, 4)
+        );
+
+        let contract = EthAddress::from_hex("0xd4c5fb16488aa48081296299d54b0c648c9333da").unwrap();
+        entries.insert(
+            Address::new_id(1234),
+            ActorEntry {
+                delegated_address: Some(contract.delegated_address_bytes()),
+                ..actor("fil/10/evm", 1, 5)
+            },
+        );
+        let actors = put_actors_v5(&store, &entries);
+        assert_eq!(
+            "bafy2bzacecn7qfsp7x6a7vdnqxeuo7zy3h436fi2x6jgsunjio64zsstmcigw",
+            actors.to_string()
+        );
+        assert_eq!(
+            "bafy2bzacecizfda6q7dt5xzakrk3a46zjvqwz5bba2ivibyhmzopsp76l4eik",
+            root(actors, 5)
+        );
+    }
+
+    #[test]
+    fn walk_stops_on_callback_error() {
+        let store = MemoryBlockstore::default();
+        let (actors, _) = actors(&store);
+        let mut visited = 0;
+        let res = walk_state_tree(&store, &actors, |_, _| {
+            visited += 1;
+            Err(anyhow!("stop"))
+        });
+        assert!(res.is_err());
+        assert_eq!(1, visited);
+
+        assert!(matches!(
+            walk_state_tree(&store, &Cid::default(), |_, _| Ok(())),
+            Err(StateError::NotFound(_))
+        ));
+    }
+
+    /// Returns a state tree holding only an init actor that maps each of `addresses` to its ID.
+    fn init_state_tree(store: &MemoryBlockstore, addresses: &[(Vec<u8>, ActorID)]) -> Cid {
+        let mut init = fil_actor_init_v9::State::new(store, "test".to_string()).unwrap();
+        let mut address_map =
+            make_map_with_root_and_bitwidth::<_, ActorID>(&init.address_map, store, HAMT_BIT_WIDTH)
+                .unwrap();
+        for (addr, id) in addresses {
+            address_map.set(addr.clone().into(), *id).unwrap();
+        }
+        init.address_map = address_map.flush().unwrap();
+
+        let mut actors = make_empty_map(store, HAMT_BIT_WIDTH);
+        let init_actor = ActorV4 {
+            code: Cid::default(),
+            head: store.put_cbor(&init, Code::Blake2b256).unwrap(),
+            nonce: 0,
+            balance: TokenAmount::from_atto(0),
+        };
+        actors
+            .set(Address::new_id(INIT_ACTOR_ID).key(), init_actor)
+            .unwrap();
+        actors.flush().unwrap()
+    }
+
+    #[test]
+    fn resolve_eth_addresses() {
+        let store = MemoryBlockstore::default();
+        let contract = EthAddress::from_hex("0xd4c5fb16488aa48081296299d54b0c648c9333da").unwrap();
+        let root = init_state_tree(&store, &[(contract.delegated_address_bytes(), 1234)]);
+        let init_actor = get_actor(&store, &root, &Address::new_id(INIT_ACTOR_ID))
+            .unwrap()
+            .unwrap();
+        let init: fil_actor_init_v9::State = store.get_cbor(&init_actor.head).unwrap().unwrap();
+        assert_eq!("test", init.network_name);
+        assert_eq!(None, get_actor(&store, &root, &Address::new_id(2)).unwrap());
+
+        // Deployed contract, through the init actor's address map.
+        assert_eq!(
+            Some(1234),
+            resolve_eth_address(&store, &root, &contract).unwrap()
+        );
+        // Masked ID, without a lookup.
+        let masked = EthAddress::from_hex("0xff00000000000000000000000000000000000400").unwrap();
+        assert_eq!(
+            Some(1024),
+            resolve_eth_address(&store, &root, &masked).unwrap()
+        );
+        // Not deployed.
+        let unknown = EthAddress::from_hex("0x0000000000000000000000000000000000000001").unwrap();
+        assert_eq!(None, resolve_eth_address(&store, &root, &unknown).unwrap());
+    }
+
+    #[test]
+    fn normalize_addresses() {
+        let store = MemoryBlockstore::default();
+        let account = Address::new_secp256k1(&[7; 65]).unwrap();
+        let root = init_state_tree(&store, &[(account.to_bytes(), 1001)]);
+
+        assert_eq!(
+            Address::new_id(1001),
+            normalize_address(&store, &root, &account).unwrap()
+        );
+        // ID addresses pass through, whether or not the actor exists.
+        assert_eq!(
+            Address::new_id(5000),
+            normalize_address(&store, &root, &Address::new_id(5000)).unwrap()
+        );
+        let unknown = Address::new_secp256k1(&[8; 65]).unwrap();
+        assert!(normalize_address(&store, &root, &unknown).is_err());
+    }
+}
@@ -0,0 +1,108 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use cid::Cid;
+use fvm_ipld_blockstore::Blockstore;
+
+/// The raw block access of a node's own store, such as Forest's, which does not implement
+/// [`Blockstore`].
+pub trait RawStore {
+    /// Returns the bytes of block `k`, or `None` if the store does not have it.
+    fn get_bytes(&self, k: &Cid) -> anyhow::Result<Option<Vec<u8>>>;
+
+    /// Writes `block` under `k`.
+    fn put_bytes(&self, k: &Cid, block: &[u8]) -> anyhow::Result<()>;
+
+    /// Returns whether the store has block `k`.
+    fn has_bytes(&self, k: &Cid) -> anyhow::Result<bool> {
+        Ok(self.get_bytes(k)?.is_some())
+    }
+}
+
+impl<S: RawStore> RawStore for &S {
+    fn get_bytes(&self, k: &Cid) -> anyhow::Result<Option<Vec<u8>>> {
+        (**self).get_bytes(k)
+    }
+
+    fn put_bytes(&self, k: &Cid, block: &[u8]) -> anyhow::Result<()> {
+        (**self).put_bytes(k, block)
+    }
+
+    fn has_bytes(&self, k: &Cid) -> anyhow::Result<bool> {
+        (**self).has_bytes(k)
+    }
+}
+
+/// Exposes a [`RawStore`] as a [`Blockstore`], so the state loaders of this crate and the actor
+/// crates can read from it. Every call is forwarded, with no caching or copying of its own.
+#[derive(Clone, Copy, Debug, Default)]
+pub struct StoreAdapter<S>(pub S);
+
+impl<S: RawStore> Blockstore for StoreAdapter<S> {
+    fn get(&self, k: &Cid) -> anyhow::Result<Option<Vec<u8>>> {
+        self.0.get_bytes(k)
+    }
+
+    fn put_keyed(&self, k: &Cid, block: &[u8]) -> anyhow::Result<()> {
+        self.0.put_bytes(k, block)
+    }
+
+    fn has(&self, k: &Cid) -> anyhow::Result<bool> {
+        self.0.has_bytes(k)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::cell::{Cell, RefCell};
+    use std::collections::HashMap;
+
+    use cid::multihash::Code;
+    use fil_actor_market_v9::{DealArray, State};
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_ipld_encoding::CborStore;
+
+    use super::*;
+
+    /// A store with its own interface, counting the blocks read from it.
+    #[derive(Default)]
+    struct NodeStore {
+        blocks: RefCell<HashMap<Cid, Vec<u8>>>,
+        reads: Cell<usize>,
+    }
+
+    impl RawStore for NodeStore {
+        fn get_bytes(&self, k: &Cid) -> anyhow::Result<Option<Vec<u8>>> {
+            self.reads.set(self.reads.get() + 1);
+            Ok(self.blocks.borrow().get(k).cloned())
+        }
+
+        fn put_bytes(&self, k: &Cid, block: &[u8]) -> anyhow::Result<()> {
+            self.blocks.borrow_mut().insert(*k, block.to_vec());
+            Ok(())
+        }
+    }
+
+    #[test]
+    fn load_market_state_through_adapter() {
+        let node = NodeStore::default();
+        let store = StoreAdapter(&node);
+        let mut st = State::new(&store).unwrap();
+        st.next_id = 7;
+        let root = store.put_cbor(&st, Code::Blake2b256).unwrap();
+
+        // The same state written to a memory blockstore has the same CID.
+        let mem = MemoryBlockstore::default();
+        let expected = State::new(&mem).unwrap();
+        assert_eq!(st.proposals, expected.proposals);
+        assert!(store.has(&root).unwrap());
+
+        node.reads.set(0);
+        let loaded: State = store.get_cbor(&root).unwrap().unwrap();
+        assert_eq!(7, loaded.next_id);
+        let proposals = DealArray::load(&loaded.proposals, &store).unwrap();
+        assert_eq!(0, proposals.count());
+        // One read of the state and one of the proposals root, each forwarded once.
+        assert_eq!(2, node.reads.get());
+    }
+}
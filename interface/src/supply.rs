@@ -0,0 +1,139 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use fvm_shared::clock::ChainEpoch;
+use fvm_shared::econ::TokenAmount;
+
+use crate::{MarketStateExt, PowerStateExt, RewardStateExt};
+
+/// FIL held by the mining reserve actor, f090, at genesis.
+const INITIAL_RESERVE_FIL: u64 = 300_000_000;
+
+/// The components of the circulating supply, as reported by Lotus'
+/// `StateVMCirculatingSupplyInternal`.
+#[derive(Clone, Debug, Default, PartialEq, Eq)]
+pub struct CirculatingSupply {
+    /// FIL released so far by the genesis vesting schedules.
+    pub fil_vested: TokenAmount,
+    /// FIL awarded to block producers.
+    pub fil_mined: TokenAmount,
+    /// FIL sent to the burnt funds actor, f099.
+    pub fil_burnt: TokenAmount,
+    /// FIL locked as deal collateral and fees, and as miner pledge.
+    pub fil_locked: TokenAmount,
+    /// FIL paid out of the mining reserve.
+    pub fil_reserve_disbursed: TokenAmount,
+    /// Vested, mined and disbursed FIL less burnt and locked FIL, or zero if that is negative.
+    pub fil_circulating: TokenAmount,
+}
+
+/// Computes the circulating supply at `epoch` the way Lotus does for networks past the actors v2
+/// upgrade, from the states of the reward, power and market actors and the balances of the
+/// burnt funds and reserve actors.
+///
+/// `genesis_vesting` holds the vesting schedules of the genesis allocations. These are not read
+/// from the chain but built into the node for each network, as multisig states whose initial
+/// balance unlocks linearly from their start epoch.
+pub fn circulating_supply<R, P, M>(
+    epoch: ChainEpoch,
+    genesis_vesting: &[fil_actor_multisig_v9::State],
+    reward: &R,
+    power: &P,
+    market: &M,
+    burnt_balance: &TokenAmount,
+    reserve_balance: &TokenAmount,
+) -> CirculatingSupply
+where
+    R: RewardStateExt,
+    P: PowerStateExt,
+    M: MarketStateExt,
+{
+    let mut fil_vested = TokenAmount::default();
+    for schedule in genesis_vesting {
+        fil_vested += &schedule.initial_balance;
+        fil_vested -= schedule.amount_locked(epoch - schedule.start_epoch);
+    }
+    let fil_mined = reward.total_storage_power_reward().clone();
+    let fil_burnt = burnt_balance.clone();
+    let fil_locked = market.total_locked() + power.total_pledge_collateral();
+    let fil_reserve_disbursed = TokenAmount::from_whole(INITIAL_RESERVE_FIL) - reserve_balance;
+
+    let mut fil_circulating =
+        &fil_vested + &fil_mined + &fil_reserve_disbursed - &fil_burnt - &fil_locked;
+    if fil_circulating.is_negative() {
+        fil_circulating = TokenAmount::default();
+    }
+    CirculatingSupply {
+        fil_vested,
+        fil_mined,
+        fil_burnt,
+        fil_locked,
+        fil_reserve_disbursed,
+        fil_circulating,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use cid::Cid;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_shared::sector::StoragePower;
+
+    use super::*;
+
+    fn vesting(
+        fil: u64,
+        start_epoch: ChainEpoch,
+        unlock_duration: ChainEpoch,
+    ) -> fil_actor_multisig_v9::State {
+        fil_actor_multisig_v9::State {
+            signers: vec![],
+            num_approvals_threshold: 0,
+            next_tx_id: Default::default(),
+            initial_balance: TokenAmount::from_whole(fil),
+            start_epoch,
+            unlock_duration,
+            pending_txs: Cid::default(),
+        }
+    }
+
+    #[test]
+    fn circulating_supply_components() {
+        let store = MemoryBlockstore::default();
+        let mut reward = fil_actor_reward_v9::State::new(StoragePower::from(1 << 20));
+        reward.total_storage_power_reward = TokenAmount::from_whole(40);
+        let mut power = fil_actor_power_v9::State::new(&store).unwrap();
+        power.total_pledge_collateral = TokenAmount::from_whole(7);
+        let mut market = fil_actor_market_v9::State::new(&store).unwrap();
+        market.total_client_locked_collateral = TokenAmount::from_whole(3);
+        market.total_provider_locked_collateral = TokenAmount::from_whole(2);
+        market.total_client_storage_fee = TokenAmount::from_whole(1);
+
+        // A quarter of the way through the first schedule, before the second one starts.
+        let genesis = [vesting(1000, 0, 100), vesting(500, 50, 100)];
+        let burnt = TokenAmount::from_whole(5);
+        let reserve = TokenAmount::from_whole(INITIAL_RESERVE_FIL - 10);
+        let supply = circulating_supply(25, &genesis, &reward, &power, &market, &burnt, &reserve);
+        assert_eq!(
+            CirculatingSupply {
+                fil_vested: TokenAmount::from_whole(250),
+                fil_mined: TokenAmount::from_whole(40),
+                fil_burnt: TokenAmount::from_whole(5),
+                fil_locked: TokenAmount::from_whole(13),
+                fil_reserve_disbursed: TokenAmount::from_whole(10),
+                fil_circulating: TokenAmount::from_whole(282),
+            },
+            supply
+        );
+
+        // Both schedules fully vested.
+        let supply = circulating_supply(200, &genesis, &reward, &power, &market, &burnt, &reserve);
+        assert_eq!(TokenAmount::from_whole(1500), supply.fil_vested);
+        assert_eq!(TokenAmount::from_whole(1532), supply.fil_circulating);
+
+        // More burnt than released is reported as no supply.
+        let burnt = TokenAmount::from_whole(10_000);
+        let supply = circulating_supply(25, &genesis, &reward, &power, &market, &burnt, &reserve);
+        assert_eq!(TokenAmount::default(), supply.fil_circulating);
+    }
+}
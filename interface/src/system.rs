@@ -0,0 +1,50 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use cid::Cid;
+
+/// Read access to the system actor state common to all versions.
+///
+/// Every shipped version records the builtin actors manifest; versions predating it (v0 to v7)
+/// are not part of this workspace, so there is no version without the field to gate.
+pub trait SystemStateExt {
+    /// Root of the builtin actors registry, `Vec<(String, Cid)>` of actor name to code CID.
+    fn builtin_actors(&self) -> &Cid;
+}
+
+macro_rules! impl_system_state_ext {
+    ($($state:ty),+) => {
+        $(
+            impl SystemStateExt for $state {
+                fn builtin_actors(&self) -> &Cid {
+                    &self.builtin_actors
+                }
+            }
+        )+
+    };
+}
+
+impl_system_state_ext!(fil_actor_system_v8::State, fil_actor_system_v9::State);
+
+#[cfg(test)]
+mod tests {
+    use cid::multihash::{Code, MultihashDigest};
+    use fvm_ipld_encoding::DAG_CBOR;
+
+    use super::*;
+
+    #[test]
+    fn builtin_actors_parity() {
+        let root = Cid::new_v1(DAG_CBOR, Code::Blake2b256.digest(b"manifest"));
+        let v8 = fil_actor_system_v8::State {
+            builtin_actors: root,
+        };
+        let v9 = fil_actor_system_v9::State {
+            builtin_actors: root,
+        };
+        let states: [&dyn SystemStateExt; 2] = [&v8, &v9];
+        for state in states {
+            assert_eq!(&root, state.builtin_actors());
+        }
+    }
+}
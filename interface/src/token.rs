@@ -0,0 +1,92 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use anyhow::anyhow;
+use fvm_shared::bigint::{BigInt, Sign};
+use fvm_shared::econ::TokenAmount;
+
+/// Maximum length of an encoded big integer, including the sign byte.
+const MAX_ENCODED_SIZE: usize = 128;
+
+/// Explicit conversion of token amounts to and from the byte string used for big integers in
+/// Filecoin CBOR, matching Go's `big.Int` encoding.
+///
+/// Zero encodes to empty bytes. Any other value encodes to a sign byte (`0` for positive, `1` for
+/// negative) followed by the big-endian magnitude.
+pub trait TokenAmountCborExt: Sized {
+    fn to_cbor_bytes(&self) -> Vec<u8>;
+    fn from_cbor_bytes(bytes: &[u8]) -> anyhow::Result<Self>;
+}
+
+impl TokenAmountCborExt for TokenAmount {
+    fn to_cbor_bytes(&self) -> Vec<u8> {
+        let (sign, mut magnitude) = self.atto().to_bytes_be();
+        let sign_byte = match sign {
+            Sign::NoSign => return Vec::new(),
+            Sign::Plus => 0,
+            Sign::Minus => 1,
+        };
+        magnitude.insert(0, sign_byte);
+        magnitude
+    }
+
+    fn from_cbor_bytes(bytes: &[u8]) -> anyhow::Result<Self> {
+        if bytes.len() > MAX_ENCODED_SIZE {
+            return Err(anyhow!(
+                "big integer of {} bytes exceeds the maximum of {}",
+                bytes.len(),
+                MAX_ENCODED_SIZE
+            ));
+        }
+        let (sign_byte, magnitude) = match bytes.split_first() {
+            Some(split) => split,
+            None => return Ok(TokenAmount::from_atto(0)),
+        };
+        let sign = match sign_byte {
+            0 => Sign::Plus,
+            1 => Sign::Minus,
+            b => return Err(anyhow!("big integer sign byte must be 0 or 1, got {}", b)),
+        };
+        Ok(TokenAmount::from_atto(BigInt::from_bytes_be(
+            sign, magnitude,
+        )))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use fvm_ipld_encoding::{from_slice, to_vec, BytesDe};
+
+    use super::*;
+
+    fn check(amount: TokenAmount, expected: &[u8]) {
+        let bytes = amount.to_cbor_bytes();
+        assert_eq!(expected, bytes.as_slice());
+        assert_eq!(amount, TokenAmount::from_cbor_bytes(&bytes).unwrap());
+
+        // The explicit encoding is the payload of the serde CBOR byte string.
+        let BytesDe(payload) = from_slice(&to_vec(&amount).unwrap()).unwrap();
+        assert_eq!(bytes, payload);
+    }
+
+    #[test]
+    fn token_amount_cbor_bytes() {
+        check(TokenAmount::from_atto(0), &[]);
+        check(TokenAmount::from_atto(1), &[0x00, 0x01]);
+        check(TokenAmount::from_atto(-1), &[0x01, 0x01]);
+        check(TokenAmount::from_atto(256), &[0x00, 0x01, 0x00]);
+
+        let max = BigInt::parse_bytes(&[b'f'; 64], 16).unwrap();
+        let mut expected = vec![0x00];
+        expected.extend([0xff; 32]);
+        check(TokenAmount::from_atto(max.clone()), &expected);
+        expected[0] = 0x01;
+        check(TokenAmount::from_atto(-max), &expected);
+    }
+
+    #[test]
+    fn token_amount_invalid_cbor_bytes() {
+        assert!(TokenAmount::from_cbor_bytes(&[0x02, 0x01]).is_err());
+        assert!(TokenAmount::from_cbor_bytes(&[0x00; MAX_ENCODED_SIZE + 1]).is_err());
+    }
+}
@@ -1,6 +1,7 @@
 // Copyright 2019-2022 ChainSafe Systems
 // SPDX-License-Identifier: Apache-2.0, MIT
 
+use anyhow::anyhow;
 use cid::Cid;
 use fvm_ipld_blockstore::Blockstore;
 use fvm_ipld_hamt::Error as HamtError;
@@ -12,6 +13,20 @@ use fil_actors_runtime_v8::{make_empty_map, make_map_with_root_and_bitwidth, Map
 
 pub const BALANCE_TABLE_BITWIDTH: u32 = 6;
 
+/// Subtracts `amount` from a balance that must stay non-negative, and errors instead of
+/// returning a negative result.
+pub fn checked_sub(balance: &TokenAmount, amount: &TokenAmount) -> anyhow::Result<TokenAmount> {
+    let remaining = balance - amount;
+    if remaining.is_negative() {
+        return Err(anyhow!(
+            "subtracting {} from balance {} would leave it negative",
+            amount,
+            balance
+        ));
+    }
+    Ok(remaining)
+}
+
 /// Balance table which handles getting and updating token balances specifically
 pub struct BalanceTable<'a, BS>(pub Map<'a, BS, TokenAmount>);
 
@@ -85,12 +100,9 @@ where
     /// Subtracts value from a balance, and errors if full amount was not substracted.
     pub fn must_subtract(&mut self, key: &Address, req: &TokenAmount) -> Result<(), HamtError> {
         let prev = self.get(key)?;
-
-        if req > &prev {
-            Err("couldn't subtract the requested amount".into())
-        } else {
-            self.add(key, &-req)
-        }
+        checked_sub(&prev, req)
+            .map_err(|e| HamtError::Dynamic(e.context("couldn't subtract the requested amount")))?;
+        self.add(key, &-req)
     }
 
     /// Returns total balance held by this balance table
@@ -113,7 +125,7 @@ mod tests {
     use fvm_shared::address::Address;
     use fvm_shared::econ::TokenAmount;
 
-    use crate::balance_table::BalanceTable;
+    use crate::balance_table::{checked_sub, BalanceTable};
 
     #[test]
     fn total() {
@@ -201,4 +213,39 @@ mod tests {
             .must_subtract(&addr, &TokenAmount::from_atto(100u8))
             .is_err());
     }
+
+    #[test]
+    fn checked_sub_non_negative() {
+        let ten = TokenAmount::from_atto(10u8);
+        assert_eq!(
+            checked_sub(&ten, &TokenAmount::from_atto(3u8)).unwrap(),
+            TokenAmount::from_atto(7u8)
+        );
+        assert!(checked_sub(&ten, &ten).unwrap().is_zero());
+        assert!(checked_sub(&ten, &TokenAmount::from_atto(11u8)).is_err());
+
+        // Amounts well beyond u128 neither overflow nor panic.
+        let large = TokenAmount::from_whole(u64::MAX) * u64::MAX;
+        assert!(checked_sub(&large, &large).unwrap().is_zero());
+        assert_eq!(checked_sub(&large, &ten).unwrap(), &large - &ten);
+        assert!(checked_sub(&ten, &large).is_err());
+    }
+
+    #[test]
+    fn must_subtract_exact_balance_removes_entry() {
+        let addr = Address::new_id(100);
+        let store = MemoryBlockstore::default();
+        let mut bt = BalanceTable::new(&store);
+
+        bt.add(&addr, &TokenAmount::from_atto(80u8)).unwrap();
+        assert!(bt
+            .must_subtract(&addr, &TokenAmount::from_atto(81u8))
+            .is_err());
+        assert_eq!(bt.get(&addr).unwrap(), TokenAmount::from_atto(80u8));
+
+        bt.must_subtract(&addr, &TokenAmount::from_atto(80u8))
+            .unwrap();
+        assert!(bt.get(&addr).unwrap().is_zero());
+        assert!(bt.0.get(&addr.to_bytes()).unwrap().is_none());
+    }
 }
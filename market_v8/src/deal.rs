@@ -1,19 +1,23 @@
 // Copyright 2019-2022 ChainSafe Systems
 // SPDX-License-Identifier: Apache-2.0, MIT
 
+use crate::policy::detail::DEAL_MAX_LABEL_SIZE;
 use cid::{Cid, Version};
-use fil_actors_runtime_v8::DealWeight;
+use fil_actors_runtime_v8::{actor_error, ActorError, DealWeight};
 use fvm_ipld_encoding::tuple::*;
 use fvm_ipld_encoding::{BytesSer, Cbor};
 use fvm_shared::address::Address;
+use fvm_shared::bigint::BigInt;
 use fvm_shared::clock::ChainEpoch;
 use fvm_shared::commcid::{FIL_COMMITMENT_UNSEALED, SHA2_256_TRUNC254_PADDED};
 use fvm_shared::crypto::signature::Signature;
 use fvm_shared::econ::TokenAmount;
 use fvm_shared::piece::PaddedPieceSize;
 use libipld_core::ipld::Ipld;
-use serde::{de, Deserialize, Deserializer, Serialize, Serializer};
+use num_traits::Zero;
+use serde::{de, ser, Deserialize, Deserializer, Serialize, Serializer};
 use std::convert::{TryFrom, TryInto};
+use std::str::FromStr;
 
 /// Cid prefix for piece Cids
 pub fn is_piece_cid(c: &Cid) -> bool {
@@ -82,6 +86,38 @@ impl Label {
             Label::Bytes(b) => b.is_empty(),
         }
     }
+
+    /// Returns the label if it was encoded as a string. Labels encoded as bytes return `None`,
+    /// even if the bytes are valid UTF-8.
+    pub fn as_string(&self) -> Option<&str> {
+        match self {
+            Label::String(s) => Some(s),
+            Label::Bytes(_) => None,
+        }
+    }
+
+    /// Returns the raw bytes of the label, whichever way it was encoded.
+    pub fn as_bytes(&self) -> &[u8] {
+        match self {
+            Label::String(s) => s.as_bytes(),
+            Label::Bytes(b) => b,
+        }
+    }
+
+    /// Checks the label is no longer than the market actor accepts. A string label is valid
+    /// UTF-8 by construction, as decoding rejects any that is not, so only its length in bytes
+    /// is checked, the same limit as for a bytes label.
+    pub fn validate(&self) -> Result<(), ActorError> {
+        if self.len() > DEAL_MAX_LABEL_SIZE {
+            return Err(actor_error!(
+                illegal_argument,
+                "deal label can be at most {} bytes, is {}",
+                DEAL_MAX_LABEL_SIZE,
+                self.len()
+            ));
+        }
+        Ok(())
+    }
 }
 
 /// Note: Deal Collateral is only released and returned to clients and miners
@@ -92,7 +128,7 @@ impl Label {
 /// minimal deals that last for a long time.
 /// Note: ClientCollateralPerEpoch may not be needed and removed pending future confirmation.
 /// There will be a Minimum value for both client and provider deal collateral.
-#[derive(Clone, Debug, PartialEq, Serialize_tuple, Deserialize_tuple)]
+#[derive(Clone, Debug, PartialEq)]
 pub struct DealProposal {
     pub piece_cid: Cid,
     pub piece_size: PaddedPieceSize,
@@ -118,6 +154,135 @@ pub struct DealProposal {
 
 impl Cbor for DealProposal {}
 
+/// Encodes the proposal as its DAG-CBOR tuple for binary formats, or as the Lotus JSON object
+/// for human-readable ones. Lotus only accepts string labels in JSON.
+impl Serialize for DealProposal {
+    fn serialize<S>(&self, serializer: S) -> Result<S::Ok, S::Error>
+    where
+        S: Serializer,
+    {
+        if serializer.is_human_readable() {
+            let label = self.label.as_string().ok_or_else(|| {
+                <S::Error as ser::Error>::custom("can only marshal string labels to JSON")
+            })?;
+            LotusDealProposal {
+                piece_cid: LotusCid {
+                    cid: self.piece_cid.to_string(),
+                },
+                piece_size: self.piece_size.0,
+                verified_deal: self.verified_deal,
+                client: self.client.to_string(),
+                provider: self.provider.to_string(),
+                label: label.to_string(),
+                start_epoch: self.start_epoch,
+                end_epoch: self.end_epoch,
+                storage_price_per_epoch: self.storage_price_per_epoch.atto().to_string(),
+                provider_collateral: self.provider_collateral.atto().to_string(),
+                client_collateral: self.client_collateral.atto().to_string(),
+            }
+            .serialize(serializer)
+        } else {
+            (
+                &self.piece_cid,
+                &self.piece_size,
+                &self.verified_deal,
+                &self.client,
+                &self.provider,
+                &self.label,
+                &self.start_epoch,
+                &self.end_epoch,
+                &self.storage_price_per_epoch,
+                &self.provider_collateral,
+                &self.client_collateral,
+            )
+                .serialize(serializer)
+        }
+    }
+}
+
+impl<'de> Deserialize<'de> for DealProposal {
+    fn deserialize<D>(deserializer: D) -> Result<Self, D::Error>
+    where
+        D: Deserializer<'de>,
+    {
+        if deserializer.is_human_readable() {
+            let p = LotusDealProposal::deserialize(deserializer)?;
+            let address = |s: &str| Address::from_str(s).map_err(<D::Error as de::Error>::custom);
+            let amount = |s: &str| {
+                BigInt::from_str(s)
+                    .map(TokenAmount::from_atto)
+                    .map_err(<D::Error as de::Error>::custom)
+            };
+            Ok(DealProposal {
+                piece_cid: Cid::try_from(p.piece_cid.cid.as_str())
+                    .map_err(<D::Error as de::Error>::custom)?,
+                piece_size: PaddedPieceSize(p.piece_size),
+                verified_deal: p.verified_deal,
+                client: address(&p.client)?,
+                provider: address(&p.provider)?,
+                label: Label::String(p.label),
+                start_epoch: p.start_epoch,
+                end_epoch: p.end_epoch,
+                storage_price_per_epoch: amount(&p.storage_price_per_epoch)?,
+                provider_collateral: amount(&p.provider_collateral)?,
+                client_collateral: amount(&p.client_collateral)?,
+            })
+        } else {
+            let (
+                piece_cid,
+                piece_size,
+                verified_deal,
+                client,
+                provider,
+                label,
+                start_epoch,
+                end_epoch,
+                storage_price_per_epoch,
+                provider_collateral,
+                client_collateral,
+            ) = Deserialize::deserialize(deserializer)?;
+            Ok(DealProposal {
+                piece_cid,
+                piece_size,
+                verified_deal,
+                client,
+                provider,
+                label,
+                start_epoch,
+                end_epoch,
+                storage_price_per_epoch,
+                provider_collateral,
+                client_collateral,
+            })
+        }
+    }
+}
+
+/// A CID as Lotus writes it in JSON, `{"/": "<cid>"}`.
+#[derive(Serialize, Deserialize)]
+struct LotusCid {
+    #[serde(rename = "/")]
+    cid: String,
+}
+
+/// The JSON form of a deal proposal in the Lotus API. Token amounts are decimal attoFIL strings.
+#[derive(Serialize, Deserialize)]
+#[serde(rename_all = "PascalCase", deny_unknown_fields)]
+struct LotusDealProposal {
+    #[serde(rename = "PieceCID")]
+    piece_cid: LotusCid,
+    piece_size: u64,
+    verified_deal: bool,
+    client: String,
+    provider: String,
+    label: String,
+    start_epoch: ChainEpoch,
+    end_epoch: ChainEpoch,
+    storage_price_per_epoch: String,
+    provider_collateral: String,
+    client_collateral: String,
+}
+
 impl DealProposal {
     pub fn duration(&self) -> ChainEpoch {
         self.end_epoch - self.start_epoch
@@ -126,6 +291,15 @@ impl DealProposal {
     pub fn weight(&self) -> DealWeight {
         DealWeight::from(self.duration()) * self.piece_size.0
     }
+    /// Returns the deal weight and verified deal weight the proposal contributes to a sector.
+    /// Only one of them is non-zero, depending on whether the deal is verified.
+    pub fn weights(&self) -> (DealWeight, DealWeight) {
+        if self.verified_deal {
+            (DealWeight::zero(), self.weight())
+        } else {
+            (self.weight(), DealWeight::zero())
+        }
+    }
     pub fn total_storage_fee(&self) -> TokenAmount {
         self.storage_price_per_epoch.clone() * self.duration() as u64
     }
@@ -155,3 +329,136 @@ pub struct DealState {
     // -1 if deal never slashed
     pub slash_epoch: ChainEpoch,
 }
+
+#[cfg(test)]
+mod tests {
+    use fvm_ipld_encoding::{from_slice, to_vec};
+
+    use super::*;
+
+    fn proposal(label: Label, end_epoch: ChainEpoch) -> DealProposal {
+        DealProposal {
+            piece_cid: Cid::try_from(
+                "baga6ea4seaqaaaicamcakbqhbaequcymbuha6earcijrifiwc4mbsgq3dqor4hy",
+            )
+            .unwrap(),
+            piece_size: PaddedPieceSize(2048),
+            verified_deal: false,
+            client: Address::new_id(101),
+            provider: Address::new_id(100),
+            label,
+            start_epoch: 10,
+            end_epoch,
+            storage_price_per_epoch: TokenAmount::from_atto(10),
+            provider_collateral: TokenAmount::from_atto(1000),
+            client_collateral: TokenAmount::from_atto(0),
+        }
+    }
+
+    // Expected CIDs are those computed by go-state-types `DealProposal.Cid()`
+    // (DAG-CBOR, Blake2b-256) over the same proposals.
+    #[test]
+    fn deal_proposal_cid() {
+        let cases = [
+            (
+                proposal(Label::String(String::new()), 200),
+                "bafy2bzaceabqlig4auvdvtk53ua5lykigsbb5zanqbybc6auuallctcf3fars",
+            ),
+            (
+                proposal(Label::String("hello".to_string()), 200),
+                "bafy2bzacecgtwurs3rqblqosml4nay33riezfirkgftgfsdm223wqx66obc4y",
+            ),
+            (
+                proposal(Label::Bytes(vec![0xde, 0xad]), 200),
+                "bafy2bzaceaaar7taqjqlriqmlh6tpid4umkw2vgoi4cnmupcc4f55lzsxx4tq",
+            ),
+            (
+                proposal(Label::String("hello".to_string()), ChainEpoch::MAX),
+                "bafy2bzacebnxx77ollt5nyjahqfmchrxruk7llbocuwibuj3sa463qz2dsydu",
+            ),
+        ];
+        for (proposal, expected) in cases {
+            assert_eq!(proposal.cid().unwrap().to_string(), expected);
+        }
+    }
+
+    #[test]
+    fn deal_proposal_weights() {
+        let mut unverified = proposal(Label::String(String::new()), 200);
+        let weight = DealWeight::from(190 * 2048);
+        assert_eq!(unverified.weights(), (weight.clone(), DealWeight::zero()));
+
+        let mut verified = unverified.clone();
+        verified.verified_deal = true;
+        assert_eq!(verified.weights(), (DealWeight::zero(), weight));
+
+        // 64GiB piece over the largest representable duration.
+        unverified.piece_size = PaddedPieceSize(64 << 30);
+        unverified.start_epoch = 0;
+        unverified.end_epoch = ChainEpoch::MAX;
+        let weight = DealWeight::from(ChainEpoch::MAX) * DealWeight::from(64u64 << 30);
+        assert_eq!(unverified.weights(), (weight, DealWeight::zero()));
+
+        unverified.end_epoch = 0;
+        assert_eq!(
+            unverified.weights(),
+            (DealWeight::zero(), DealWeight::zero())
+        );
+    }
+
+    // The `DealProposal` example from the Lotus API documentation, with the DAG-CBOR encoding
+    // of the same proposal.
+    const LOTUS_PROPOSAL_JSON: &str = r#"{"PieceCID":{"/":"bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4"},"PieceSize":1032,"VerifiedDeal":true,"Client":"f01234","Provider":"f01234","Label":"","StartEpoch":10101,"EndEpoch":10101,"StoragePricePerEpoch":"0","ProviderCollateral":"0","ClientCollateral":"0"}"#;
+    const LOTUS_PROPOSAL_CBOR: &str = "8bd82a5827000171a0e4022037690cfec6c1bf4c3b9288c7a5d783e98731e90b0a4c177c2a374c7a9427355e190408f54300d2094300d20960192775192775404040";
+
+    #[test]
+    fn deal_proposal_lotus_json() {
+        let decoded: DealProposal = serde_json::from_str(LOTUS_PROPOSAL_JSON).unwrap();
+        assert_eq!(
+            DealProposal {
+                piece_cid: Cid::try_from(
+                    "bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4",
+                )
+                .unwrap(),
+                piece_size: PaddedPieceSize(1032),
+                verified_deal: true,
+                client: Address::new_id(1234),
+                provider: Address::new_id(1234),
+                label: Label::String(String::new()),
+                start_epoch: 10101,
+                end_epoch: 10101,
+                storage_price_per_epoch: TokenAmount::from_atto(0),
+                provider_collateral: TokenAmount::from_atto(0),
+                client_collateral: TokenAmount::from_atto(0),
+            },
+            decoded
+        );
+        assert_eq!(
+            LOTUS_PROPOSAL_JSON,
+            serde_json::to_string(&decoded).unwrap()
+        );
+
+        // The JSON form leaves the DAG-CBOR encoding as the tuple.
+        let cbor = hex::decode(LOTUS_PROPOSAL_CBOR).unwrap();
+        assert_eq!(cbor, to_vec(&decoded).unwrap());
+        assert_eq!(decoded, from_slice::<DealProposal>(&cbor).unwrap());
+
+        // Amounts beyond u64 are written out in full.
+        let large = DealProposal {
+            label: Label::String("hello".to_string()),
+            storage_price_per_epoch: TokenAmount::from_whole(1_000_000_000_000u64),
+            ..decoded.clone()
+        };
+        let json = serde_json::to_string(&large).unwrap();
+        assert!(json.contains(r#""StoragePricePerEpoch":"1000000000000000000000000000000""#));
+        assert!(json.contains(r#""Label":"hello""#));
+        assert_eq!(large, serde_json::from_str(&json).unwrap());
+
+        let bytes = DealProposal {
+            label: Label::Bytes(vec![0xde, 0xad]),
+            ..decoded
+        };
+        assert!(serde_json::to_string(&bytes).is_err());
+        assert!(serde_json::from_str::<DealProposal>(r#"{"PieceSize":1032}"#).is_err());
+    }
+}
@@ -1,13 +1,17 @@
 // Copyright 2019-2022 ChainSafe Systems
 // SPDX-License-Identifier: Apache-2.0, MIT
 
+use std::cmp;
+
 use crate::balance_table::BalanceTable;
+use crate::{DealProposal, DealState};
 use anyhow::anyhow;
 use cid::Cid;
-use fil_actors_runtime_v8::{make_empty_map, Array, SetMultimap};
+use fil_actors_runtime_v8::{actor_error, make_empty_map, Array, Set, SetMultimap};
 use fvm_ipld_blockstore::Blockstore;
 use fvm_ipld_encoding::tuple::*;
 use fvm_ipld_encoding::Cbor;
+use fvm_shared::address::Address;
 use fvm_shared::clock::{ChainEpoch, EPOCH_UNDEFINED};
 use fvm_shared::deal::DealID;
 use fvm_shared::econ::TokenAmount;
@@ -97,6 +101,465 @@ impl State {
             + &self.total_provider_locked_collateral
             + &self.total_client_storage_fee
     }
+
+    /// Returns the on-chain state of a deal, or `None` if the deal has not been activated
+    /// (or is unknown).
+    pub fn find_deal_state<BS: Blockstore>(
+        &self,
+        store: &BS,
+        deal_id: DealID,
+    ) -> anyhow::Result<Option<DealState>> {
+        let states = DealMetaArray::load(&self.states, store)
+            .map_err(|e| anyhow!("failed to load deal states: {}", e))?;
+        let state = states
+            .get(deal_id)
+            .map_err(|e| anyhow!("failed to get deal state {}: {}", deal_id, e))?;
+        Ok(state.copied())
+    }
+
+    /// Returns the ID the next published deal will be assigned. Every deal ID below it has been
+    /// assigned, though the deal may since have been removed.
+    pub fn next_deal_id(&self) -> DealID {
+        self.next_id
+    }
+
+    /// Returns the proposal of a deal, or `None` if the deal ID has not been assigned or the deal
+    /// has been removed from the state.
+    pub fn deal_proposal<BS: Blockstore>(
+        &self,
+        store: &BS,
+        deal_id: DealID,
+    ) -> anyhow::Result<Option<DealProposal>> {
+        if deal_id >= self.next_id {
+            return Ok(None);
+        }
+        let proposals = DealArray::load(&self.proposals, store)
+            .map_err(|e| anyhow!("failed to load deal proposals: {}", e))?;
+        let proposal = proposals
+            .get(deal_id)
+            .map_err(|e| anyhow!("failed to get deal proposal {}: {}", deal_id, e))?;
+        Ok(proposal.cloned())
+    }
+
+    /// Returns the on-chain states of the given deals, in the order requested, with `None` for
+    /// deals that have not been activated (or are unknown). The deal states AMT is loaded once,
+    /// so nodes shared between lookups are only read from the store once.
+    pub fn deal_states_batch<BS: Blockstore>(
+        &self,
+        store: &BS,
+        ids: &[DealID],
+    ) -> anyhow::Result<Vec<Option<DealState>>> {
+        let states = DealMetaArray::load(&self.states, store)
+            .map_err(|e| anyhow!("failed to load deal states: {}", e))?;
+        ids.iter()
+            .map(|&deal_id| {
+                let state = states
+                    .get(deal_id)
+                    .map_err(|e| anyhow!("failed to get deal state {}: {}", deal_id, e))?;
+                Ok(state.copied())
+            })
+            .collect()
+    }
+
+    /// Audits the escrow and locked balance tables, returning a description of every
+    /// violation found: a negative escrow or locked balance, or a locked balance that exceeds
+    /// the address' escrow. An empty list means the tables are consistent.
+    pub fn check_balance_invariants<BS: Blockstore>(
+        &self,
+        store: &BS,
+    ) -> anyhow::Result<Vec<String>> {
+        let escrow_table = BalanceTable::from_root(store, &self.escrow_table)
+            .map_err(|e| anyhow!("failed to load escrow table: {}", e))?;
+        let locked_table = BalanceTable::from_root(store, &self.locked_table)
+            .map_err(|e| anyhow!("failed to load locked table: {}", e))?;
+
+        let mut violations = Vec::new();
+        escrow_table
+            .0
+            .for_each(|key, escrow: &TokenAmount| {
+                let addr = Address::from_bytes(&key.0)?;
+                if escrow.is_negative() {
+                    violations.push(format!(
+                        "escrow balance of {} is negative: {}",
+                        addr, escrow
+                    ));
+                }
+                Ok(())
+            })
+            .map_err(|e| anyhow!("failed to iterate escrow table: {}", e))?;
+        locked_table
+            .0
+            .for_each(|key, locked: &TokenAmount| {
+                let addr = Address::from_bytes(&key.0)?;
+                if locked.is_negative() {
+                    violations.push(format!(
+                        "locked balance of {} is negative: {}",
+                        addr, locked
+                    ));
+                }
+                let escrow = escrow_table.get(&addr)?;
+                if locked > &escrow {
+                    violations.push(format!(
+                        "locked balance of {} exceeds its escrow: {} > {}",
+                        addr, locked, escrow
+                    ));
+                }
+                Ok(())
+            })
+            .map_err(|e| anyhow!("failed to iterate locked table: {}", e))?;
+        Ok(violations)
+    }
+
+    /// Returns how much of `requested` the market actor would pay out if `addr` withdrew it now:
+    /// its escrow balance less its locked balance, capped at `requested`. An address with no
+    /// escrow can withdraw nothing. As the tables are keyed by ID address, `addr` must be one.
+    pub fn simulate_withdraw_balance<BS: Blockstore>(
+        &self,
+        store: &BS,
+        addr: &Address,
+        requested: &TokenAmount,
+    ) -> anyhow::Result<TokenAmount> {
+        if requested.is_negative() {
+            return Err(anyhow!(
+                "negative withdrawal amount requested: {}",
+                requested
+            ));
+        }
+        let escrow = BalanceTable::from_root(store, &self.escrow_table)
+            .map_err(|e| anyhow!("failed to load escrow table: {}", e))?
+            .get(addr)
+            .map_err(|e| anyhow!("failed to get escrow balance of {}: {}", addr, e))?;
+        let locked = BalanceTable::from_root(store, &self.locked_table)
+            .map_err(|e| anyhow!("failed to load locked table: {}", e))?
+            .get(addr)
+            .map_err(|e| anyhow!("failed to get locked balance of {}: {}", addr, e))?;
+        let available = cmp::max(escrow - locked, TokenAmount::default());
+        Ok(cmp::min(available, requested.clone()))
+    }
+
+    /// Iterates over the published deal proposals that have not yet reached their start epoch,
+    /// with their CIDs, in deal ID order. The pending proposals set only holds the proposal
+    /// CIDs, so this walks `proposals` and passes on those whose CID is in the set. Nothing is
+    /// collected, so memory use does not grow with the number of deals.
+    pub fn for_each_pending_proposal<BS, F>(&self, store: &BS, mut f: F) -> anyhow::Result<()>
+    where
+        BS: Blockstore,
+        F: FnMut(&Cid, &DealProposal) -> anyhow::Result<()>,
+    {
+        let pending_proposals = Set::from_root(store, &self.pending_proposals)
+            .map_err(|e| anyhow!("failed to load pending proposals: {}", e))?;
+        let proposals = DealArray::load(&self.proposals, store)
+            .map_err(|e| anyhow!("failed to load deal proposals: {}", e))?;
+        proposals
+            .for_each(|_, proposal| {
+                let proposal_cid = proposal.cid()?;
+                if pending_proposals.has(&proposal_cid.to_bytes())? {
+                    f(&proposal_cid, proposal)?;
+                }
+                Ok(())
+            })
+            .map_err(|e| anyhow!("failed to iterate pending proposals: {}", e))
+    }
+
+    /// Computes the outcome of settling a deal's payments at `current_epoch`, as the market
+    /// actor's cron does, without modifying the state.
+    ///
+    /// An active deal pays the provider for the epochs since it was last settled, up to its end
+    /// epoch. A slashed deal is paid up to its slash epoch and loses its provider collateral, as
+    /// does a deal that was not activated by its start epoch.
+    /// A deal last settled or slashed after `current_epoch` is an illegal state.
+    pub fn simulate_settle<BS: Blockstore>(
+        &self,
+        store: &BS,
+        deal_id: DealID,
+        current_epoch: ChainEpoch,
+    ) -> anyhow::Result<SettleOutcome> {
+        let proposals = DealArray::load(&self.proposals, store)
+            .map_err(|e| anyhow!("failed to load deal proposals: {}", e))?;
+        let deal = proposals
+            .get(deal_id)
+            .map_err(|e| anyhow!("failed to get deal proposal {}: {}", deal_id, e))?
+            .ok_or_else(|| anyhow!("no such deal proposal {}", deal_id))?;
+
+        let mut outcome = SettleOutcome::default();
+        if current_epoch < deal.start_epoch {
+            return Ok(outcome);
+        }
+        let state = match self.find_deal_state(store, deal_id)? {
+            Some(state) => state,
+            None => {
+                outcome.slashed = deal.provider_collateral.clone();
+                outcome.completed = true;
+                return Ok(outcome);
+            }
+        };
+
+        if state.last_updated_epoch > current_epoch {
+            return Err(actor_error!(
+                illegal_state,
+                "deal {} last updated at {}, after the current epoch {}",
+                deal_id,
+                state.last_updated_epoch,
+                current_epoch
+            )
+            .into());
+        }
+        let slashed = state.slash_epoch != EPOCH_UNDEFINED;
+        let payment_end = if slashed {
+            if state.slash_epoch > current_epoch || state.slash_epoch > deal.end_epoch {
+                return Err(actor_error!(
+                    illegal_state,
+                    "deal {} slash epoch {} is after the current epoch {} or the deal end {}",
+                    deal_id,
+                    state.slash_epoch,
+                    current_epoch,
+                    deal.end_epoch
+                )
+                .into());
+            }
+            state.slash_epoch
+        } else {
+            cmp::min(deal.end_epoch, current_epoch)
+        };
+        // A deal never settled has an undefined last update epoch, before its start.
+        let payment_start = cmp::max(deal.start_epoch, state.last_updated_epoch);
+        if payment_end > payment_start {
+            outcome.payment =
+                deal.storage_price_per_epoch.clone() * (payment_end - payment_start) as u64;
+        }
+
+        if slashed {
+            outcome.slashed = deal.provider_collateral.clone();
+            outcome.completed = true;
+        } else if current_epoch >= deal.end_epoch {
+            outcome.completed = true;
+        }
+        Ok(outcome)
+    }
+}
+
+/// The result of settling a deal's payments, as computed by [`State::simulate_settle`].
+#[derive(Clone, Debug, Default, PartialEq, Eq)]
+pub struct SettleOutcome {
+    /// Storage fee paid from the client's escrow to the provider.
+    pub payment: TokenAmount,
+    /// Provider collateral slashed.
+    pub slashed: TokenAmount,
+    /// Whether the deal is done and removed from the market: it expired, was slashed, or was
+    /// never activated.
+    pub completed: bool,
 }
 
 impl Cbor for State {}
+
+#[cfg(test)]
+mod tests {
+    use fil_actors_runtime_v8::ActorError;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_shared::error::ExitCode;
+    use fvm_shared::piece::PaddedPieceSize;
+
+    use super::*;
+    use crate::Label;
+
+    fn proposal() -> DealProposal {
+        DealProposal {
+            piece_cid: Cid::default(),
+            piece_size: PaddedPieceSize(2048),
+            verified_deal: false,
+            client: Address::new_id(100),
+            provider: Address::new_id(101),
+            label: Label::String("label".to_string()),
+            start_epoch: 100,
+            end_epoch: 200,
+            storage_price_per_epoch: TokenAmount::from_atto(10),
+            provider_collateral: TokenAmount::from_atto(1000),
+            client_collateral: TokenAmount::from_atto(0),
+        }
+    }
+
+    #[test]
+    fn for_each_pending_proposal() {
+        let store = MemoryBlockstore::default();
+        let mut state = State::new(&store).unwrap();
+
+        // Every third of a few thousand published deals is still pending.
+        let mut proposals = DealArray::load(&state.proposals, &store).unwrap();
+        let mut pending_proposals = Set::from_root(&store, &state.pending_proposals).unwrap();
+        let mut expected = Vec::new();
+        for deal_id in 0..3000 {
+            let deal = DealProposal {
+                start_epoch: 100 + deal_id as ChainEpoch,
+                ..proposal()
+            };
+            if deal_id % 3 == 0 {
+                let proposal_cid = deal.cid().unwrap();
+                pending_proposals
+                    .put(proposal_cid.to_bytes().into())
+                    .unwrap();
+                expected.push((proposal_cid, deal.clone()));
+            }
+            proposals.set(deal_id, deal).unwrap();
+        }
+        state.proposals = proposals.flush().unwrap();
+        state.pending_proposals = pending_proposals.root().unwrap();
+
+        let mut first = Vec::new();
+        state
+            .for_each_pending_proposal(&store, |proposal_cid, deal| {
+                first.push((*proposal_cid, deal.clone()));
+                Ok(())
+            })
+            .unwrap();
+        assert_eq!(expected, first);
+
+        // The order only depends on the state, so it is stable across walks.
+        let mut second = Vec::new();
+        state
+            .for_each_pending_proposal(&store, |proposal_cid, deal| {
+                second.push((*proposal_cid, deal.clone()));
+                Ok(())
+            })
+            .unwrap();
+        assert_eq!(first, second);
+
+        // Store errors are returned rather than ending the walk early.
+        let missing = State {
+            proposals: Cid::default(),
+            ..state.clone()
+        };
+        assert!(missing
+            .for_each_pending_proposal(&store, |_, _| Ok(()))
+            .is_err());
+    }
+
+    #[test]
+    fn find_deal_state() {
+        let store = MemoryBlockstore::default();
+        let mut state = State::new(&store).unwrap();
+
+        let activated = DealState {
+            sector_start_epoch: 10,
+            last_updated_epoch: 20,
+            slash_epoch: EPOCH_UNDEFINED,
+        };
+        let slashed = DealState {
+            sector_start_epoch: 10,
+            last_updated_epoch: 30,
+            slash_epoch: 30,
+        };
+        let mut states = DealMetaArray::load(&state.states, &store).unwrap();
+        states.set(1, activated).unwrap();
+        states.set(2, slashed).unwrap();
+        state.states = states.flush().unwrap();
+
+        assert_eq!(Some(activated), state.find_deal_state(&store, 1).unwrap());
+        assert_eq!(Some(slashed), state.find_deal_state(&store, 2).unwrap());
+        assert_eq!(None, state.find_deal_state(&store, 3).unwrap());
+    }
+
+    #[test]
+    fn check_balance_invariants() {
+        let store = MemoryBlockstore::default();
+        let mut state = State::new(&store).unwrap();
+        let client = Address::new_id(100);
+        let provider = Address::new_id(101);
+
+        let mut escrow = BalanceTable::from_root(&store, &state.escrow_table).unwrap();
+        escrow.add(&client, &TokenAmount::from_atto(100)).unwrap();
+        escrow.add(&provider, &TokenAmount::from_atto(50)).unwrap();
+        state.escrow_table = escrow.root().unwrap();
+        let mut locked = BalanceTable::from_root(&store, &state.locked_table).unwrap();
+        locked.add(&client, &TokenAmount::from_atto(100)).unwrap();
+        locked.add(&provider, &TokenAmount::from_atto(20)).unwrap();
+        state.locked_table = locked.root().unwrap();
+        assert!(state.check_balance_invariants(&store).unwrap().is_empty());
+
+        // Lock more than the provider has in escrow, and lock funds for an address with none.
+        let unfunded = Address::new_id(102);
+        locked.add(&provider, &TokenAmount::from_atto(31)).unwrap();
+        locked.add(&unfunded, &TokenAmount::from_atto(1)).unwrap();
+        state.locked_table = locked.root().unwrap();
+        let mut violations = state.check_balance_invariants(&store).unwrap();
+        violations.sort();
+        assert_eq!(2, violations.len());
+        assert!(violations[0].starts_with("locked balance of f0101 exceeds its escrow"));
+        assert!(violations[1].starts_with("locked balance of f0102 exceeds its escrow"));
+
+        // The balance table refuses negative balances, so write one directly.
+        escrow
+            .0
+            .set(client.to_bytes().into(), TokenAmount::from_atto(-1))
+            .unwrap();
+        state.escrow_table = escrow.root().unwrap();
+        let mut violations = state.check_balance_invariants(&store).unwrap();
+        violations.sort();
+        assert_eq!(4, violations.len());
+        assert!(violations[0].starts_with("escrow balance of f0100 is negative"));
+        assert!(violations[1].starts_with("locked balance of f0100 exceeds its escrow"));
+    }
+
+    #[test]
+    fn simulate_settle() {
+        let store = MemoryBlockstore::default();
+        let mut state = State::new(&store).unwrap();
+
+        let mut proposals = DealArray::load(&state.proposals, &store).unwrap();
+        for deal_id in 0..4 {
+            proposals.set(deal_id, proposal()).unwrap();
+        }
+        state.proposals = proposals.flush().unwrap();
+        let mut states = DealMetaArray::load(&state.states, &store).unwrap();
+        // Deal 0 was never settled, deal 1 was last settled at 150 and deal 2 was slashed at
+        // 170. Deal 3 was never activated.
+        for (deal_id, last_updated_epoch, slash_epoch) in [
+            (0, EPOCH_UNDEFINED, EPOCH_UNDEFINED),
+            (1, 150, EPOCH_UNDEFINED),
+            (2, 150, 170),
+        ] {
+            let deal_state = DealState {
+                sector_start_epoch: 90,
+                last_updated_epoch,
+                slash_epoch,
+            };
+            states.set(deal_id, deal_state).unwrap();
+        }
+        state.states = states.flush().unwrap();
+
+        let outcome = |payment: i64, slashed: i64, completed: bool| SettleOutcome {
+            payment: TokenAmount::from_atto(payment),
+            slashed: TokenAmount::from_atto(slashed),
+            completed,
+        };
+        assert_eq!(
+            outcome(0, 0, false),
+            state.simulate_settle(&store, 0, 99).unwrap()
+        );
+        assert_eq!(
+            outcome(600, 0, false),
+            state.simulate_settle(&store, 0, 160).unwrap()
+        );
+        assert_eq!(
+            outcome(500, 0, true),
+            state.simulate_settle(&store, 1, 250).unwrap()
+        );
+        assert_eq!(
+            outcome(200, 1000, true),
+            state.simulate_settle(&store, 2, 180).unwrap()
+        );
+        assert_eq!(
+            outcome(0, 1000, true),
+            state.simulate_settle(&store, 3, 120).unwrap()
+        );
+        assert!(state.simulate_settle(&store, 4, 120).is_err());
+
+        // Settled or slashed after the current epoch.
+        for (deal_id, epoch) in [(1, 140), (2, 160)] {
+            let err = state.simulate_settle(&store, deal_id, epoch).unwrap_err();
+            assert_eq!(
+                ExitCode::USR_ILLEGAL_STATE,
+                err.downcast::<ActorError>().unwrap().exit_code()
+            );
+        }
+    }
+}
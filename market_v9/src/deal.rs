@@ -1,19 +1,30 @@
 // Copyright 2019-2022 ChainSafe Systems
 // SPDX-License-Identifier: Apache-2.0, MIT
 
+use crate::policy::{
+    deal_client_collateral_bounds, deal_duration_bounds, deal_price_per_epoch_bounds,
+    deal_provider_collateral_bounds, detail::DEAL_MAX_LABEL_SIZE,
+};
 use crate::types::AllocationID;
 use cid::{Cid, Version};
+use fil_actors_runtime_v9::runtime::Policy;
+use fil_actors_runtime_v9::{actor_error, ActorError, DealWeight};
 use fvm_ipld_encoding::tuple::*;
 use fvm_ipld_encoding::{BytesSer, Cbor};
 use fvm_shared::address::Address;
+use fvm_shared::bigint::BigInt;
 use fvm_shared::clock::ChainEpoch;
 use fvm_shared::commcid::{FIL_COMMITMENT_UNSEALED, SHA2_256_TRUNC254_PADDED};
 use fvm_shared::crypto::signature::Signature;
 use fvm_shared::econ::TokenAmount;
 use fvm_shared::piece::PaddedPieceSize;
+use fvm_shared::sector::StoragePower;
 use libipld_core::ipld::Ipld;
-use serde::{de, Deserialize, Deserializer, Serialize, Serializer};
+use num_traits::Zero;
+use serde::{de, ser, Deserialize, Deserializer, Serialize, Serializer};
 use std::convert::{TryFrom, TryInto};
+use std::str::FromStr;
+use thiserror::Error;
 
 /// Cid prefix for piece Cids
 pub fn is_piece_cid(c: &Cid) -> bool {
@@ -82,6 +93,38 @@ impl Label {
             Label::Bytes(b) => b.is_empty(),
         }
     }
+
+    /// Returns the label if it was encoded as a string. Labels encoded as bytes return `None`,
+    /// even if the bytes are valid UTF-8.
+    pub fn as_string(&self) -> Option<&str> {
+        match self {
+            Label::String(s) => Some(s),
+            Label::Bytes(_) => None,
+        }
+    }
+
+    /// Returns the raw bytes of the label, whichever way it was encoded.
+    pub fn as_bytes(&self) -> &[u8] {
+        match self {
+            Label::String(s) => s.as_bytes(),
+            Label::Bytes(b) => b,
+        }
+    }
+
+    /// Checks the label is no longer than the market actor accepts. A string label is valid
+    /// UTF-8 by construction, as decoding rejects any that is not, so only its length in bytes
+    /// is checked, the same limit as for a bytes label.
+    pub fn validate(&self) -> Result<(), ActorError> {
+        if self.len() > DEAL_MAX_LABEL_SIZE {
+            return Err(actor_error!(
+                illegal_argument,
+                "deal label can be at most {} bytes, is {}",
+                DEAL_MAX_LABEL_SIZE,
+                self.len()
+            ));
+        }
+        Ok(())
+    }
 }
 
 /// Note: Deal Collateral is only released and returned to clients and miners
@@ -92,7 +135,7 @@ impl Label {
 /// minimal deals that last for a long time.
 /// Note: ClientCollateralPerEpoch may not be needed and removed pending future confirmation.
 /// There will be a Minimum value for both client and provider deal collateral.
-#[derive(Clone, Debug, PartialEq, Eq, Serialize_tuple, Deserialize_tuple)]
+#[derive(Clone, Debug, PartialEq, Eq)]
 pub struct DealProposal {
     pub piece_cid: Cid,
     pub piece_size: PaddedPieceSize,
@@ -118,10 +161,152 @@ pub struct DealProposal {
 
 impl Cbor for DealProposal {}
 
+/// Encodes the proposal as its DAG-CBOR tuple for binary formats, or as the Lotus JSON object
+/// for human-readable ones. Lotus only accepts string labels in JSON.
+impl Serialize for DealProposal {
+    fn serialize<S>(&self, serializer: S) -> Result<S::Ok, S::Error>
+    where
+        S: Serializer,
+    {
+        if serializer.is_human_readable() {
+            let label = self.label.as_string().ok_or_else(|| {
+                <S::Error as ser::Error>::custom("can only marshal string labels to JSON")
+            })?;
+            LotusDealProposal {
+                piece_cid: LotusCid {
+                    cid: self.piece_cid.to_string(),
+                },
+                piece_size: self.piece_size.0,
+                verified_deal: self.verified_deal,
+                client: self.client.to_string(),
+                provider: self.provider.to_string(),
+                label: label.to_string(),
+                start_epoch: self.start_epoch,
+                end_epoch: self.end_epoch,
+                storage_price_per_epoch: self.storage_price_per_epoch.atto().to_string(),
+                provider_collateral: self.provider_collateral.atto().to_string(),
+                client_collateral: self.client_collateral.atto().to_string(),
+            }
+            .serialize(serializer)
+        } else {
+            (
+                &self.piece_cid,
+                &self.piece_size,
+                &self.verified_deal,
+                &self.client,
+                &self.provider,
+                &self.label,
+                &self.start_epoch,
+                &self.end_epoch,
+                &self.storage_price_per_epoch,
+                &self.provider_collateral,
+                &self.client_collateral,
+            )
+                .serialize(serializer)
+        }
+    }
+}
+
+impl<'de> Deserialize<'de> for DealProposal {
+    fn deserialize<D>(deserializer: D) -> Result<Self, D::Error>
+    where
+        D: Deserializer<'de>,
+    {
+        if deserializer.is_human_readable() {
+            let p = LotusDealProposal::deserialize(deserializer)?;
+            let address = |s: &str| Address::from_str(s).map_err(<D::Error as de::Error>::custom);
+            let amount = |s: &str| {
+                BigInt::from_str(s)
+                    .map(TokenAmount::from_atto)
+                    .map_err(<D::Error as de::Error>::custom)
+            };
+            Ok(DealProposal {
+                piece_cid: Cid::try_from(p.piece_cid.cid.as_str())
+                    .map_err(<D::Error as de::Error>::custom)?,
+                piece_size: PaddedPieceSize(p.piece_size),
+                verified_deal: p.verified_deal,
+                client: address(&p.client)?,
+                provider: address(&p.provider)?,
+                label: Label::String(p.label),
+                start_epoch: p.start_epoch,
+                end_epoch: p.end_epoch,
+                storage_price_per_epoch: amount(&p.storage_price_per_epoch)?,
+                provider_collateral: amount(&p.provider_collateral)?,
+                client_collateral: amount(&p.client_collateral)?,
+            })
+        } else {
+            let (
+                piece_cid,
+                piece_size,
+                verified_deal,
+                client,
+                provider,
+                label,
+                start_epoch,
+                end_epoch,
+                storage_price_per_epoch,
+                provider_collateral,
+                client_collateral,
+            ) = Deserialize::deserialize(deserializer)?;
+            Ok(DealProposal {
+                piece_cid,
+                piece_size,
+                verified_deal,
+                client,
+                provider,
+                label,
+                start_epoch,
+                end_epoch,
+                storage_price_per_epoch,
+                provider_collateral,
+                client_collateral,
+            })
+        }
+    }
+}
+
+/// A CID as Lotus writes it in JSON, `{"/": "<cid>"}`.
+#[derive(Serialize, Deserialize)]
+struct LotusCid {
+    #[serde(rename = "/")]
+    cid: String,
+}
+
+/// The JSON form of a deal proposal in the Lotus API. Token amounts are decimal attoFIL strings.
+#[derive(Serialize, Deserialize)]
+#[serde(rename_all = "PascalCase", deny_unknown_fields)]
+struct LotusDealProposal {
+    #[serde(rename = "PieceCID")]
+    piece_cid: LotusCid,
+    piece_size: u64,
+    verified_deal: bool,
+    client: String,
+    provider: String,
+    label: String,
+    start_epoch: ChainEpoch,
+    end_epoch: ChainEpoch,
+    storage_price_per_epoch: String,
+    provider_collateral: String,
+    client_collateral: String,
+}
+
 impl DealProposal {
     pub fn duration(&self) -> ChainEpoch {
         self.end_epoch - self.start_epoch
     }
+    /// Computes weight for a deal proposal, which is a function of its size and duration.
+    pub fn weight(&self) -> DealWeight {
+        DealWeight::from(self.duration()) * self.piece_size.0
+    }
+    /// Returns the deal weight and verified deal weight the proposal contributes to a sector.
+    /// Only one of them is non-zero, depending on whether the deal is verified.
+    pub fn weights(&self) -> (DealWeight, DealWeight) {
+        if self.verified_deal {
+            (DealWeight::zero(), self.weight())
+        } else {
+            (self.weight(), DealWeight::zero())
+        }
+    }
     pub fn total_storage_fee(&self) -> TokenAmount {
         self.storage_price_per_epoch.clone() * self.duration() as u64
     }
@@ -131,6 +316,136 @@ impl DealProposal {
     pub fn provider_balance_requirement(&self) -> &TokenAmount {
         &self.provider_collateral
     }
+
+    /// Checks the proposal against the bounds the market actor enforces when the deal is
+    /// published, returning the first rule it violates. The client signature and account
+    /// balances are not checked. Every violation converts to an illegal argument `ActorError`.
+    pub fn validate(
+        &self,
+        policy: &Policy,
+        params: &DealNetworkParams,
+    ) -> Result<(), ProposalError> {
+        if self.label.len() > DEAL_MAX_LABEL_SIZE {
+            return Err(ProposalError::Label {
+                len: self.label.len(),
+            });
+        }
+        self.piece_size
+            .validate()
+            .map_err(|e| ProposalError::PieceSize(e.to_string()))?;
+        if !is_piece_cid(&self.piece_cid) {
+            return Err(ProposalError::PieceCid);
+        }
+        if self.end_epoch <= self.start_epoch {
+            return Err(ProposalError::EndBeforeStart);
+        }
+        if params.current_epoch > self.start_epoch {
+            return Err(ProposalError::StartEpoch {
+                start_epoch: self.start_epoch,
+                current_epoch: params.current_epoch,
+            });
+        }
+
+        let (min, max) = deal_duration_bounds(self.piece_size);
+        if self.duration() < min || self.duration() > max {
+            return Err(ProposalError::Duration {
+                duration: self.duration(),
+                min,
+                max,
+            });
+        }
+
+        let (min, max) = deal_price_per_epoch_bounds(self.piece_size, self.duration());
+        if self.storage_price_per_epoch < min || &self.storage_price_per_epoch > max {
+            return Err(ProposalError::Price {
+                price: self.storage_price_per_epoch.clone(),
+                min,
+                max: max.clone(),
+            });
+        }
+
+        let (min, max) = deal_provider_collateral_bounds(
+            policy,
+            self.piece_size,
+            &params.network_raw_power,
+            &params.baseline_power,
+            &params.circulating_supply,
+        );
+        if self.provider_collateral < min || self.provider_collateral > max {
+            return Err(ProposalError::ProviderCollateral {
+                collateral: self.provider_collateral.clone(),
+                min,
+                max,
+            });
+        }
+
+        let (min, max) = deal_client_collateral_bounds(self.piece_size, self.duration());
+        if self.client_collateral < min || self.client_collateral > max {
+            return Err(ProposalError::ClientCollateral {
+                collateral: self.client_collateral.clone(),
+                min,
+                max,
+            });
+        }
+        Ok(())
+    }
+}
+
+/// The rule a deal proposal breaks, as returned by [`DealProposal::validate`].
+#[derive(Clone, Debug, PartialEq, Eq, Error)]
+pub enum ProposalError {
+    #[error("deal label can be at most {} bytes, is {len}", DEAL_MAX_LABEL_SIZE)]
+    Label { len: usize },
+    #[error("proposal piece size is invalid: {0}")]
+    PieceSize(String),
+    #[error("proposal PieceCID undefined")]
+    PieceCid,
+    #[error("proposal end before proposal start")]
+    EndBeforeStart,
+    #[error("deal start epoch {start_epoch} has already elapsed at {current_epoch}")]
+    StartEpoch {
+        start_epoch: ChainEpoch,
+        current_epoch: ChainEpoch,
+    },
+    #[error("deal duration {duration} out of bounds [{min}, {max}]")]
+    Duration {
+        duration: ChainEpoch,
+        min: ChainEpoch,
+        max: ChainEpoch,
+    },
+    #[error("storage price {price} out of bounds [{min}, {max}]")]
+    Price {
+        price: TokenAmount,
+        min: TokenAmount,
+        max: TokenAmount,
+    },
+    #[error("provider collateral {collateral} out of bounds [{min}, {max}]")]
+    ProviderCollateral {
+        collateral: TokenAmount,
+        min: TokenAmount,
+        max: TokenAmount,
+    },
+    #[error("client collateral {collateral} out of bounds [{min}, {max}]")]
+    ClientCollateral {
+        collateral: TokenAmount,
+        min: TokenAmount,
+        max: TokenAmount,
+    },
+}
+
+impl From<ProposalError> for ActorError {
+    fn from(e: ProposalError) -> Self {
+        actor_error!(illegal_argument, e)
+    }
+}
+
+/// The network conditions a deal proposal is validated against.
+#[derive(Clone, Debug)]
+pub struct DealNetworkParams {
+    pub current_epoch: ChainEpoch,
+    pub network_raw_power: StoragePower,
+    pub baseline_power: StoragePower,
+    pub circulating_supply: TokenAmount,
 }
 
 /// ClientDealProposal is a DealProposal signed by a client
@@ -153,3 +468,373 @@ pub struct DealState {
     // ID of the verified registry allocation/claim for this deal's data (0 if none).
     pub verified_claim: AllocationID,
 }
+
+#[cfg(test)]
+mod tests {
+    use fil_actors_runtime_v9::network::EPOCHS_IN_DAY;
+    use fvm_ipld_encoding::{from_slice, to_vec};
+    use fvm_shared::error::ExitCode;
+    use fvm_shared::piece::UnpaddedPieceSize;
+
+    use super::*;
+
+    fn proposal(label: Label, end_epoch: ChainEpoch) -> DealProposal {
+        DealProposal {
+            piece_cid: Cid::try_from(
+                "baga6ea4seaqaaaicamcakbqhbaequcymbuha6earcijrifiwc4mbsgq3dqor4hy",
+            )
+            .unwrap(),
+            piece_size: PaddedPieceSize(2048),
+            verified_deal: false,
+            client: Address::new_id(101),
+            provider: Address::new_id(100),
+            label,
+            start_epoch: 10,
+            end_epoch,
+            storage_price_per_epoch: TokenAmount::from_atto(10),
+            provider_collateral: TokenAmount::from_atto(1000),
+            client_collateral: TokenAmount::from_atto(0),
+        }
+    }
+
+    // Expected CIDs are those computed by go-state-types `DealProposal.Cid()`
+    // (DAG-CBOR, Blake2b-256) over the same proposals.
+    #[test]
+    fn deal_proposal_cid() {
+        let cases = [
+            (
+                proposal(Label::String(String::new()), 200),
+                "bafy2bzaceabqlig4auvdvtk53ua5lykigsbb5zanqbybc6auuallctcf3fars",
+            ),
+            (
+                proposal(Label::String("hello".to_string()), 200),
+                "bafy2bzacecgtwurs3rqblqosml4nay33riezfirkgftgfsdm223wqx66obc4y",
+            ),
+            (
+                proposal(Label::Bytes(vec![0xde, 0xad]), 200),
+                "bafy2bzaceaaar7taqjqlriqmlh6tpid4umkw2vgoi4cnmupcc4f55lzsxx4tq",
+            ),
+            (
+                proposal(Label::String("hello".to_string()), ChainEpoch::MAX),
+                "bafy2bzacebnxx77ollt5nyjahqfmchrxruk7llbocuwibuj3sa463qz2dsydu",
+            ),
+        ];
+        for (proposal, expected) in cases {
+            assert_eq!(proposal.cid().unwrap().to_string(), expected);
+        }
+    }
+
+    // As go-state-types `PublishStorageDealsParams` encodes batches of the proposals above; the
+    // batch CID is that of the publish message's params.
+    #[test]
+    fn publish_storage_deals_params_cid() {
+        let signed = |label, end_epoch, client_signature| ClientDealProposal {
+            proposal: proposal(label, end_epoch),
+            client_signature,
+        };
+        let secp = || Signature::new_secp256k1(vec![7; 65]);
+        let single = crate::PublishStorageDealsParams {
+            deals: vec![signed(Label::String("hello".to_string()), 200, secp())],
+        };
+        assert_eq!(
+            "bafy2bzacebzsrx5bncpy7rpiwehspmpo5a5p6boaebil3kmc5a5otpewzsxji",
+            single.cid().unwrap().to_string()
+        );
+        let multi = crate::PublishStorageDealsParams {
+            deals: vec![
+                signed(Label::String("hello".to_string()), 200, secp()),
+                signed(
+                    Label::Bytes(vec![0xde, 0xad]),
+                    300,
+                    Signature::new_bls(vec![9; 96]),
+                ),
+                signed(Label::String(String::new()), ChainEpoch::MAX, secp()),
+            ],
+        };
+        assert_eq!(
+            "bafy2bzacecvikgom7vzygunkxhgfr3p5kdfngbpjjnan5uf5gr4ogopw4mhlq",
+            multi.cid().unwrap().to_string()
+        );
+        let empty = crate::PublishStorageDealsParams { deals: vec![] };
+        assert_eq!(
+            "bafy2bzacealbq6s7ptdud6gvpc2yv54opwotncjlqjxmzb2q2rnjxv753rwdc",
+            empty.cid().unwrap().to_string()
+        );
+    }
+
+    #[test]
+    fn deal_proposal_weights() {
+        let mut unverified = proposal(Label::String(String::new()), 200);
+        let weight = DealWeight::from(190 * 2048);
+        assert_eq!(unverified.weights(), (weight.clone(), DealWeight::zero()));
+
+        let mut verified = unverified.clone();
+        verified.verified_deal = true;
+        assert_eq!(verified.weights(), (DealWeight::zero(), weight));
+
+        // 64GiB piece over the largest representable duration.
+        unverified.piece_size = PaddedPieceSize(64 << 30);
+        unverified.start_epoch = 0;
+        unverified.end_epoch = ChainEpoch::MAX;
+        let weight = DealWeight::from(ChainEpoch::MAX) * DealWeight::from(64u64 << 30);
+        assert_eq!(unverified.weights(), (weight, DealWeight::zero()));
+
+        unverified.end_epoch = 0;
+        assert_eq!(
+            unverified.weights(),
+            (DealWeight::zero(), DealWeight::zero())
+        );
+    }
+
+    #[test]
+    fn label_major_type() {
+        // CBOR text string "hi".
+        let string: Label = fvm_ipld_encoding::from_slice(&[0x62, b'h', b'i']).unwrap();
+        assert_eq!(Label::String("hi".to_string()), string);
+        assert_eq!(Some("hi"), string.as_string());
+        assert_eq!(b"hi", string.as_bytes());
+
+        // CBOR byte string h'6869' is valid UTF-8, but remains a bytes label.
+        let bytes: Label = fvm_ipld_encoding::from_slice(&[0x42, b'h', b'i']).unwrap();
+        assert_eq!(Label::Bytes(b"hi".to_vec()), bytes);
+        assert_eq!(None, bytes.as_string());
+        assert_eq!(b"hi", bytes.as_bytes());
+
+        assert_eq!(
+            vec![0x62, b'h', b'i'],
+            fvm_ipld_encoding::to_vec(&string).unwrap()
+        );
+        assert_eq!(
+            vec![0x42, b'h', b'i'],
+            fvm_ipld_encoding::to_vec(&bytes).unwrap()
+        );
+        assert!(fvm_ipld_encoding::from_slice::<Label>(&[0x02]).is_err());
+    }
+
+    // The `DealProposal` example from the Lotus API documentation, with the DAG-CBOR encoding
+    // of the same proposal.
+    const LOTUS_PROPOSAL_JSON: &str = r#"{"PieceCID":{"/":"bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4"},"PieceSize":1032,"VerifiedDeal":true,"Client":"f01234","Provider":"f01234","Label":"","StartEpoch":10101,"EndEpoch":10101,"StoragePricePerEpoch":"0","ProviderCollateral":"0","ClientCollateral":"0"}"#;
+    const LOTUS_PROPOSAL_CBOR: &str = "8bd82a5827000171a0e4022037690cfec6c1bf4c3b9288c7a5d783e98731e90b0a4c177c2a374c7a9427355e190408f54300d2094300d20960192775192775404040";
+
+    #[test]
+    fn deal_proposal_lotus_json() {
+        let decoded: DealProposal = serde_json::from_str(LOTUS_PROPOSAL_JSON).unwrap();
+        assert_eq!(
+            DealProposal {
+                piece_cid: Cid::try_from(
+                    "bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4",
+                )
+                .unwrap(),
+                piece_size: PaddedPieceSize(1032),
+                verified_deal: true,
+                client: Address::new_id(1234),
+                provider: Address::new_id(1234),
+                label: Label::String(String::new()),
+                start_epoch: 10101,
+                end_epoch: 10101,
+                storage_price_per_epoch: TokenAmount::from_atto(0),
+                provider_collateral: TokenAmount::from_atto(0),
+                client_collateral: TokenAmount::from_atto(0),
+            },
+            decoded
+        );
+        assert_eq!(
+            LOTUS_PROPOSAL_JSON,
+            serde_json::to_string(&decoded).unwrap()
+        );
+
+        // The JSON form leaves the DAG-CBOR encoding as the tuple.
+        let cbor = hex::decode(LOTUS_PROPOSAL_CBOR).unwrap();
+        assert_eq!(cbor, to_vec(&decoded).unwrap());
+        assert_eq!(decoded, from_slice::<DealProposal>(&cbor).unwrap());
+
+        // Amounts beyond u64 are written out in full.
+        let large = DealProposal {
+            label: Label::String("hello".to_string()),
+            storage_price_per_epoch: TokenAmount::from_whole(1_000_000_000_000u64),
+            ..decoded.clone()
+        };
+        let json = serde_json::to_string(&large).unwrap();
+        assert!(json.contains(r#""StoragePricePerEpoch":"1000000000000000000000000000000""#));
+        assert!(json.contains(r#""Label":"hello""#));
+        assert_eq!(large, serde_json::from_str(&json).unwrap());
+
+        let bytes = DealProposal {
+            label: Label::Bytes(vec![0xde, 0xad]),
+            ..decoded
+        };
+        assert!(serde_json::to_string(&bytes).is_err());
+        assert!(serde_json::from_str::<DealProposal>(r#"{"PieceSize":1032}"#).is_err());
+    }
+
+    fn valid_proposal() -> DealProposal {
+        DealProposal {
+            start_epoch: 1000,
+            end_epoch: 1000 + 200 * EPOCHS_IN_DAY,
+            provider_collateral: TokenAmount::from_whole(1),
+            ..proposal(Label::String("label".to_string()), 0)
+        }
+    }
+
+    fn network() -> DealNetworkParams {
+        DealNetworkParams {
+            current_epoch: 900,
+            network_raw_power: StoragePower::from(1u64 << 40),
+            baseline_power: StoragePower::from(1u64 << 40),
+            circulating_supply: TokenAmount::from_whole(100),
+        }
+    }
+
+    fn rejection(proposal: &DealProposal, params: &DealNetworkParams) -> ProposalError {
+        let err = proposal.validate(&Policy::default(), params).unwrap_err();
+        let actor_err = ActorError::from(err.clone());
+        assert_eq!(ExitCode::USR_ILLEGAL_ARGUMENT, actor_err.exit_code());
+        assert_eq!(err.to_string(), actor_err.msg());
+        err
+    }
+
+    #[test]
+    fn label_validate() {
+        Label::String("hello".to_string()).validate().unwrap();
+        Label::String(String::new()).validate().unwrap();
+        Label::Bytes(vec![0xff; DEAL_MAX_LABEL_SIZE])
+            .validate()
+            .unwrap();
+
+        // The limit is on bytes, so a string of fewer multi-byte characters can exceed it.
+        let long = "\u{e9}".repeat(DEAL_MAX_LABEL_SIZE / 2 + 1);
+        assert_eq!(DEAL_MAX_LABEL_SIZE / 2 + 1, long.chars().count());
+        let err = Label::String(long).validate().unwrap_err();
+        assert_eq!(ExitCode::USR_ILLEGAL_ARGUMENT, err.exit_code());
+        assert!(err.msg().starts_with("deal label can be at most 256 bytes"));
+        assert!(Label::Bytes(vec![0; DEAL_MAX_LABEL_SIZE + 1])
+            .validate()
+            .is_err());
+
+        // A string label that is not valid UTF-8 does not decode.
+        let mut bytes = to_vec(&Label::String("ab".to_string())).unwrap();
+        *bytes.last_mut().unwrap() = 0xff;
+        assert!(from_slice::<Label>(&bytes).is_err());
+    }
+
+    #[test]
+    fn deal_proposal_validate() {
+        let policy = Policy::default();
+        valid_proposal().validate(&policy, &network()).unwrap();
+
+        let mut p = valid_proposal();
+        p.label = Label::Bytes(vec![0; DEAL_MAX_LABEL_SIZE + 1]);
+        assert_eq!(
+            ProposalError::Label {
+                len: DEAL_MAX_LABEL_SIZE + 1
+            },
+            rejection(&p, &network())
+        );
+
+        let mut p = valid_proposal();
+        p.piece_size = PaddedPieceSize(3000);
+        assert!(matches!(
+            rejection(&p, &network()),
+            ProposalError::PieceSize(_)
+        ));
+
+        let mut p = valid_proposal();
+        p.piece_cid = Cid::default();
+        assert_eq!(ProposalError::PieceCid, rejection(&p, &network()));
+
+        let mut p = valid_proposal();
+        p.end_epoch = p.start_epoch;
+        assert_eq!(ProposalError::EndBeforeStart, rejection(&p, &network()));
+
+        let params = DealNetworkParams {
+            current_epoch: 1001,
+            ..network()
+        };
+        assert_eq!(
+            ProposalError::StartEpoch {
+                start_epoch: 1000,
+                current_epoch: 1001
+            },
+            rejection(&valid_proposal(), &params)
+        );
+
+        let mut p = valid_proposal();
+        p.end_epoch = p.start_epoch + 180 * EPOCHS_IN_DAY - 1;
+        assert!(matches!(
+            rejection(&p, &network()),
+            ProposalError::Duration { .. }
+        ));
+        p.end_epoch = p.start_epoch + 540 * EPOCHS_IN_DAY + 1;
+        assert_eq!(
+            ProposalError::Duration {
+                duration: 540 * EPOCHS_IN_DAY + 1,
+                min: 180 * EPOCHS_IN_DAY,
+                max: 540 * EPOCHS_IN_DAY
+            },
+            rejection(&p, &network())
+        );
+        p.end_epoch = p.start_epoch + 540 * EPOCHS_IN_DAY;
+        p.validate(&policy, &network()).unwrap();
+
+        let mut p = valid_proposal();
+        p.storage_price_per_epoch = TokenAmount::from_atto(-1);
+        assert!(matches!(
+            rejection(&p, &network()),
+            ProposalError::Price { .. }
+        ));
+
+        // The minimum is the piece's share of 1% of the circulating supply.
+        let (min, _) = deal_provider_collateral_bounds(
+            &policy,
+            PaddedPieceSize(2048),
+            &StoragePower::from(1u64 << 40),
+            &StoragePower::from(1u64 << 40),
+            &TokenAmount::from_whole(100),
+        );
+        assert_eq!(TokenAmount::from_atto(1_862_645_149u64), min);
+        let mut p = valid_proposal();
+        p.provider_collateral = min.clone() - TokenAmount::from_atto(1);
+        assert!(matches!(
+            rejection(&p, &network()),
+            ProposalError::ProviderCollateral { .. }
+        ));
+        p.provider_collateral = min;
+        p.validate(&policy, &network()).unwrap();
+
+        let mut p = valid_proposal();
+        p.client_collateral = TokenAmount::from_atto(-1);
+        assert!(matches!(
+            rejection(&p, &network()),
+            ProposalError::ClientCollateral { .. }
+        ));
+    }
+
+    // PaddedPieceSize's checks are fvm_shared's; pinned here as the market relies on them.
+    // Conversions match go-state-types' abi.PaddedPieceSize.Unpadded() and
+    // abi.UnpaddedPieceSize.Padded(): each 128 byte chunk carries 127 bytes of data.
+    #[test]
+    fn piece_size_conversion_matches_go() {
+        for (padded, unpadded) in [
+            (128u64, 127u64),
+            (2048, 2032),
+            (32 << 30, 34_091_302_912),
+            (64 << 30, 68_182_605_824),
+        ] {
+            PaddedPieceSize(padded).validate().unwrap();
+            UnpaddedPieceSize(unpadded).validate().unwrap();
+            assert_eq!(
+                UnpaddedPieceSize(unpadded),
+                PaddedPieceSize(padded).unpadded()
+            );
+            assert_eq!(
+                PaddedPieceSize(padded),
+                UnpaddedPieceSize(unpadded).padded()
+            );
+        }
+
+        for padded in [0, 64, 3000, (32 << 30) + 128] {
+            assert!(PaddedPieceSize(padded).validate().is_err(), "{}", padded);
+        }
+        assert!(UnpaddedPieceSize(2048).validate().is_err());
+    }
+}
@@ -0,0 +1,138 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use anyhow::anyhow;
+use fil_actors_runtime_v9::amt_diff;
+pub use fil_actors_runtime_v9::Change;
+use fvm_ipld_blockstore::Blockstore;
+use fvm_shared::deal::DealID;
+
+use super::{DealProposal, DealState, State};
+
+/// The deal proposals and deal states that differ between two market states, in deal ID order.
+#[derive(Clone, Debug, Default, PartialEq, Eq)]
+pub struct MarketDiff {
+    pub proposals: Vec<Change<DealID, DealProposal>>,
+    pub states: Vec<Change<DealID, DealState>>,
+}
+
+/// Returns the deal proposals and deal states added, removed or modified from `old` to `new`.
+///
+/// Unchanged subtrees of the proposals and states AMTs are skipped, see [`amt_diff`], so the cost
+/// is proportional to the size of the change rather than the number of deals.
+pub fn diff<BS: Blockstore>(old: &State, new: &State, store: &BS) -> anyhow::Result<MarketDiff> {
+    Ok(MarketDiff {
+        proposals: amt_diff(store, &old.proposals, &new.proposals)
+            .map_err(|e| anyhow!("failed to diff deal proposals: {}", e))?,
+        states: amt_diff(store, &old.states, &new.states)
+            .map_err(|e| anyhow!("failed to diff deal states: {}", e))?,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use fvm_shared::address::Address;
+    use fvm_shared::clock::{ChainEpoch, EPOCH_UNDEFINED};
+    use fvm_shared::econ::TokenAmount;
+    use fvm_shared::piece::PaddedPieceSize;
+
+    use cid::Cid;
+
+    use super::*;
+    use crate::{DealArray, DealMetaArray, Label};
+
+    fn proposal(end_epoch: ChainEpoch) -> DealProposal {
+        DealProposal {
+            piece_cid: Cid::default(),
+            piece_size: PaddedPieceSize(2048),
+            verified_deal: false,
+            client: Address::new_id(100),
+            provider: Address::new_id(101),
+            label: Label::String("label".to_string()),
+            start_epoch: 10,
+            end_epoch,
+            storage_price_per_epoch: TokenAmount::from_atto(1),
+            provider_collateral: TokenAmount::from_atto(2),
+            client_collateral: TokenAmount::from_atto(3),
+        }
+    }
+
+    fn state(sector_start_epoch: ChainEpoch) -> DealState {
+        DealState {
+            sector_start_epoch,
+            last_updated_epoch: EPOCH_UNDEFINED,
+            slash_epoch: EPOCH_UNDEFINED,
+            verified_claim: 0,
+        }
+    }
+
+    #[test]
+    fn diff_single_deal_change() {
+        let store = MemoryBlockstore::default();
+        let mut old = State::new(&store).unwrap();
+        let mut proposals = DealArray::load(&old.proposals, &store).unwrap();
+        let mut states = DealMetaArray::load(&old.states, &store).unwrap();
+        for id in 0..500 {
+            proposals
+                .set(id, proposal(1000 + id as ChainEpoch))
+                .unwrap();
+            states.set(id, state(id as ChainEpoch)).unwrap();
+        }
+        old.proposals = proposals.flush().unwrap();
+        old.states = states.flush().unwrap();
+        assert_eq!(MarketDiff::default(), diff(&old, &old, &store).unwrap());
+
+        let mut new = old.clone();
+        let mut states = DealMetaArray::load(&new.states, &store).unwrap();
+        states.set(42, state(4242)).unwrap();
+        new.states = states.flush().unwrap();
+
+        assert_eq!(
+            MarketDiff {
+                proposals: vec![],
+                states: vec![Change::Modified(42, state(42), state(4242))],
+            },
+            diff(&old, &new, &store).unwrap()
+        );
+    }
+
+    #[test]
+    fn diff_added_and_removed_deals() {
+        let store = MemoryBlockstore::default();
+        let mut old = State::new(&store).unwrap();
+        let mut proposals = DealArray::load(&old.proposals, &store).unwrap();
+        for id in 0..10 {
+            proposals
+                .set(id, proposal(1000 + id as ChainEpoch))
+                .unwrap();
+        }
+        old.proposals = proposals.flush().unwrap();
+
+        // Deleting a deal and publishing one with a much larger ID, which adds levels to the AMT.
+        let mut new = old.clone();
+        let mut proposals = DealArray::load(&new.proposals, &store).unwrap();
+        proposals.delete(3).unwrap();
+        proposals.set(100_000, proposal(5000)).unwrap();
+        new.proposals = proposals.flush().unwrap();
+
+        let d = diff(&old, &new, &store).unwrap();
+        assert_eq!(
+            vec![
+                Change::Removed(3, proposal(1003)),
+                Change::Added(100_000, proposal(5000)),
+            ],
+            d.proposals
+        );
+        assert!(d.states.is_empty());
+
+        let d = diff(&new, &old, &store).unwrap();
+        assert_eq!(
+            vec![
+                Change::Added(3, proposal(1003)),
+                Change::Removed(100_000, proposal(5000)),
+            ],
+            d.proposals
+        );
+    }
+}
@@ -20,6 +20,7 @@ use fil_actors_runtime_v9::runtime::Policy;
 use fil_actors_runtime_v9::{actor_error, ActorContext, ActorError, AsActorError};
 
 pub use self::deal::*;
+pub use self::diff::{diff, Change, MarketDiff};
 pub use self::state::*;
 pub use self::types::*;
 
@@ -28,6 +29,7 @@ pub mod balance_table;
 pub mod policy;
 
 mod deal;
+mod diff;
 mod state;
 mod types;
 
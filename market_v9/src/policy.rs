@@ -3,18 +3,33 @@
 
 use std::cmp::max;
 
+use fil_actors_runtime_v9::network::EPOCHS_IN_DAY;
 use fil_actors_runtime_v9::runtime::Policy;
 use fvm_shared::bigint::{BigInt, Integer};
+use fvm_shared::clock::ChainEpoch;
 use fvm_shared::econ::TokenAmount;
 use fvm_shared::piece::PaddedPieceSize;
 use fvm_shared::sector::StoragePower;
 use fvm_shared::TOTAL_FILECOIN;
+use num_traits::Zero;
 
 pub mod detail {
     /// Maximum length of a deal label.
     pub const DEAL_MAX_LABEL_SIZE: usize = 256;
 }
 
+/// Bounds (inclusive) on deal duration.
+pub fn deal_duration_bounds(_size: PaddedPieceSize) -> (ChainEpoch, ChainEpoch) {
+    (180 * EPOCHS_IN_DAY, 540 * EPOCHS_IN_DAY)
+}
+
+pub fn deal_price_per_epoch_bounds(
+    _size: PaddedPieceSize,
+    _duration: ChainEpoch,
+) -> (TokenAmount, &'static TokenAmount) {
+    (TokenAmount::zero(), &TOTAL_FILECOIN)
+}
+
 pub fn deal_provider_collateral_bounds(
     policy: &Policy,
     size: PaddedPieceSize,
@@ -37,3 +52,58 @@ pub fn deal_provider_collateral_bounds(
         TOTAL_FILECOIN.clone(),
     )
 }
+
+pub fn deal_client_collateral_bounds(
+    _size: PaddedPieceSize,
+    _duration: ChainEpoch,
+) -> (TokenAmount, TokenAmount) {
+    (TokenAmount::zero(), TOTAL_FILECOIN.clone())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn provider_collateral_bounds() {
+        // Expected minimums are Go's DealProviderCollateralBounds, which is the same for verified
+        // and unverified deals, at a circulating supply of 400M FIL.
+        let policy = Policy::default();
+        let supply = TokenAmount::from_whole(400_000_000);
+        let eib = StoragePower::from(1u64 << 60);
+        let cases = [
+            // A 32GiB piece on a 10EiB network, above its 2.5EiB baseline.
+            (
+                32u64 << 30,
+                &eib * 10,
+                &eib * 5 / 2,
+                11_920_928_955_078_125u128,
+            ),
+            // A small network, below its baseline.
+            (
+                2048,
+                StoragePower::from(1 << 10),
+                StoragePower::from(1 << 20),
+                7_812_500_000_000_000_000_000,
+            ),
+            // A piece larger than both: the share is capped at the whole supply target.
+            (
+                2048,
+                StoragePower::from(1 << 10),
+                StoragePower::from(512),
+                4_000_000_000_000_000_000_000_000,
+            ),
+        ];
+        for (size, network, baseline, expected) in cases {
+            let (min, max) = deal_provider_collateral_bounds(
+                &policy,
+                PaddedPieceSize(size),
+                &network,
+                &baseline,
+                &supply,
+            );
+            assert_eq!(TokenAmount::from_atto(expected), min);
+            assert_eq!(TOTAL_FILECOIN.clone(), max);
+        }
+    }
+}
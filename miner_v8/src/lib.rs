@@ -20,6 +20,7 @@ pub use policy::*;
 pub use sector_map::*;
 pub use sectors::*;
 pub use state::*;
+pub use state_cache::*;
 pub use termination::*;
 pub use types::*;
 pub use vesting_state::*;
@@ -38,6 +39,7 @@ mod policy;
 mod sector_map;
 mod sectors;
 mod state;
+mod state_cache;
 mod termination;
 mod types;
 mod vesting_state;
@@ -118,3 +120,6 @@ pub fn power_for_sectors(sector_size: SectorSize, sectors: &[SectorOnChainInfo])
         qa,
     }
 }
+
+#[cfg(test)]
+mod state_tests;
@@ -0,0 +1,187 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::cell::RefCell;
+use std::collections::BTreeMap;
+use std::rc::Rc;
+
+use anyhow::anyhow;
+use fil_actors_runtime_v8::runtime::Policy;
+use fil_actors_runtime_v8::{actor_error, Array};
+use fvm_ipld_blockstore::Blockstore;
+use fvm_shared::clock::ChainEpoch;
+use fvm_shared::sector::SectorNumber;
+
+use super::{Deadline, Deadlines, Partition, SectorOnChainInfo, Sectors, State};
+
+/// A read-only view of a miner state that memoizes the deadlines, partitions and sectors it
+/// loads, along with the location of every sector and the expirations in each partition, so
+/// repeated reads of the same state only decode each block once.
+///
+/// The state is borrowed immutably and its blocks are content addressed, so cached values
+/// never go stale.
+pub struct CachedMinerState<'a, BS> {
+    state: &'a State,
+    policy: &'a Policy,
+    store: &'a BS,
+    deadlines: RefCell<Option<Rc<Deadlines>>>,
+    deadline: RefCell<BTreeMap<u64, Rc<Deadline>>>,
+    partitions: RefCell<BTreeMap<(u64, u64), Rc<Partition>>>,
+    sector_locations: RefCell<Option<Rc<BTreeMap<SectorNumber, (u64, u64)>>>>,
+    expirations: RefCell<BTreeMap<(u64, u64), Rc<BTreeMap<SectorNumber, ChainEpoch>>>>,
+    sectors: RefCell<Option<Sectors<'a, BS>>>,
+}
+
+impl<'a, BS: Blockstore> CachedMinerState<'a, BS> {
+    pub fn new(state: &'a State, policy: &'a Policy, store: &'a BS) -> Self {
+        Self {
+            state,
+            policy,
+            store,
+            deadlines: Default::default(),
+            deadline: Default::default(),
+            partitions: Default::default(),
+            sector_locations: Default::default(),
+            expirations: Default::default(),
+            sectors: Default::default(),
+        }
+    }
+
+    /// The underlying, uncached state.
+    pub fn state(&self) -> &'a State {
+        self.state
+    }
+
+    pub fn load_deadlines(&self) -> anyhow::Result<Rc<Deadlines>> {
+        if let Some(deadlines) = &*self.deadlines.borrow() {
+            return Ok(deadlines.clone());
+        }
+        let deadlines = Rc::new(self.state.load_deadlines(self.store)?);
+        *self.deadlines.borrow_mut() = Some(deadlines.clone());
+        Ok(deadlines)
+    }
+
+    pub fn load_deadline(&self, deadline_idx: u64) -> anyhow::Result<Rc<Deadline>> {
+        if let Some(deadline) = self.deadline.borrow().get(&deadline_idx) {
+            return Ok(deadline.clone());
+        }
+        let deadline = Rc::new(self.load_deadlines()?.load_deadline(
+            self.policy,
+            self.store,
+            deadline_idx,
+        )?);
+        self.deadline
+            .borrow_mut()
+            .insert(deadline_idx, deadline.clone());
+        Ok(deadline)
+    }
+
+    pub fn load_partition(
+        &self,
+        deadline_idx: u64,
+        partition_idx: u64,
+    ) -> anyhow::Result<Rc<Partition>> {
+        let key = (deadline_idx, partition_idx);
+        if let Some(partition) = self.partitions.borrow().get(&key) {
+            return Ok(partition.clone());
+        }
+        let partition = Rc::new(
+            self.load_deadline(deadline_idx)?
+                .load_partition(self.store, partition_idx)?,
+        );
+        self.partitions.borrow_mut().insert(key, partition.clone());
+        Ok(partition)
+    }
+
+    pub fn get_sector(
+        &self,
+        sector_number: SectorNumber,
+    ) -> anyhow::Result<Option<SectorOnChainInfo>> {
+        let mut sectors = self.sectors.borrow_mut();
+        if sectors.is_none() {
+            *sectors = Some(Sectors::load(self.store, &self.state.sectors)?);
+        }
+        sectors.as_ref().unwrap().get(sector_number)
+    }
+
+    /// Returns the deadline and partition index of every sector. The first call walks all
+    /// deadlines and partitions, caching each partition on the way.
+    fn sector_locations(&self) -> anyhow::Result<Rc<BTreeMap<SectorNumber, (u64, u64)>>> {
+        if let Some(locations) = &*self.sector_locations.borrow() {
+            return Ok(locations.clone());
+        }
+        let mut locations = BTreeMap::new();
+        for deadline_idx in 0..self.policy.wpost_period_deadlines {
+            let deadline = self.load_deadline(deadline_idx)?;
+            let partitions = Array::<Partition, _>::load(&deadline.partitions, self.store)?;
+            partitions.for_each(|partition_idx, partition| {
+                for sector_number in partition.sectors.iter() {
+                    locations
+                        .entry(sector_number)
+                        .or_insert((deadline_idx, partition_idx));
+                }
+                self.partitions
+                    .borrow_mut()
+                    .entry((deadline_idx, partition_idx))
+                    .or_insert_with(|| Rc::new(partition.clone()));
+                Ok(())
+            })?;
+        }
+        let locations = Rc::new(locations);
+        *self.sector_locations.borrow_mut() = Some(locations.clone());
+        Ok(locations)
+    }
+
+    /// Returns the deadline and partition index for a sector number, as `State::find_sector`.
+    /// The first call indexes every sector of the miner, so later calls read nothing.
+    pub fn find_sector(&self, sector_number: SectorNumber) -> anyhow::Result<(u64, u64)> {
+        self.sector_locations()?
+            .get(&sector_number)
+            .copied()
+            .ok_or_else(|| anyhow!("sector {} not due at any deadline", sector_number))
+    }
+
+    /// Returns the expiration epoch of every sector in a partition's expiration queue, walking
+    /// the queue once per partition.
+    fn partition_expirations(
+        &self,
+        deadline_idx: u64,
+        partition_idx: u64,
+    ) -> anyhow::Result<Rc<BTreeMap<SectorNumber, ChainEpoch>>> {
+        let key = (deadline_idx, partition_idx);
+        if let Some(expirations) = self.expirations.borrow().get(&key) {
+            return Ok(expirations.clone());
+        }
+        let partition = self.load_partition(deadline_idx, partition_idx)?;
+        let mut expirations = BTreeMap::new();
+        for (epoch, set) in partition.expiration_queue(self.store)? {
+            for sector_number in set.on_time_sectors.iter().chain(set.early_sectors.iter()) {
+                expirations.entry(sector_number).or_insert(epoch);
+            }
+        }
+        let expirations = Rc::new(expirations);
+        self.expirations
+            .borrow_mut()
+            .insert(key, expirations.clone());
+        Ok(expirations)
+    }
+
+    /// Returns the epoch at which a sector is scheduled to expire, as `State::sector_expiration`.
+    pub fn sector_expiration(&self, sector_number: SectorNumber) -> anyhow::Result<ChainEpoch> {
+        if self.get_sector(sector_number)?.is_none() {
+            return Err(actor_error!(not_found; "sector {} not found", sector_number).into());
+        }
+        let (deadline_idx, partition_idx) = self.find_sector(sector_number)?;
+        self.partition_expirations(deadline_idx, partition_idx)?
+            .get(&sector_number)
+            .copied()
+            .ok_or_else(|| {
+                anyhow!(
+                    "sector {} not scheduled in expiration queue of deadline {} partition {}",
+                    sector_number,
+                    deadline_idx,
+                    partition_idx
+                )
+            })
+    }
+}
@@ -0,0 +1,442 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use super::*;
+use cid::Cid;
+use fil_actors_runtime_v8::runtime::Policy;
+use fil_actors_runtime_v8::{ActorError, TrackingBlockstore};
+use fvm_ipld_bitfield::BitField;
+use fvm_ipld_blockstore::{Blockstore, MemoryBlockstore};
+use fvm_ipld_encoding::{from_slice, to_vec, BytesDe};
+use fvm_shared::address::Address;
+use fvm_shared::clock::ChainEpoch;
+use fvm_shared::econ::TokenAmount;
+
+/// A miner with `count` sectors, numbered from zero, in partitions of `partition_size`.
+fn state_with_sectors(
+    policy: &Policy,
+    store: &MemoryBlockstore,
+    count: u64,
+    partition_size: u64,
+) -> State {
+    let mut state = State::new(policy, store, Cid::default(), 0, 0).unwrap();
+    let sectors: Vec<_> = (0..count)
+        .map(|sector_number| SectorOnChainInfo {
+            sector_number,
+            expiration: 1_000_000,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(store, sectors.clone()).unwrap();
+    state
+        .assign_sectors_to_deadlines(
+            policy,
+            store,
+            0,
+            sectors,
+            partition_size,
+            SectorSize::_32GiB,
+        )
+        .unwrap();
+    state
+}
+
+#[test]
+fn load_partition_targets_one_partition() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let state = state_with_sectors(&policy, &store, 10, 2);
+
+    let (deadline_idx, partition_idx) = state.find_sector(&policy, &store, 9).unwrap();
+    let partition = state
+        .load_partition(&policy, &store, deadline_idx, partition_idx)
+        .unwrap();
+    assert!(partition.sectors.get(9));
+
+    let exit_code = |err: anyhow::Error| err.downcast::<ActorError>().unwrap().exit_code();
+    let err = state
+        .load_partition(&policy, &store, policy.wpost_period_deadlines, 0)
+        .unwrap_err();
+    assert_eq!(ExitCode::USR_ILLEGAL_ARGUMENT, exit_code(err));
+    let err = state
+        .load_partition(&policy, &store, deadline_idx, 100)
+        .unwrap_err();
+    assert_eq!(ExitCode::USR_NOT_FOUND, exit_code(err));
+}
+
+#[test]
+fn for_each_sector_while_in_order_until_corrupted_node() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let sectors = [70_000, 5, 1, 1_000]
+        .iter()
+        .map(|&sector_number| SectorOnChainInfo {
+            sector_number,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(&store, sectors).unwrap();
+
+    let mut seen = Vec::new();
+    state
+        .for_each_sector_while(&store, |i, _| {
+            seen.push(i);
+            Ok(true)
+        })
+        .unwrap();
+    assert_eq!(seen, vec![1, 5, 1_000, 70_000]);
+
+    // Replace the subtree holding 70_000 with a block that is not an AMT node.
+    let root: (u32, u32, u64, (BytesDe, Vec<Cid>, Vec<()>)) =
+        from_slice(&store.get(&state.sectors).unwrap().unwrap()).unwrap();
+    store.put_keyed(&(root.3).1[1], &[0xa0]).unwrap();
+    let mut seen = Vec::new();
+    let result = state.for_each_sector_while(&store, |i, _| {
+        seen.push(i);
+        Ok(true)
+    });
+    assert!(result.is_err());
+    assert_eq!(seen, vec![1, 5, 1_000]);
+}
+
+#[test]
+fn cached_miner_state_matches_uncached() {
+    let policy = Policy::default();
+    let store = TrackingBlockstore::<MemoryBlockstore>::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let sectors: Vec<_> = (0..10)
+        .map(|sector_number| SectorOnChainInfo {
+            sector_number,
+            expiration: 1_000_000 + sector_number as ChainEpoch * 10_000,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(&store, sectors.clone()).unwrap();
+    state
+        .assign_sectors_to_deadlines(&policy, &store, 0, sectors, 2, SectorSize::_32GiB)
+        .unwrap();
+
+    let cached = CachedMinerState::new(&state, &policy, &store);
+    for sector_number in 0..10 {
+        assert_eq!(
+            state.find_sector(&policy, &store, sector_number).unwrap(),
+            cached.find_sector(sector_number).unwrap()
+        );
+        assert_eq!(
+            state
+                .sector_expiration(&policy, &store, sector_number)
+                .unwrap(),
+            cached.sector_expiration(sector_number).unwrap()
+        );
+    }
+    assert!(cached.sector_expiration(10).is_err());
+
+    store.clear_reads();
+    for sector_number in 0..10 {
+        cached.sector_expiration(sector_number).unwrap();
+    }
+    assert_eq!(0, store.read_count());
+}
+
+#[test]
+fn sector_deal_ids_for_cc_and_deal_sectors() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    state
+        .put_sectors(
+            &store,
+            vec![
+                SectorOnChainInfo {
+                    sector_number: 1,
+                    ..Default::default()
+                },
+                SectorOnChainInfo {
+                    sector_number: 2,
+                    deal_ids: vec![10, 11, 42],
+                    ..Default::default()
+                },
+            ],
+        )
+        .unwrap();
+
+    assert!(state.sector_deal_ids(&store, 1).unwrap().is_empty());
+    assert_eq!(vec![10, 11, 42], state.sector_deal_ids(&store, 2).unwrap());
+    assert!(state.sector_deal_ids(&store, 3).is_err());
+}
+
+#[test]
+fn current_deadline_wraps_into_next_period() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let state = State::new(&policy, &store, Cid::default(), 1000, 0).unwrap();
+    let window = policy.wpost_challenge_window;
+    let period = policy.wpost_proving_period;
+
+    assert_eq!((0, 1000), state.current_deadline(&policy, 1000));
+    assert_eq!(
+        (24, 1000),
+        state.current_deadline(&policy, 1000 + period / 2)
+    );
+    assert_eq!(
+        (3, 1000),
+        state.current_deadline(&policy, 1000 + 3 * window)
+    );
+    assert_eq!(
+        (0, 1000 + period),
+        state.current_deadline(&policy, 1000 + period)
+    );
+    assert_eq!((47, 1000 - period), state.current_deadline(&policy, 999));
+}
+
+#[test]
+fn load_sectors_selects_subset_in_one_pass() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let sectors: Vec<_> = (0..200)
+        .map(|i| SectorOnChainInfo {
+            sector_number: i * 3,
+            expiration: 1000 + i as ChainEpoch,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(&store, sectors.clone()).unwrap();
+
+    assert_eq!(sectors, state.load_sectors(&store, None).unwrap());
+
+    // Every tenth sector, plus sector numbers that were never committed.
+    let wanted =
+        BitField::try_from_bits((0..200).step_by(10).map(|i| i * 3).chain([1, 7_000])).unwrap();
+    let expected: Vec<_> = sectors.iter().step_by(10).cloned().collect();
+    assert_eq!(expected, state.load_sectors(&store, Some(&wanted)).unwrap());
+    assert!(state
+        .load_sectors(&store, Some(&BitField::new()))
+        .unwrap()
+        .is_empty());
+}
+
+#[test]
+fn control_addresses_with_pending_worker() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let mut info = MinerInfo::new(
+        Address::new_id(100),
+        Address::new_id(101),
+        vec![Address::new_id(102)],
+        b"peer".to_vec(),
+        vec![],
+        RegisteredPoStProof::StackedDRGWindow32GiBV1,
+    )
+    .unwrap();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    state.save_info(&store, &info).unwrap();
+
+    let addrs = state.control_addresses(&store).unwrap();
+    assert_eq!(Address::new_id(100), addrs.owner);
+    assert_eq!(Address::new_id(101), addrs.worker);
+    assert_eq!(vec![Address::new_id(102)], addrs.control);
+    assert_eq!(None, addrs.pending_worker);
+
+    info.pending_worker_key = Some(WorkerKeyChange {
+        new_worker: Address::new_id(104),
+        effective_at: 1000,
+    });
+    state.save_info(&store, &info).unwrap();
+    let addrs = state.control_addresses(&store).unwrap();
+    assert_eq!(Some(Address::new_id(104)), addrs.pending_worker);
+    assert_eq!(Address::new_id(101), addrs.worker);
+}
+
+#[test]
+fn next_free_sector_number_finds_first_gap() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    assert!(state.allocated_sectors(&store).unwrap().is_empty());
+    assert_eq!(0, state.next_free_sector_number(&store).unwrap());
+
+    let allocated = BitField::try_from_bits([0, 1, 2, 5, 6, 100]).unwrap();
+    state
+        .allocate_sector_numbers(&store, &allocated, CollisionPolicy::DenyCollisions)
+        .unwrap();
+    assert_eq!(allocated, state.allocated_sectors(&store).unwrap());
+    assert_eq!(3, state.next_free_sector_number(&store).unwrap());
+}
+
+#[test]
+fn export_summary_matches_enumeration() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    assert_eq!(
+        MinerSummary::default(),
+        state.export_summary(&policy, &store).unwrap()
+    );
+
+    let mut state = state_with_sectors(&policy, &store, 20, 4);
+    state.initial_pledge = TokenAmount::from_atto(1234);
+    let (deadline_idx, partition_idx) = state.find_sector(&policy, &store, 0).unwrap();
+    let quant = state.quant_spec_for_deadline(&policy, deadline_idx);
+    let mut deadlines = state.load_deadlines(&store).unwrap();
+    let mut deadline = deadlines
+        .load_deadline(&policy, &store, deadline_idx)
+        .unwrap();
+    let mut faults = PartitionSectorMap::default();
+    faults
+        .add(partition_idx, BitField::try_from_bits([0]).unwrap().into())
+        .unwrap();
+    deadline
+        .record_faults(
+            &store,
+            &Sectors::load(&store, &state.sectors).unwrap(),
+            SectorSize::_32GiB,
+            quant,
+            5_000,
+            &mut faults,
+        )
+        .unwrap();
+    deadlines
+        .update_deadline(&policy, &store, deadline_idx, &deadline)
+        .unwrap();
+    state.save_deadlines(&store, deadlines).unwrap();
+
+    let summary = state.export_summary(&policy, &store).unwrap();
+    let sector_power = StoragePower::from(SectorSize::_32GiB as u64);
+    assert_eq!(20, summary.live_sectors);
+    assert_eq!(&sector_power * 20, summary.live_power.raw);
+    assert_eq!(sector_power, summary.faulty_power.raw);
+    assert_eq!(1, summary.faulty_sectors);
+    assert_eq!(TokenAmount::from_atto(1234), summary.initial_pledge);
+    assert!(summary.active_deadlines.len() > 1);
+
+    let decoded: MinerSummary = from_slice(&to_vec(&summary).unwrap()).unwrap();
+    assert_eq!(summary, decoded);
+}
+
+#[test]
+fn deadline_post_submissions() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let mut state = state_with_sectors(&policy, &store, 20, 4);
+    let (deadline_idx, _) = state.find_sector(&policy, &store, 0).unwrap();
+    assert!(state
+        .deadline_post_submissions(&policy, &store, deadline_idx)
+        .unwrap()
+        .is_empty());
+
+    let mut deadlines = state.load_deadlines(&store).unwrap();
+    let mut deadline = deadlines
+        .load_deadline(&policy, &store, deadline_idx)
+        .unwrap();
+    deadline.partitions_posted = BitField::try_from_bits([0, 2]).unwrap();
+    deadlines
+        .update_deadline(&policy, &store, deadline_idx, &deadline)
+        .unwrap();
+    state.save_deadlines(&store, deadlines).unwrap();
+
+    let posted = state
+        .deadline_post_submissions(&policy, &store, deadline_idx)
+        .unwrap();
+    assert_eq!(vec![0, 2], posted.iter().collect::<Vec<_>>());
+    assert!(state
+        .deadline_post_submissions(&policy, &store, policy.wpost_period_deadlines)
+        .is_err());
+}
+
+#[test]
+fn sectors_due_for_post() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let mut state = state_with_sectors(&policy, &store, 20, 4);
+    let (deadline_idx, _) = state.find_sector(&policy, &store, 0).unwrap();
+    let partition_count = state
+        .load_deadlines(&store)
+        .unwrap()
+        .load_deadline(&policy, &store, deadline_idx)
+        .unwrap()
+        .partitions_amt(&store)
+        .unwrap()
+        .count();
+
+    // An epoch in the deadline's challenge window of the third proving period.
+    let at_epoch = 2 * policy.wpost_proving_period
+        + deadline_idx as ChainEpoch * policy.wpost_challenge_window
+        + 10;
+    let due = state
+        .sectors_due_for_post(&policy, &store, at_epoch)
+        .unwrap();
+    assert_eq!(1, due.len());
+    assert_eq!(deadline_idx, due[0].0);
+    assert_eq!(
+        (0..partition_count).collect::<Vec<_>>(),
+        due[0].1.iter().collect::<Vec<_>>()
+    );
+
+    // Once every partition is proven, nothing is due.
+    let mut deadlines = state.load_deadlines(&store).unwrap();
+    let mut deadline = deadlines
+        .load_deadline(&policy, &store, deadline_idx)
+        .unwrap();
+    deadline.partitions_posted = due[0].1.clone();
+    deadlines
+        .update_deadline(&policy, &store, deadline_idx, &deadline)
+        .unwrap();
+    state.save_deadlines(&store, deadlines).unwrap();
+    assert!(state
+        .sectors_due_for_post(&policy, &store, at_epoch)
+        .unwrap()
+        .is_empty());
+}
+
+#[test]
+fn terminated_sectors_union() {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let mut state = state_with_sectors(&policy, &store, 20, 4);
+    assert!(state
+        .terminated_sectors(&policy, &store)
+        .unwrap()
+        .is_empty());
+
+    // Terminate sector 0 and a sector in another partition.
+    let location = |state: &State, sector| state.find_sector(&policy, &store, sector).unwrap();
+    let other = (1..20)
+        .find(|&sector| location(&state, sector) != location(&state, 0))
+        .unwrap();
+    let sectors = Sectors::load(&store, &state.sectors).unwrap();
+    for sector in [0, other] {
+        let (deadline_idx, partition_idx) = location(&state, sector);
+        let quant = state.quant_spec_for_deadline(&policy, deadline_idx);
+        let mut deadlines = state.load_deadlines(&store).unwrap();
+        let mut deadline = deadlines
+            .load_deadline(&policy, &store, deadline_idx)
+            .unwrap();
+        let mut terminations = PartitionSectorMap::default();
+        terminations
+            .add(
+                partition_idx,
+                BitField::try_from_bits([sector]).unwrap().into(),
+            )
+            .unwrap();
+        deadline
+            .terminate_sectors(
+                &policy,
+                &store,
+                &sectors,
+                100,
+                &mut terminations,
+                SectorSize::_32GiB,
+                quant,
+            )
+            .unwrap();
+        deadlines
+            .update_deadline(&policy, &store, deadline_idx, &deadline)
+            .unwrap();
+        state.save_deadlines(&store, deadlines).unwrap();
+    }
+
+    let terminated = state.terminated_sectors(&policy, &store).unwrap();
+    assert_eq!(vec![0, other], terminated.iter().collect::<Vec<_>>());
+}
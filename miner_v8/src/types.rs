@@ -318,6 +318,18 @@ pub struct SectorOnChainInfo {
     pub sector_key_cid: Option<Cid>,
 }
 
+impl SectorOnChainInfo {
+    /// Returns the sector's committed lifetime, from activation to expiration.
+    pub fn duration(&self) -> ChainEpoch {
+        self.expiration - self.activation
+    }
+
+    /// Returns the number of epochs from `at` until the sector expires, or zero if it has expired.
+    pub fn remaining_lifetime(&self, at: ChainEpoch) -> ChainEpoch {
+        (self.expiration - at).max(0)
+    }
+}
+
 #[derive(Debug, PartialEq, Copy, Clone, Serialize_tuple, Deserialize_tuple)]
 pub struct Fault {
     pub miner: Address,
@@ -42,6 +42,16 @@ impl VestingFunds {
         self.funds.drain(..i).map(|fund| fund.amount).sum()
     }
 
+    /// Returns the total of the funds that are still locked at the given epoch, i.e. that
+    /// `unlock_vested_funds` would not release at that epoch.
+    pub fn locked_at(&self, epoch: ChainEpoch) -> TokenAmount {
+        self.funds
+            .iter()
+            .filter(|fund| fund.epoch >= epoch)
+            .map(|fund| fund.amount.clone())
+            .sum()
+    }
+
     pub fn add_locked_funds(
         &mut self,
         current_epoch: ChainEpoch,
@@ -0,0 +1,115 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+//! Benchmarks of miner state reads, on the libtest bench harness of the pinned nightly
+//! toolchain. Run them with `cargo bench -p fil_actor_miner_v9`.
+
+#![feature(test)]
+
+extern crate test;
+
+use cid::Cid;
+use fil_actor_miner_v9::{CachedMinerState, Partition, SectorOnChainInfo, State};
+use fil_actors_runtime_v9::runtime::Policy;
+use fvm_ipld_blockstore::MemoryBlockstore;
+use fvm_shared::clock::ChainEpoch;
+use fvm_shared::sector::SectorSize;
+use test::Bencher;
+
+const SECTORS: u64 = 10_000;
+
+/// A miner with `SECTORS` sectors, spread over a few partitions in each deadline.
+fn miner(policy: &Policy, store: &MemoryBlockstore) -> State {
+    let mut state = State::new(policy, store, Cid::default(), 0, 0).unwrap();
+    let sectors: Vec<_> = (0..SECTORS)
+        .map(|sector_number| SectorOnChainInfo {
+            sector_number,
+            expiration: 1_000_000 + (sector_number % 100) as ChainEpoch * 10_000,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(store, sectors.clone()).unwrap();
+    state
+        .assign_sectors_to_deadlines(policy, store, 0, sectors, 100, SectorSize::_32GiB)
+        .unwrap();
+    state
+}
+
+#[bench]
+fn load_partition(b: &mut Bencher) {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let state = miner(&policy, &store);
+    let (deadline_idx, partition_idx) = state.find_sector(&policy, &store, SECTORS - 1).unwrap();
+
+    b.iter(|| {
+        state
+            .load_partition(&policy, &store, deadline_idx, partition_idx)
+            .unwrap()
+    });
+}
+
+/// The same read as `load_partition`, by decoding every deadline and partition of the miner.
+#[bench]
+fn load_partition_full_decode(b: &mut Bencher) {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let state = miner(&policy, &store);
+    let location = state.find_sector(&policy, &store, SECTORS - 1).unwrap();
+
+    b.iter(|| {
+        let mut found: Option<Partition> = None;
+        let deadlines = state.load_deadlines(&store).unwrap();
+        deadlines
+            .for_each(&policy, &store, |deadline_idx, deadline| {
+                deadline
+                    .partitions_amt(&store)?
+                    .for_each(|partition_idx, partition| {
+                        if (deadline_idx, partition_idx) == location {
+                            found = Some(partition.clone());
+                        }
+                        Ok(())
+                    })?;
+                Ok(())
+            })
+            .unwrap();
+        found.unwrap()
+    });
+}
+
+#[bench]
+fn sector_expiration(b: &mut Bencher) {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let state = miner(&policy, &store);
+
+    b.iter(|| {
+        state
+            .sector_expiration(&policy, &store, SECTORS - 1)
+            .unwrap()
+    });
+}
+
+/// The same read as `sector_expiration`, answered from a warm cache.
+#[bench]
+fn sector_expiration_cached(b: &mut Bencher) {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let state = miner(&policy, &store);
+    let cached = CachedMinerState::new(&state, &policy, &store);
+    cached.sector_expiration(SECTORS - 1).unwrap();
+
+    b.iter(|| cached.sector_expiration(SECTORS - 1).unwrap());
+}
+
+/// Locating a sector from a warm cache, after the first lookup has indexed them all.
+#[bench]
+fn find_sector_cached(b: &mut Bencher) {
+    let policy = Policy::default();
+    let store = MemoryBlockstore::default();
+    let state = miner(&policy, &store);
+    let cached = CachedMinerState::new(&state, &policy, &store);
+    cached.find_sector(0).unwrap();
+
+    b.iter(|| cached.find_sector(SECTORS - 1).unwrap());
+}
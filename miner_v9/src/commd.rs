@@ -70,3 +70,23 @@ fn zero_commd(seal_proof: RegisteredSealProof) -> Result<Cid, ActorError> {
         .map_err(|_| actor_error!(assertion_failed, "static commd payload invalid"))?;
     Ok(Cid::new_v1(FIL_COMMITMENT_UNSEALED, hash))
 }
+
+#[cfg(test)]
+mod tests {
+    use fvm_shared::commcid::{FIL_COMMITMENT_SEALED, POSEIDON_BLS12_381_A1_FC1};
+
+    use super::*;
+
+    #[test]
+    fn commitment_multicodecs() {
+        // Pinned to the multicodec table so an upstream reassignment is caught here.
+        assert_eq!(0xf101, FIL_COMMITMENT_UNSEALED);
+        assert_eq!(0xf102, FIL_COMMITMENT_SEALED);
+        assert_eq!(0x1012, SHA2_256_TRUNC254_PADDED);
+        assert_eq!(0xb401, POSEIDON_BLS12_381_A1_FC1);
+
+        let commd = zero_commd(RegisteredSealProof::StackedDRG32GiBV1P1).unwrap();
+        assert_eq!(FIL_COMMITMENT_UNSEALED, commd.codec());
+        assert!(is_unsealed_sector(&commd));
+    }
+}
@@ -168,6 +168,18 @@ pub struct Deadline {
     pub optimistic_post_submissions_snapshot: Cid,
 }
 
+/// Sector and partition counts of a single deadline.
+#[derive(Debug, Default, PartialEq, Eq, Clone, Copy)]
+pub struct DeadlineSummary {
+    /// The total number of sectors in the deadline (incl dead).
+    pub total_sectors: u64,
+    /// The number of non-terminated sectors in the deadline (incl faulty).
+    pub live_sectors: u64,
+    /// The number of faulty sectors in the deadline, including those declared as recovering.
+    pub faulty_sectors: u64,
+    pub partition_count: u64,
+}
+
 #[derive(Serialize_tuple, Deserialize_tuple, Clone)]
 pub struct WindowedPoSt {
     // Partitions proved by this WindowedPoSt.
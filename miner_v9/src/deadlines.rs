@@ -28,6 +28,26 @@ pub fn new_deadline_info(
     )
 }
 
+/// Returns the epochs at which the deadline at the given index opens and closes in the proving
+/// period starting at `proving_period_start`. As with `DeadlineInfo`, an index past the last
+/// deadline opens and closes at the start of the next proving period.
+/// Saturates rather than overflowing for proving periods far in the future.
+pub fn deadline_open_close(
+    policy: &Policy,
+    proving_period_start: ChainEpoch,
+    deadline_idx: u64,
+) -> (ChainEpoch, ChainEpoch) {
+    if deadline_idx < policy.wpost_period_deadlines {
+        let open = proving_period_start.saturating_add(
+            (deadline_idx as ChainEpoch).saturating_mul(policy.wpost_challenge_window),
+        );
+        (open, open.saturating_add(policy.wpost_challenge_window))
+    } else {
+        let after_last_deadline = proving_period_start.saturating_add(policy.wpost_proving_period);
+        (after_last_deadline, after_last_deadline)
+    }
+}
+
 impl Deadlines {
     /// Returns the deadline and partition index for a sector number.
     /// Returns an error if the sector number is not tracked by `self`.
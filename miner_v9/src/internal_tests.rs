@@ -2,7 +2,13 @@
 // SPDX-License-Identifier: Apache-2.0, MIT
 
 use super::*;
-use fil_actors_runtime_v9::EPOCHS_IN_DAY;
+use cid::multihash::Multihash;
+use fil_actors_runtime_v9::runtime::Policy;
+use fil_actors_runtime_v9::{ActorError, DealWeight, TrackingBlockstore, EPOCHS_IN_DAY};
+use fvm_ipld_blockstore::Blockstore;
+use fvm_ipld_encoding::{from_slice, to_vec, BytesDe, Cbor};
+use fvm_shared::address::Address;
+use fvm_shared::commcid::{FIL_COMMITMENT_SEALED, POSEIDON_BLS12_381_A1_FC1};
 use fvm_shared::econ::TokenAmount;
 use fvm_shared::smooth::FilterEstimate;
 use num_traits::Zero;
@@ -162,3 +168,1316 @@ fn declared_and_undeclared_fault_penalties_are_linear_over_sector_qa_power_term(
     assert!(diff >= Zero::zero());
     assert!(diff < TokenAmount::from_atto(3));
 }
+
+#[test]
+fn termination_penalty_known_values() {
+    // A reward of 2^64 atto per epoch over 2^60 bytes of network power, without velocity, pays a
+    // 32GiB sector exactly 2^39 atto per epoch, so no step of the projection rounds.
+    let reward_estimate = FilterEstimate::new(BigInt::from(1_u128 << 64), Zero::zero());
+    let power_estimate = FilterEstimate::new(StoragePower::from(1_u64 << 60), Zero::zero());
+    let sector_power = StoragePower::from(32_u64 << 30);
+    let day_reward = TokenAmount::from_atto(1_583_296_743_997_440_u64);
+    let twenty_day_reward = TokenAmount::from_atto(31_665_934_879_948_800_u64);
+    assert_eq!(
+        twenty_day_reward,
+        expected_reward_for_power(
+            &reward_estimate,
+            &power_estimate,
+            &sector_power,
+            INITIAL_PLEDGE_PROJECTION_PERIOD
+        )
+    );
+    // SP(t), 3.5 days of reward.
+    assert_eq!(
+        TokenAmount::from_atto(5_541_538_603_991_040_u64),
+        pledge_penalty_for_termination_lower_bound(
+            &reward_estimate,
+            &power_estimate,
+            &sector_power
+        )
+    );
+
+    let penalty = |day_reward: &TokenAmount,
+                   twenty_day_reward: &TokenAmount,
+                   age_days: ChainEpoch,
+                   replaced_age_days: ChainEpoch| {
+        pledge_penalty_for_termination(
+            day_reward,
+            age_days * EPOCHS_IN_DAY,
+            twenty_day_reward,
+            &power_estimate,
+            &sector_power,
+            &reward_estimate,
+            day_reward,
+            replaced_age_days * EPOCHS_IN_DAY,
+        )
+    };
+    // Cases are (sector age, replaced sector age, penalty) in days of reward. The penalty is 20
+    // days plus half of each day of age, capped at 140 days of age including the replaced
+    // sector's.
+    for (age, replaced_age, days) in [(0, 0, 20), (100, 0, 70), (200, 0, 90), (100, 100, 90)] {
+        assert_eq!(
+            &day_reward * days,
+            penalty(&day_reward, &twenty_day_reward, age, replaced_age),
+            "age {} replaced age {}",
+            age,
+            replaced_age
+        );
+    }
+    // Without a recorded reward, the penalty is SP(t).
+    assert_eq!(
+        TokenAmount::from_atto(5_541_538_603_991_040_u64),
+        penalty(&TokenAmount::zero(), &TokenAmount::zero(), 100, 0)
+    );
+}
+
+#[test]
+fn initial_pledge_known_values() {
+    // As in termination_penalty_known_values, a 32GiB sector earns exactly 2^39 atto per epoch
+    // over 2^60 bytes of network power, so the 20 day projection does not round.
+    let reward_estimate = FilterEstimate::new(BigInt::from(1_u128 << 64), Zero::zero());
+    let power_estimate = FilterEstimate::new(StoragePower::from(1_u64 << 60), Zero::zero());
+    let sector_power = StoragePower::from(32_u64 << 30);
+    let circulating_supply = TokenAmount::from_whole(400_000_000);
+    let pledge_cap = TokenAmount::from_atto(999_999_984_306_749_440_u64);
+
+    // With the baseline above network power, the pledge share is 2^35 / 2^62, so the additional
+    // pledge is 3/10 of the circulating supply over 2^27.
+    assert_eq!(
+        TokenAmount::from_atto(31_665_934_879_948_800_u64 + 894_069_671_630_859_375_u64),
+        initial_pledge_for_power(
+            &sector_power,
+            &StoragePower::from(1_u64 << 62),
+            &reward_estimate,
+            &power_estimate,
+            &circulating_supply,
+        )
+    );
+    // Below it, the share is 2^35 / 2^60 and the pledge is capped at about 1 FIL per 32GiB.
+    assert_eq!(
+        pledge_cap,
+        initial_pledge_for_power(
+            &sector_power,
+            &StoragePower::from(1_u64 << 59),
+            &reward_estimate,
+            &power_estimate,
+            &circulating_supply,
+        )
+    );
+    // Over a small network, the 20 day reward alone exceeds the cap.
+    assert_eq!(
+        pledge_cap,
+        initial_pledge_for_power(
+            &sector_power,
+            &StoragePower::from(1_u64 << 40),
+            &reward_estimate,
+            &FilterEstimate::new(StoragePower::from(1_u64 << 45), Zero::zero()),
+            &TokenAmount::zero(),
+        )
+    );
+}
+
+#[test]
+fn disputed_window_post_reward_is_flat() {
+    for power in [
+        PowerPair::zero(),
+        PowerPair::new(
+            StoragePower::from(1_u64 << 60),
+            StoragePower::from(1_u64 << 61),
+        ),
+    ] {
+        assert_eq!(
+            TokenAmount::from_whole(4),
+            reward_for_disputed_window_post(RegisteredPoStProof::StackedDRGWindow32GiBV1, power)
+        );
+    }
+}
+
+#[test]
+fn for_each_sector_while_walks_sparse_sectors_in_order() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let sectors = [70_000, 5, 1, 1_000]
+        .iter()
+        .map(|&sector_number| SectorOnChainInfo {
+            sector_number,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(&store, sectors).unwrap();
+
+    let mut seen = Vec::new();
+    state
+        .for_each_sector_while(&store, |i, info| {
+            assert_eq!(i, info.sector_number);
+            seen.push(i);
+            Ok(true)
+        })
+        .unwrap();
+    assert_eq!(seen, vec![1, 5, 1_000, 70_000]);
+
+    let mut seen = Vec::new();
+    state
+        .for_each_sector_while(&store, |i, _| {
+            seen.push(i);
+            Ok(i < 5)
+        })
+        .unwrap();
+    assert_eq!(seen, vec![1, 5]);
+}
+
+#[test]
+fn for_each_sector_while_fails_on_corrupted_node() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let sectors = [70_000, 5, 1, 1_000]
+        .iter()
+        .map(|&sector_number| SectorOnChainInfo {
+            sector_number,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(&store, sectors).unwrap();
+
+    // The root node links the subtree of sectors below 32^3, and the one holding 70_000.
+    // Overwrite the second with a block that is not an AMT node.
+    let root: (u32, u32, u64, (BytesDe, Vec<Cid>, Vec<()>)) =
+        from_slice(&store.get(&state.sectors).unwrap().unwrap()).unwrap();
+    let links = (root.3).1;
+    assert_eq!(2, links.len());
+    store.put_keyed(&links[1], &[0xa0]).unwrap();
+
+    let mut seen = Vec::new();
+    let result = state.for_each_sector_while(&store, |i, _| {
+        seen.push(i);
+        Ok(true)
+    });
+    assert!(result.is_err());
+    assert_eq!(seen, vec![1, 5, 1_000]);
+}
+
+// Matches the seal to PoSt proof mappings in go-state-types `abi`.
+#[test]
+fn seal_proof_to_post_proof_mappings() {
+    use RegisteredPoStProof::*;
+    use RegisteredSealProof::*;
+    let cases = [
+        (
+            StackedDRG2KiBV1,
+            StackedDRGWindow2KiBV1,
+            StackedDRGWinning2KiBV1,
+        ),
+        (
+            StackedDRG2KiBV1P1,
+            StackedDRGWindow2KiBV1,
+            StackedDRGWinning2KiBV1,
+        ),
+        (
+            StackedDRG8MiBV1,
+            StackedDRGWindow8MiBV1,
+            StackedDRGWinning8MiBV1,
+        ),
+        (
+            StackedDRG8MiBV1P1,
+            StackedDRGWindow8MiBV1,
+            StackedDRGWinning8MiBV1,
+        ),
+        (
+            StackedDRG512MiBV1,
+            StackedDRGWindow512MiBV1,
+            StackedDRGWinning512MiBV1,
+        ),
+        (
+            StackedDRG512MiBV1P1,
+            StackedDRGWindow512MiBV1,
+            StackedDRGWinning512MiBV1,
+        ),
+        (
+            StackedDRG32GiBV1,
+            StackedDRGWindow32GiBV1,
+            StackedDRGWinning32GiBV1,
+        ),
+        (
+            StackedDRG32GiBV1P1,
+            StackedDRGWindow32GiBV1,
+            StackedDRGWinning32GiBV1,
+        ),
+        (
+            StackedDRG64GiBV1,
+            StackedDRGWindow64GiBV1,
+            StackedDRGWinning64GiBV1,
+        ),
+        (
+            StackedDRG64GiBV1P1,
+            StackedDRGWindow64GiBV1,
+            StackedDRGWinning64GiBV1,
+        ),
+    ];
+    for (seal, window, winning) in cases {
+        assert_eq!(seal.registered_window_post_proof().unwrap(), window);
+        assert_eq!(seal.registered_winning_post_proof().unwrap(), winning);
+    }
+    assert!(RegisteredSealProof::Invalid(-1)
+        .registered_window_post_proof()
+        .is_err());
+}
+
+// Matches `RegisteredSealProof.SectorSize()` in go-state-types `abi`, for each proof by its
+// numeric value there. The synthetic PoRep proofs (10 to 14) were added after the v9 actors.
+#[test]
+fn seal_proof_sector_sizes() {
+    let cases = [
+        (0, SectorSize::_2KiB),
+        (1, SectorSize::_8MiB),
+        (2, SectorSize::_512MiB),
+        (3, SectorSize::_32GiB),
+        (4, SectorSize::_64GiB),
+        (5, SectorSize::_2KiB),
+        (6, SectorSize::_8MiB),
+        (7, SectorSize::_512MiB),
+        (8, SectorSize::_32GiB),
+        (9, SectorSize::_64GiB),
+    ];
+    for (code, size) in cases {
+        let proof = RegisteredSealProof::from(code);
+        assert_eq!(size, proof.sector_size().unwrap(), "proof {}", code);
+    }
+    assert_eq!(2 << 10, SectorSize::_2KiB as u64);
+    assert_eq!(8 << 20, SectorSize::_8MiB as u64);
+    assert_eq!(512 << 20, SectorSize::_512MiB as u64);
+    assert_eq!(32 << 30, SectorSize::_32GiB as u64);
+    assert_eq!(64 << 30, SectorSize::_64GiB as u64);
+    for code in [-1, 10, 14] {
+        assert!(
+            RegisteredSealProof::from(code).sector_size().is_err(),
+            "proof {}",
+            code
+        );
+    }
+}
+
+#[test]
+fn partition_live_sectors_count_and_membership() {
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut partition = Partition::new(&store).unwrap();
+
+    // One long run followed by alternating single bits.
+    let sectors = (0..100_000).chain((100_001..100_200).step_by(2));
+    partition.sectors = BitField::try_from_bits(sectors).unwrap();
+    partition.terminated = BitField::try_from_bits((50_000..50_010).chain([100_001])).unwrap();
+
+    assert_eq!(partition.live_sectors_count(), 100_000 + 100 - 11);
+    assert!(partition.contains_sector(0));
+    assert!(partition.contains_sector(99_999));
+    assert!(!partition.contains_sector(100_000));
+    assert!(partition.contains_sector(100_001));
+    assert!(!partition.contains_sector(100_002));
+    assert!(partition.contains_sector(100_199));
+    assert!(!partition.contains_sector(100_200));
+    // Terminated sectors remain assigned to the partition until it is compacted.
+    assert!(partition.contains_sector(50_000));
+}
+
+#[test]
+fn partition_expiration_queue() {
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut partition = Partition::new(&store).unwrap();
+    assert!(partition.expiration_queue(&store).unwrap().is_empty());
+
+    let sectors: Vec<_> = [(1, 1000), (2, 1000), (3, 2000)]
+        .iter()
+        .map(|&(sector_number, expiration)| SectorOnChainInfo {
+            sector_number,
+            expiration,
+            ..Default::default()
+        })
+        .collect();
+    partition
+        .add_sectors(&store, true, &sectors, SectorSize::_32GiB, NO_QUANTIZATION)
+        .unwrap();
+    // Faulting sector 3 reschedules it to expire early, before its on-time expiration.
+    let root = fil_actors_runtime_v9::Array::<SectorOnChainInfo, _>::new_with_bit_width(
+        &store,
+        SECTORS_AMT_BITWIDTH,
+    )
+    .flush()
+    .unwrap();
+    let mut sectors_arr = Sectors::load(&store, &root).unwrap();
+    sectors_arr.store(sectors.clone()).unwrap();
+    partition
+        .record_faults(
+            &store,
+            &sectors_arr,
+            &BitField::try_from_bits([3]).unwrap(),
+            1500,
+            SectorSize::_32GiB,
+            NO_QUANTIZATION,
+        )
+        .unwrap();
+
+    let queue = partition.expiration_queue(&store).unwrap();
+    let bits = |bf: &BitField| bf.iter().collect::<Vec<_>>();
+    assert_eq!(
+        vec![1000, 1500],
+        queue.iter().map(|(epoch, _)| *epoch).collect::<Vec<_>>()
+    );
+    assert_eq!(vec![1, 2], bits(&queue[0].1.on_time_sectors));
+    assert!(queue[0].1.early_sectors.is_empty());
+    assert!(queue[1].1.on_time_sectors.is_empty());
+    assert_eq!(vec![3], bits(&queue[1].1.early_sectors));
+}
+
+#[test]
+fn partition_fault_and_active_sector_counts() {
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut partition = Partition::new(&store).unwrap();
+    partition.sectors = BitField::try_from_bits(0..10).unwrap();
+    partition.terminated = BitField::try_from_bits([0]).unwrap();
+    partition.unproven = BitField::try_from_bits([9]).unwrap();
+    partition.faults = BitField::try_from_bits([1, 2, 3]).unwrap();
+    // Recovering sectors remain faulty until proven.
+    partition.recoveries = BitField::try_from_bits([2, 3]).unwrap();
+
+    assert_eq!(3, partition.faults_count());
+    assert_eq!(2, partition.recoveries_count());
+    assert_eq!(9, partition.live_sectors_count());
+    // Active sectors are live sectors less faults (recovering or not) and unproven sectors.
+    assert_eq!(
+        (4..9).collect::<Vec<u64>>(),
+        partition.active_sectors().iter().collect::<Vec<_>>()
+    );
+}
+
+#[test]
+fn miner_info_cid() {
+    let mut info = MinerInfo::new(
+        Address::new_id(100),
+        Address::new_id(101),
+        vec![Address::new_id(102)],
+        b"peer".to_vec(),
+        vec![BytesDe(vec![0x04, 0x7f, 0x00, 0x00, 0x01])],
+        RegisteredPoStProof::StackedDRGWindow32GiBV1,
+    )
+    .unwrap();
+    assert_eq!(
+        "bafy2bzacedvgyfvrolzz3u75rniv3ktnbuuz3adfrhvysqhecr26shs2zu5yc",
+        info.cid().unwrap().to_string()
+    );
+
+    info.pending_worker_key = Some(WorkerKeyChange {
+        new_worker: Address::new_id(103),
+        effective_at: 1000,
+    });
+    assert_eq!(
+        "bafy2bzacebj77v7e7qpcp4toojpgygh42ybnrdmq7zzxrgqysmrvtlmqevhyq",
+        info.cid().unwrap().to_string()
+    );
+}
+
+#[test]
+fn control_addresses_with_pending_worker() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut info = MinerInfo::new(
+        Address::new_id(100),
+        Address::new_id(101),
+        vec![Address::new_id(102), Address::new_id(103)],
+        b"peer".to_vec(),
+        vec![],
+        RegisteredPoStProof::StackedDRGWindow32GiBV1,
+    )
+    .unwrap();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    state.save_info(&store, &info).unwrap();
+
+    let addrs = state.control_addresses(&store).unwrap();
+    assert_eq!(Address::new_id(100), addrs.owner);
+    assert_eq!(Address::new_id(101), addrs.worker);
+    assert_eq!(
+        vec![Address::new_id(102), Address::new_id(103)],
+        addrs.control
+    );
+    assert_eq!(None, addrs.pending_worker);
+
+    info.pending_worker_key = Some(WorkerKeyChange {
+        new_worker: Address::new_id(104),
+        effective_at: 1000,
+    });
+    state.save_info(&store, &info).unwrap();
+    let addrs = state.control_addresses(&store).unwrap();
+    assert_eq!(Some(Address::new_id(104)), addrs.pending_worker);
+    assert_eq!(Address::new_id(101), addrs.worker);
+}
+
+#[test]
+fn sector_expiration_on_time_and_faulty() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let sectors: Vec<_> = [(1, 1_000_000), (2, 1_200_000)]
+        .iter()
+        .map(|&(sector_number, expiration)| SectorOnChainInfo {
+            sector_number,
+            expiration,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(&store, sectors.clone()).unwrap();
+    state
+        .assign_sectors_to_deadlines(&policy, &store, 0, sectors, 2349, SectorSize::_32GiB)
+        .unwrap();
+
+    // Fault sector 2, scheduling it to expire early.
+    let (deadline_idx, partition_idx) = state.find_sector(&policy, &store, 2).unwrap();
+    let quant = state.quant_spec_for_deadline(&policy, deadline_idx);
+    let mut deadlines = state.load_deadlines(&store).unwrap();
+    let mut deadline = deadlines
+        .load_deadline(&policy, &store, deadline_idx)
+        .unwrap();
+    let mut faults = PartitionSectorMap::default();
+    faults
+        .add(partition_idx, BitField::try_from_bits([2]).unwrap())
+        .unwrap();
+    deadline
+        .record_faults(
+            &store,
+            &Sectors::load(&store, &state.sectors).unwrap(),
+            SectorSize::_32GiB,
+            quant,
+            5_000,
+            &mut faults,
+        )
+        .unwrap();
+    deadlines
+        .update_deadline(&policy, &store, deadline_idx, &deadline)
+        .unwrap();
+    state.save_deadlines(&store, deadlines).unwrap();
+
+    assert_eq!(
+        quant.quantize_up(1_000_000),
+        state.sector_expiration(&policy, &store, 1).unwrap()
+    );
+    assert_eq!(
+        quant.quantize_up(5_000),
+        state.sector_expiration(&policy, &store, 2).unwrap()
+    );
+
+    let err = state.sector_expiration(&policy, &store, 3).unwrap_err();
+    assert_eq!(
+        ExitCode::USR_NOT_FOUND,
+        err.downcast::<ActorError>().unwrap().exit_code()
+    );
+}
+
+#[test]
+fn precommit_info_until_proven() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let precommit = SectorPreCommitOnChainInfo {
+        info: SectorPreCommitInfo {
+            seal_proof: RegisteredSealProof::StackedDRG32GiBV1P1,
+            sector_number: 7,
+            expiration: 600_000,
+            ..Default::default()
+        },
+        pre_commit_deposit: TokenAmount::from_atto(12_345),
+        pre_commit_epoch: 100,
+    };
+    state
+        .put_precommitted_sectors(&store, vec![precommit.clone()])
+        .unwrap();
+
+    let found = state.precommit_info(&store, 7).unwrap().unwrap();
+    assert_eq!(precommit, found);
+    assert_eq!(TokenAmount::from_atto(12_345), found.pre_commit_deposit);
+    assert_eq!(600_000, found.info.expiration);
+    assert!(state.precommit_info(&store, 8).unwrap().is_none());
+
+    // Once proven, the sector is no longer reported as pending.
+    state
+        .put_sectors(
+            &store,
+            vec![SectorOnChainInfo {
+                sector_number: 7,
+                expiration: 600_000,
+                ..Default::default()
+            }],
+        )
+        .unwrap();
+    assert!(state.precommit_info(&store, 7).unwrap().is_none());
+}
+
+#[test]
+fn sector_deal_ids_for_cc_and_deal_sectors() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    state
+        .put_sectors(
+            &store,
+            vec![
+                SectorOnChainInfo {
+                    sector_number: 1,
+                    ..Default::default()
+                },
+                SectorOnChainInfo {
+                    sector_number: 2,
+                    deal_ids: vec![10, 11, 42],
+                    ..Default::default()
+                },
+            ],
+        )
+        .unwrap();
+
+    assert!(state.sector_deal_ids(&store, 1).unwrap().is_empty());
+    assert_eq!(vec![10, 11, 42], state.sector_deal_ids(&store, 2).unwrap());
+    assert!(state.sector_deal_ids(&store, 3).is_err());
+}
+
+#[test]
+fn sector_duration_and_remaining_lifetime() {
+    let sector = SectorOnChainInfo {
+        activation: 1000,
+        expiration: 1000 + 180 * EPOCHS_IN_DAY,
+        ..Default::default()
+    };
+    assert_eq!(180 * EPOCHS_IN_DAY, sector.duration());
+    // Freshly activated.
+    assert_eq!(180 * EPOCHS_IN_DAY, sector.remaining_lifetime(1000));
+    // Nearly expired.
+    assert_eq!(1, sector.remaining_lifetime(sector.expiration - 1));
+    assert_eq!(0, sector.remaining_lifetime(sector.expiration));
+    assert_eq!(0, sector.remaining_lifetime(sector.expiration + 100));
+}
+
+#[test]
+fn sector_is_updated_by_replica_update() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let sector_key = Cid::new_v1(
+        FIL_COMMITMENT_SEALED,
+        Multihash::wrap(POSEIDON_BLS12_381_A1_FC1, &[7; 32]).unwrap(),
+    );
+    state
+        .put_sectors(
+            &store,
+            vec![
+                SectorOnChainInfo {
+                    sector_number: 1,
+                    ..Default::default()
+                },
+                SectorOnChainInfo {
+                    sector_number: 2,
+                    sector_key_cid: Some(sector_key),
+                    ..Default::default()
+                },
+            ],
+        )
+        .unwrap();
+
+    assert!(!state.sector_is_updated(&store, 1).unwrap());
+    assert_eq!(None, state.sector_key_cid(&store, 1).unwrap());
+    assert!(state.sector_is_updated(&store, 2).unwrap());
+    assert_eq!(Some(sector_key), state.sector_key_cid(&store, 2).unwrap());
+    assert!(state.sector_is_updated(&store, 3).is_err());
+}
+
+#[test]
+fn deadline_open_close_epochs() {
+    let policy = Policy::default();
+    let last = policy.wpost_period_deadlines - 1;
+    assert_eq!(47, last);
+
+    assert_eq!((1000, 1060), deadline_open_close(&policy, 1000, 0));
+    assert_eq!((3820, 3880), deadline_open_close(&policy, 1000, last));
+    assert_eq!((3880, 3880), deadline_open_close(&policy, 1000, last + 1));
+    for idx in [0, 1, last] {
+        let info = new_deadline_info(&policy, 1000, idx, 0);
+        assert_eq!(
+            (info.open, info.close),
+            deadline_open_close(&policy, 1000, idx)
+        );
+    }
+
+    let far = ChainEpoch::MAX - 100;
+    assert_eq!(
+        (far, far + policy.wpost_challenge_window),
+        deadline_open_close(&policy, far, 0)
+    );
+    assert_eq!(
+        (ChainEpoch::MAX, ChainEpoch::MAX),
+        deadline_open_close(&policy, far, last)
+    );
+}
+
+#[test]
+fn current_deadline_wraps_into_next_period() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let state = State::new(&policy, &store, Cid::default(), 1000, 0).unwrap();
+    let window = policy.wpost_challenge_window;
+    let period = policy.wpost_proving_period;
+
+    assert_eq!((0, 1000), state.current_deadline(&policy, 1000));
+    assert_eq!(
+        (24, 1000),
+        state.current_deadline(&policy, 1000 + period / 2)
+    );
+    // Deadline 3 opens exactly at the end of deadline 2.
+    assert_eq!(
+        (2, 1000),
+        state.current_deadline(&policy, 1000 + 3 * window - 1)
+    );
+    assert_eq!(
+        (3, 1000),
+        state.current_deadline(&policy, 1000 + 3 * window)
+    );
+
+    // Past the end of the period.
+    assert_eq!(
+        (0, 1000 + period),
+        state.current_deadline(&policy, 1000 + period)
+    );
+    assert_eq!(
+        (47, 1000 + period),
+        state.current_deadline(&policy, 1000 + 2 * period - 1)
+    );
+    // And before its start.
+    assert_eq!((47, 1000 - period), state.current_deadline(&policy, 999));
+}
+
+#[test]
+fn available_balance_subtracts_pledge_and_fee_debt() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    state.locked_funds = TokenAmount::from_atto(100);
+    state.pre_commit_deposits = TokenAmount::from_atto(200);
+    state.initial_pledge = TokenAmount::from_atto(300);
+    state.fee_debt = TokenAmount::from_atto(50);
+
+    let balance = TokenAmount::from_atto(1_000);
+    assert_eq!(
+        TokenAmount::from_atto(400),
+        state.get_unlocked_balance(&balance).unwrap()
+    );
+    assert_eq!(
+        TokenAmount::from_atto(350),
+        state.get_available_balance(&balance).unwrap()
+    );
+
+    // Fee debt beyond the unlocked balance makes the available balance negative.
+    state.fee_debt = TokenAmount::from_atto(500);
+    assert_eq!(
+        TokenAmount::from_atto(-100),
+        state.get_available_balance(&balance).unwrap()
+    );
+
+    // A balance that does not cover the locked amounts is an error rather than negative.
+    assert!(state
+        .get_available_balance(&TokenAmount::from_atto(500))
+        .is_err());
+}
+
+#[test]
+fn vesting_schedule_and_locked_total() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let funds = VestingFunds {
+        funds: [(100, 10), (200, 20), (300, 30)]
+            .iter()
+            .map(|&(epoch, amount)| VestingFund {
+                epoch,
+                amount: TokenAmount::from_atto(amount),
+            })
+            .collect(),
+    };
+    state.save_vesting_funds(&store, &funds).unwrap();
+
+    assert_eq!(
+        vec![
+            (100, TokenAmount::from_atto(10)),
+            (200, TokenAmount::from_atto(20)),
+            (300, TokenAmount::from_atto(30)),
+        ],
+        state.vesting_funds(&store).unwrap()
+    );
+    assert_eq!(
+        TokenAmount::from_atto(60),
+        state.locked_vesting_at(&store, 100).unwrap()
+    );
+    // Between the first and second unlock points only the first amount has vested.
+    assert_eq!(
+        TokenAmount::from_atto(50),
+        state.locked_vesting_at(&store, 150).unwrap()
+    );
+    assert!(state.locked_vesting_at(&store, 301).unwrap().is_zero());
+}
+
+#[test]
+fn sector_on_chain_info_cbor_round_trip() {
+    fn comm_r(fill: u8) -> Cid {
+        Cid::new_v1(
+            FIL_COMMITMENT_SEALED,
+            Multihash::wrap(POSEIDON_BLS12_381_A1_FC1, &[fill; 32]).unwrap(),
+        )
+    }
+    let base = SectorOnChainInfo {
+        seal_proof: RegisteredSealProof::StackedDRG32GiBV1P1,
+        activation: 100,
+        expiration: 600_000,
+        initial_pledge: TokenAmount::from_atto(1000),
+        expected_day_reward: TokenAmount::from_atto(10),
+        expected_storage_pledge: TokenAmount::from_atto(200),
+        ..Default::default()
+    };
+    let committed_capacity = SectorOnChainInfo {
+        sector_number: 1,
+        sealed_cid: comm_r(1),
+        ..base.clone()
+    };
+    let with_deals = SectorOnChainInfo {
+        sector_number: 2,
+        sealed_cid: comm_r(2),
+        deal_ids: vec![5, 6],
+        deal_weight: DealWeight::from(1000u64 << 40),
+        verified_deal_weight: DealWeight::from(3u64 << 60),
+        ..base.clone()
+    };
+    let upgraded = SectorOnChainInfo {
+        sector_number: 3,
+        sealed_cid: comm_r(3),
+        sector_key_cid: Some(comm_r(4)),
+        simple_qa_power: true,
+        ..base
+    };
+
+    for (info, expected) in [
+        (
+            committed_capacity,
+            "8f0108d82a5829000182e20381e8022001010101010101010101010101010101010101010101010101010101010101018018641a000927c04040430003e842000a4200c80040f6f4",
+        ),
+        (
+            with_deals,
+            "8f0208d82a5829000182e20381e80220020202020202020202020202020202020202020202020202020202020202020282050618641a000927c0480003e8000000000049003000000000000000430003e842000a4200c80040f6f4",
+        ),
+        (
+            upgraded,
+            "8f0308d82a5829000182e20381e8022003030303030303030303030303030303030303030303030303030303030303038018641a000927c04040430003e842000a4200c80040d82a5829000182e20381e802200404040404040404040404040404040404040404040404040404040404040404f5",
+        ),
+    ] {
+        let bytes = to_vec(&info).unwrap();
+        assert_eq!(expected, hex::encode(&bytes));
+        let decoded: SectorOnChainInfo = from_slice(&bytes).unwrap();
+        assert_eq!(info, decoded);
+    }
+}
+
+#[test]
+fn qa_power_for_weight_quality_multipliers() {
+    let size = SectorSize::_32GiB;
+    let duration = 540 * EPOCHS_IN_DAY;
+    let space_time = DealWeight::from(size as u64) * duration;
+    let raw = StoragePower::from(size as u64);
+
+    // Committed capacity has quality 1.
+    let none = DealWeight::zero();
+    assert_eq!(raw, qa_power_for_weight(size, duration, &none, &none));
+    // Unverified deals do not change quality.
+    assert_eq!(raw, qa_power_for_weight(size, duration, &space_time, &none));
+    // A fully verified sector has quality 10.
+    assert_eq!(
+        &raw * 10,
+        qa_power_for_weight(size, duration, &none, &space_time)
+    );
+    assert_eq!(
+        qa_power_max(size),
+        qa_power_for_weight(size, duration, &none, &space_time)
+    );
+
+    // Quarter unverified and a seventh verified: the quality of 1 + 9/7 is truncated to
+    // SECTOR_QUALITY_PRECISION fractional bits, below the exact 78536544841.14 bytes.
+    let deal_weight = &space_time / 4;
+    let verified_weight = &space_time / 7;
+    assert_eq!(
+        BigInt::from(2396745),
+        quality_for_weight(size, duration, &deal_weight, &verified_weight)
+    );
+    assert_eq!(
+        StoragePower::from(78536540160u64),
+        qa_power_for_weight(size, duration, &deal_weight, &verified_weight)
+    );
+}
+
+#[test]
+fn cached_miner_state_matches_uncached() {
+    let policy = Policy::default();
+    let store = TrackingBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let sectors: Vec<_> = (0..10)
+        .map(|sector_number| SectorOnChainInfo {
+            sector_number,
+            expiration: 1_000_000 + sector_number as ChainEpoch * 10_000,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(&store, sectors.clone()).unwrap();
+    state
+        .assign_sectors_to_deadlines(&policy, &store, 0, sectors, 2, SectorSize::_32GiB)
+        .unwrap();
+
+    let cached = CachedMinerState::new(&state, &policy, &store);
+    for sector_number in 0..10 {
+        assert_eq!(
+            state.find_sector(&policy, &store, sector_number).unwrap(),
+            cached.find_sector(sector_number).unwrap()
+        );
+        assert_eq!(
+            state.get_sector(&store, sector_number).unwrap(),
+            cached.get_sector(sector_number).unwrap()
+        );
+        assert_eq!(
+            state
+                .sector_expiration(&policy, &store, sector_number)
+                .unwrap(),
+            cached.sector_expiration(sector_number).unwrap()
+        );
+    }
+    let (deadline_idx, partition_idx) = state.find_sector(&policy, &store, 9).unwrap();
+    let partition = cached.load_partition(deadline_idx, partition_idx).unwrap();
+    assert_eq!(
+        state
+            .load_partition(&policy, &store, deadline_idx, partition_idx)
+            .unwrap()
+            .sectors
+            .iter()
+            .collect::<Vec<_>>(),
+        partition.sectors.iter().collect::<Vec<_>>()
+    );
+    assert!(cached.sector_expiration(10).is_err());
+
+    // Once cached, locating a sector and loading its partition read nothing from the store.
+    store.clear_reads();
+    let (deadline_idx, partition_idx) = cached.find_sector(9).unwrap();
+    cached.load_partition(deadline_idx, partition_idx).unwrap();
+    cached.load_deadline(deadline_idx).unwrap();
+    assert_eq!(0, store.read_count());
+
+    // The first lookup indexes every sector and the first expiration walks the partition's
+    // queue, so other sectors of the miner and of the partition read nothing more.
+    let fresh = CachedMinerState::new(&state, &policy, &store);
+    fresh.sector_expiration(0).unwrap();
+    let (deadline_idx, partition_idx) = fresh.find_sector(0).unwrap();
+    let partition = fresh.load_partition(deadline_idx, partition_idx).unwrap();
+    assert!(partition.sectors.len() > 1);
+    store.clear_reads();
+    for sector_number in 0..10 {
+        fresh.find_sector(sector_number).unwrap();
+    }
+    for sector_number in partition.sectors.iter() {
+        fresh.sector_expiration(sector_number).unwrap();
+    }
+    assert_eq!(0, store.read_count());
+}
+
+fn state_with_sectors<BS: Blockstore>(
+    policy: &Policy,
+    store: &BS,
+    count: u64,
+    partition_size: u64,
+) -> State {
+    let mut state = State::new(policy, store, Cid::default(), 0, 0).unwrap();
+    let sectors: Vec<_> = (0..count)
+        .map(|sector_number| SectorOnChainInfo {
+            sector_number,
+            expiration: 1_000_000,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(store, sectors.clone()).unwrap();
+    state
+        .assign_sectors_to_deadlines(
+            policy,
+            store,
+            0,
+            sectors,
+            partition_size,
+            SectorSize::_32GiB,
+        )
+        .unwrap();
+    state
+}
+
+/// Declares the sectors faulty, expiring at epoch 5_000. They must share a partition.
+fn record_faults<BS: Blockstore>(
+    policy: &Policy,
+    store: &BS,
+    state: &mut State,
+    sector_numbers: &[SectorNumber],
+) {
+    let (deadline_idx, partition_idx) =
+        state.find_sector(policy, store, sector_numbers[0]).unwrap();
+    let quant = state.quant_spec_for_deadline(policy, deadline_idx);
+    let mut deadlines = state.load_deadlines(store).unwrap();
+    let mut deadline = deadlines
+        .load_deadline(policy, store, deadline_idx)
+        .unwrap();
+    let mut faults = PartitionSectorMap::default();
+    faults
+        .add(
+            partition_idx,
+            BitField::try_from_bits(sector_numbers.iter().copied()).unwrap(),
+        )
+        .unwrap();
+    deadline
+        .record_faults(
+            store,
+            &Sectors::load(store, &state.sectors).unwrap(),
+            SectorSize::_32GiB,
+            quant,
+            5_000,
+            &mut faults,
+        )
+        .unwrap();
+    deadlines
+        .update_deadline(policy, store, deadline_idx, &deadline)
+        .unwrap();
+    state.save_deadlines(store, deadlines).unwrap();
+}
+
+#[test]
+fn deadline_summary_counts() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = state_with_sectors(&policy, &store, 20, 4);
+
+    // Fault two sectors of the partition holding sector 0.
+    let (deadline_idx, partition_idx) = state.find_sector(&policy, &store, 0).unwrap();
+    let faulty: Vec<_> = state
+        .load_partition(&policy, &store, deadline_idx, partition_idx)
+        .unwrap()
+        .sectors
+        .iter()
+        .take(2)
+        .collect();
+    record_faults(&policy, &store, &mut state, &faulty);
+
+    let summaries = state.deadline_summary(&policy, &store).unwrap();
+    assert_eq!(policy.wpost_period_deadlines as usize, summaries.len());
+    assert!(summaries.iter().filter(|s| s.partition_count > 0).count() > 1);
+    assert_eq!(20, summaries.iter().map(|s| s.total_sectors).sum::<u64>());
+    assert_eq!(20, summaries.iter().map(|s| s.live_sectors).sum::<u64>());
+    assert_eq!(5, summaries.iter().map(|s| s.partition_count).sum::<u64>());
+    assert_eq!(2, summaries[deadline_idx as usize].faulty_sectors);
+    assert_eq!(2, summaries.iter().map(|s| s.faulty_sectors).sum::<u64>());
+}
+
+#[test]
+fn terminated_sectors_union() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = state_with_sectors(&policy, &store, 20, 4);
+    assert!(state
+        .terminated_sectors(&policy, &store)
+        .unwrap()
+        .is_empty());
+
+    // Terminate sector 0 and a sector in another partition.
+    let location = |state: &State, sector| state.find_sector(&policy, &store, sector).unwrap();
+    let other = (1..20)
+        .find(|&sector| location(&state, sector) != location(&state, 0))
+        .unwrap();
+    let sectors_arr = Sectors::load(&store, &state.sectors).unwrap();
+    for sector in [0, other] {
+        let (deadline_idx, partition_idx) = location(&state, sector);
+        let quant = state.quant_spec_for_deadline(&policy, deadline_idx);
+        let mut deadlines = state.load_deadlines(&store).unwrap();
+        let mut deadline = deadlines
+            .load_deadline(&policy, &store, deadline_idx)
+            .unwrap();
+        let mut terminations = PartitionSectorMap::default();
+        terminations
+            .add(partition_idx, BitField::try_from_bits([sector]).unwrap())
+            .unwrap();
+        deadline
+            .terminate_sectors(
+                &policy,
+                &store,
+                &sectors_arr,
+                100,
+                &mut terminations,
+                SectorSize::_32GiB,
+                quant,
+            )
+            .unwrap();
+        deadlines
+            .update_deadline(&policy, &store, deadline_idx, &deadline)
+            .unwrap();
+        state.save_deadlines(&store, deadlines).unwrap();
+    }
+
+    let terminated = state.terminated_sectors(&policy, &store).unwrap();
+    assert_eq!(vec![0, other], terminated.iter().collect::<Vec<_>>());
+}
+
+#[test]
+fn deadline_post_submissions() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = state_with_sectors(&policy, &store, 20, 4);
+    let (deadline_idx, _) = state.find_sector(&policy, &store, 0).unwrap();
+    assert!(state
+        .deadline_post_submissions(&policy, &store, deadline_idx)
+        .unwrap()
+        .is_empty());
+
+    let mut deadlines = state.load_deadlines(&store).unwrap();
+    let mut deadline = deadlines
+        .load_deadline(&policy, &store, deadline_idx)
+        .unwrap();
+    deadline.partitions_posted = BitField::try_from_bits([0, 2]).unwrap();
+    deadlines
+        .update_deadline(&policy, &store, deadline_idx, &deadline)
+        .unwrap();
+    state.save_deadlines(&store, deadlines).unwrap();
+
+    let posted = state
+        .deadline_post_submissions(&policy, &store, deadline_idx)
+        .unwrap();
+    assert_eq!(vec![0, 2], posted.iter().collect::<Vec<_>>());
+    let other = (deadline_idx + 1) % policy.wpost_period_deadlines;
+    assert!(state
+        .deadline_post_submissions(&policy, &store, other)
+        .unwrap()
+        .is_empty());
+    assert!(state
+        .deadline_post_submissions(&policy, &store, policy.wpost_period_deadlines)
+        .is_err());
+}
+
+#[test]
+fn sectors_due_for_post() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = state_with_sectors(&policy, &store, 20, 4);
+    let (deadline_idx, _) = state.find_sector(&policy, &store, 0).unwrap();
+    let partition_count = state
+        .load_deadlines(&store)
+        .unwrap()
+        .load_deadline(&policy, &store, deadline_idx)
+        .unwrap()
+        .partitions_amt(&store)
+        .unwrap()
+        .count();
+
+    // An epoch in the deadline's challenge window of the third proving period.
+    let at_epoch = 2 * policy.wpost_proving_period
+        + deadline_idx as ChainEpoch * policy.wpost_challenge_window
+        + 10;
+    assert_eq!(
+        (deadline_idx, 2 * policy.wpost_proving_period),
+        state.current_deadline(&policy, at_epoch)
+    );
+    let due = state
+        .sectors_due_for_post(&policy, &store, at_epoch)
+        .unwrap();
+    assert_eq!(1, due.len());
+    assert_eq!(deadline_idx, due[0].0);
+    assert_eq!(
+        (0..partition_count).collect::<Vec<_>>(),
+        due[0].1.iter().collect::<Vec<_>>()
+    );
+
+    // Once every partition is proven, nothing is due.
+    let mut deadlines = state.load_deadlines(&store).unwrap();
+    let mut deadline = deadlines
+        .load_deadline(&policy, &store, deadline_idx)
+        .unwrap();
+    deadline.partitions_posted = due[0].1.clone();
+    deadlines
+        .update_deadline(&policy, &store, deadline_idx, &deadline)
+        .unwrap();
+    state.save_deadlines(&store, deadlines).unwrap();
+    assert!(state
+        .sectors_due_for_post(&policy, &store, at_epoch)
+        .unwrap()
+        .is_empty());
+
+    // Nor in a deadline without sectors.
+    let deadlines = state.load_deadlines(&store).unwrap();
+    let empty_idx = (0..policy.wpost_period_deadlines)
+        .find(|&idx| {
+            let deadline = deadlines.load_deadline(&policy, &store, idx).unwrap();
+            deadline.partitions_amt(&store).unwrap().count() == 0
+        })
+        .unwrap();
+    let at_epoch = empty_idx as ChainEpoch * policy.wpost_challenge_window;
+    assert!(state
+        .sectors_due_for_post(&policy, &store, at_epoch)
+        .unwrap()
+        .is_empty());
+}
+
+#[test]
+fn load_sectors_selects_subset_in_one_pass() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let sectors: Vec<_> = (0..2000)
+        .map(|i| SectorOnChainInfo {
+            sector_number: i * 3,
+            expiration: 1000 + i as ChainEpoch,
+            ..Default::default()
+        })
+        .collect();
+    state.put_sectors(&store, sectors.clone()).unwrap();
+
+    let all = state.load_sectors(&store, None).unwrap();
+    assert_eq!(sectors, all);
+
+    // Every tenth sector, plus sector numbers that were never committed.
+    let wanted =
+        BitField::try_from_bits((0..2000).step_by(10).map(|i| i * 3).chain([1, 7_000])).unwrap();
+    let subset = state.load_sectors(&store, Some(&wanted)).unwrap();
+    let expected: Vec<_> = sectors.iter().step_by(10).cloned().collect();
+    assert_eq!(expected, subset);
+
+    let present = BitField::try_from_bits(expected.iter().map(|s| s.sector_number)).unwrap();
+    assert_eq!(expected, state.load_sector_infos(&store, &present).unwrap());
+
+    assert!(state
+        .load_sectors(&store, Some(&BitField::new()))
+        .unwrap()
+        .is_empty());
+}
+
+#[test]
+fn next_free_sector_number_finds_first_gap() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    assert!(state.allocated_sectors(&store).unwrap().is_empty());
+    assert_eq!(0, state.next_free_sector_number(&store).unwrap());
+
+    let allocated = BitField::try_from_bits([0, 1, 2, 5, 6, 100]).unwrap();
+    state
+        .allocate_sector_numbers(&store, &allocated, CollisionPolicy::DenyCollisions)
+        .unwrap();
+    assert_eq!(allocated, state.allocated_sectors(&store).unwrap());
+    assert_eq!(3, state.next_free_sector_number(&store).unwrap());
+
+    let allocated = BitField::try_from_bits([3, 4]).unwrap();
+    state
+        .allocate_sector_numbers(&store, &allocated, CollisionPolicy::DenyCollisions)
+        .unwrap();
+    assert_eq!(7, state.next_free_sector_number(&store).unwrap());
+
+    // Sector 0 free, with higher numbers allocated.
+    let mut state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    let allocated = BitField::try_from_bits([1, 2]).unwrap();
+    state
+        .allocate_sector_numbers(&store, &allocated, CollisionPolicy::AllowCollisions)
+        .unwrap();
+    assert_eq!(0, state.next_free_sector_number(&store).unwrap());
+}
+
+#[test]
+fn export_summary_matches_enumeration() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let state = State::new(&policy, &store, Cid::default(), 0, 0).unwrap();
+    assert_eq!(
+        MinerSummary::default(),
+        state.export_summary(&policy, &store).unwrap()
+    );
+
+    let mut state = state_with_sectors(&policy, &store, 20, 4);
+    state.initial_pledge = TokenAmount::from_atto(1234);
+    record_faults(&policy, &store, &mut state, &[0]);
+
+    let summary = state.export_summary(&policy, &store).unwrap();
+    let sector_power = StoragePower::from(SectorSize::_32GiB as u64);
+    assert_eq!(
+        state.load_sectors(&store, None).unwrap().len() as u64,
+        summary.live_sectors
+    );
+    assert_eq!(&sector_power * 20, summary.live_power.raw);
+    assert_eq!(sector_power, summary.faulty_power.raw);
+    assert_eq!(1, summary.faulty_sectors);
+    assert_eq!(TokenAmount::from_atto(1234), summary.initial_pledge);
+    let active: Vec<_> = state
+        .deadline_summary(&policy, &store)
+        .unwrap()
+        .iter()
+        .enumerate()
+        .filter(|(_, d)| d.live_sectors > 0)
+        .map(|(i, _)| i as u64)
+        .collect();
+    assert!(active.len() > 1);
+    assert_eq!(active, summary.active_deadlines);
+
+    let decoded: MinerSummary = from_slice(&to_vec(&summary).unwrap()).unwrap();
+    assert_eq!(summary, decoded);
+}
+
+#[test]
+fn load_partition_targets_one_partition() {
+    let policy = Policy::default();
+    let store = fvm_ipld_blockstore::MemoryBlockstore::default();
+    let state = state_with_sectors(&policy, &store, 10, 2);
+
+    let deadlines = state.load_deadlines(&store).unwrap();
+    for sector_number in 0..10 {
+        let (deadline_idx, partition_idx) =
+            state.find_sector(&policy, &store, sector_number).unwrap();
+        let partition = state
+            .load_partition(&policy, &store, deadline_idx, partition_idx)
+            .unwrap();
+        assert!(partition.sectors.get(sector_number));
+        let expected = deadlines
+            .load_deadline(&policy, &store, deadline_idx)
+            .unwrap()
+            .load_partition(&store, partition_idx)
+            .unwrap();
+        assert_eq!(
+            expected.sectors.iter().collect::<Vec<_>>(),
+            partition.sectors.iter().collect::<Vec<_>>()
+        );
+    }
+
+    let exit_code = |err: anyhow::Error| err.downcast::<ActorError>().unwrap().exit_code();
+    let err = state
+        .load_partition(&policy, &store, policy.wpost_period_deadlines, 0)
+        .unwrap_err();
+    assert_eq!(ExitCode::USR_ILLEGAL_ARGUMENT, exit_code(err));
+
+    // Past the last partition of a deadline in use.
+    let (deadline_idx, _) = state.find_sector(&policy, &store, 0).unwrap();
+    let partition_count = deadlines
+        .load_deadline(&policy, &store, deadline_idx)
+        .unwrap()
+        .partitions_amt(&store)
+        .unwrap()
+        .count();
+    let err = state
+        .load_partition(&policy, &store, deadline_idx, partition_count)
+        .unwrap_err();
+    assert_eq!(ExitCode::USR_NOT_FOUND, exit_code(err));
+
+    // Ten sectors fill five partitions, so most deadlines have none.
+    let empty_idx = (0..policy.wpost_period_deadlines)
+        .find(|&idx| {
+            let deadline = deadlines.load_deadline(&policy, &store, idx).unwrap();
+            deadline.partitions_amt(&store).unwrap().count() == 0
+        })
+        .unwrap();
+    let err = state
+        .load_partition(&policy, &store, empty_idx, 0)
+        .unwrap_err();
+    assert_eq!(ExitCode::USR_NOT_FOUND, exit_code(err));
+}
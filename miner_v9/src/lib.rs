@@ -26,6 +26,7 @@ pub use policy::*;
 pub use sector_map::*;
 pub use sectors::*;
 pub use state::*;
+pub use state_cache::*;
 pub use termination::*;
 pub use types::*;
 pub use vesting_state::*;
@@ -46,6 +47,7 @@ mod policy;
 mod sector_map;
 mod sectors;
 mod state;
+mod state_cache;
 mod termination;
 mod types;
 mod vesting_state;
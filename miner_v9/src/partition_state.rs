@@ -95,6 +95,61 @@ impl Partition {
         &self.sectors - &self.terminated
     }
 
+    /// Number of live sectors, counted over the run-length encoded bitfields.
+    pub fn live_sectors_count(&self) -> u64 {
+        self.live_sectors().len()
+    }
+
+    /// Returns true if the sector is assigned to this partition, including faulty, unproven and
+    /// terminated sectors.
+    pub fn contains_sector(&self, sector_number: u64) -> bool {
+        self.sectors.get(sector_number)
+    }
+
+    /// Returns the epoch at which the sector is scheduled to expire in the partition's expiration
+    /// queue, either on time or early for being faulty, or `None` if it is not scheduled.
+    pub fn sector_expiration<BS: Blockstore>(
+        &self,
+        store: &BS,
+        sector_number: u64,
+    ) -> anyhow::Result<Option<ChainEpoch>> {
+        let expirations = Array::<ExpirationSet, _>::load(&self.expirations_epochs, store)?;
+        let mut expiration = None;
+        expirations.for_each_while(|epoch, set| {
+            if set.on_time_sectors.get(sector_number) || set.early_sectors.get(sector_number) {
+                expiration = Some(epoch as ChainEpoch);
+                return Ok(false);
+            }
+            Ok(true)
+        })?;
+        Ok(expiration)
+    }
+
+    /// Returns the partition's expiration queue: the sectors scheduled to expire at each epoch,
+    /// on time or early for being faulty, in epoch order.
+    pub fn expiration_queue<BS: Blockstore>(
+        &self,
+        store: &BS,
+    ) -> anyhow::Result<Vec<(ChainEpoch, ExpirationSet)>> {
+        let expirations = Array::<ExpirationSet, _>::load(&self.expirations_epochs, store)?;
+        let mut queue = Vec::new();
+        expirations.for_each(|epoch, set| {
+            queue.push((epoch as ChainEpoch, set.clone()));
+            Ok(())
+        })?;
+        Ok(queue)
+    }
+
+    /// Number of faulty sectors, including those declared as recovering.
+    pub fn faults_count(&self) -> u64 {
+        self.faults.len()
+    }
+
+    /// Number of faulty sectors declared as recovering.
+    pub fn recoveries_count(&self) -> u64 {
+        self.recoveries.len()
+    }
+
     /// Active sectors are those that are neither terminated nor faulty nor unproven, i.e. actively contributing power.
     pub fn active_sectors(&self) -> BitField {
         let non_faulty = &self.live_sectors() - &self.faults;
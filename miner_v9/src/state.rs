@@ -21,6 +21,7 @@ use fvm_ipld_hamt::Error as HamtError;
 use fvm_shared::address::Address;
 
 use fvm_shared::clock::{ChainEpoch, QuantSpec, EPOCH_UNDEFINED};
+use fvm_shared::deal::DealID;
 use fvm_shared::econ::TokenAmount;
 use fvm_shared::error::ExitCode;
 use fvm_shared::sector::{RegisteredPoStProof, SectorNumber, SectorSize, MAX_SECTOR_NUMBER};
@@ -33,8 +34,8 @@ use super::policy::*;
 use super::types::*;
 use super::{
     assign_deadlines, deadline_is_mutable, new_deadline_info_from_offset_and_epoch,
-    quant_spec_for_deadline, BitFieldQueue, Deadline, DeadlineInfo, DeadlineSectorMap, Deadlines,
-    PowerPair, Sectors, TerminationResult, VestingFunds,
+    quant_spec_for_deadline, BitFieldQueue, Deadline, DeadlineInfo, DeadlineSectorMap,
+    DeadlineSummary, Deadlines, Partition, PowerPair, Sectors, TerminationResult, VestingFunds,
 };
 
 const PRECOMMIT_EXPIRY_AMT_BITWIDTH: u32 = 6;
@@ -217,6 +218,20 @@ impl State {
         }
     }
 
+    /// Returns the addresses that may send messages on behalf of the miner, read from its info.
+    pub fn control_addresses<BS: Blockstore>(
+        &self,
+        store: &BS,
+    ) -> anyhow::Result<ControlAddresses> {
+        let info = self.get_info(store)?;
+        Ok(ControlAddresses {
+            owner: info.owner,
+            worker: info.worker,
+            control: info.control_addresses,
+            pending_worker: info.pending_worker_key.map(|change| change.new_worker),
+        })
+    }
+
     pub fn save_info<BS: Blockstore>(
         &mut self,
         store: &BS,
@@ -256,6 +271,18 @@ impl State {
         dl_info.period_start
     }
 
+    /// Returns the index of the deadline open at `current_epoch`, and the start of the proving
+    /// period it belongs to. This is derived from the epoch rather than the recorded deadline, so
+    /// it wraps into the next proving period once the recorded one has ended.
+    pub fn current_deadline(
+        &self,
+        policy: &Policy,
+        current_epoch: ChainEpoch,
+    ) -> (u64, ChainEpoch) {
+        let dl_info = self.deadline_info(policy, current_epoch);
+        (dl_info.index, dl_info.period_start)
+    }
+
     /// Returns deadline calculations for the current (according to state) proving period.
     pub fn quant_spec_for_deadline(&self, policy: &Policy, deadline_idx: u64) -> QuantSpec {
         new_deadline_info(policy, self.proving_period_start, deadline_idx, 0).quant_spec()
@@ -307,6 +334,34 @@ impl State {
         Ok(())
     }
 
+    /// Loads the set of sector numbers that have been allocated, including those of sectors since
+    /// terminated, which may not be reused.
+    pub fn allocated_sectors<BS: Blockstore>(&self, store: &BS) -> anyhow::Result<BitField> {
+        store.get_cbor(&self.allocated_sectors)?.ok_or_else(|| {
+            anyhow!(
+                "allocated sectors bitfield {} not found",
+                self.allocated_sectors
+            )
+        })
+    }
+
+    /// Returns the lowest sector number that has not been allocated. Allocations need not be
+    /// contiguous, so this is the first gap rather than one past the highest allocated number.
+    pub fn next_free_sector_number<BS: Blockstore>(
+        &self,
+        store: &BS,
+    ) -> anyhow::Result<SectorNumber> {
+        let allocated = self.allocated_sectors(store)?;
+        let next = match allocated.ranges().next() {
+            Some(range) if range.start == 0 => range.end,
+            _ => 0,
+        };
+        if next > MAX_SECTOR_NUMBER {
+            return Err(anyhow!("all sector numbers are allocated"));
+        }
+        Ok(next)
+    }
+
     /// Stores a pre-committed sector info, failing if the sector number is already present.
     pub fn put_precommitted_sectors<BS: Blockstore>(
         &mut self,
@@ -341,6 +396,23 @@ impl State {
         Ok(precommitted.get(&u64_key(sector_num))?.cloned())
     }
 
+    /// Returns the pre-commitment for a sector that is still awaiting its prove-commit.
+    /// Returns `None` if there is no such pre-commitment, or if the sector has already been
+    /// proven and moved into the sectors array.
+    pub fn precommit_info<BS: Blockstore>(
+        &self,
+        store: &BS,
+        sector_num: SectorNumber,
+    ) -> anyhow::Result<Option<SectorPreCommitOnChainInfo>> {
+        if self.get_sector(store, sector_num)?.is_some() {
+            return Ok(None);
+        }
+        self.get_precommitted_sector(store, sector_num)
+            .map_err(|e| {
+                e.downcast_wrap(format!("failed to load precommitment for {}", sector_num))
+            })
+    }
+
     /// Gets and returns the requested pre-committed sectors, skipping missing sectors.
     pub fn find_precommitted_sectors<BS: Blockstore>(
         &self,
@@ -429,6 +501,43 @@ impl State {
         sectors.get(sector_num)
     }
 
+    /// Returns the IDs of the deals stored in a sector, which is empty for a committed-capacity
+    /// sector. Errors if the sector is not in the state.
+    pub fn sector_deal_ids<BS: Blockstore>(
+        &self,
+        store: &BS,
+        sector_num: SectorNumber,
+    ) -> anyhow::Result<Vec<DealID>> {
+        let sector = self
+            .get_sector(store, sector_num)?
+            .ok_or_else(|| actor_error!(not_found, "sector {} not found", sector_num))?;
+        Ok(sector.deal_ids)
+    }
+
+    /// Returns the CID of a sector's original sealed replica if its data has been replaced by a
+    /// replica update (a snap deal), or `None` for a sector as sealed. Errors if the sector is not
+    /// in the state.
+    pub fn sector_key_cid<BS: Blockstore>(
+        &self,
+        store: &BS,
+        sector_num: SectorNumber,
+    ) -> anyhow::Result<Option<Cid>> {
+        let sector = self
+            .get_sector(store, sector_num)?
+            .ok_or_else(|| actor_error!(not_found, "sector {} not found", sector_num))?;
+        Ok(sector.sector_key_cid)
+    }
+
+    /// Returns whether a sector has been upgraded by a replica update. Errors if the sector is
+    /// not in the state.
+    pub fn sector_is_updated<BS: Blockstore>(
+        &self,
+        store: &BS,
+        sector_num: SectorNumber,
+    ) -> anyhow::Result<bool> {
+        Ok(self.sector_key_cid(store, sector_num)?.is_some())
+    }
+
     pub fn delete_sectors<BS: Blockstore>(
         &mut self,
         store: &BS,
@@ -462,6 +571,139 @@ impl State {
         Ok(())
     }
 
+    /// Iterates over sectors in ascending sector number order for as long as `f` returns `true`.
+    pub fn for_each_sector_while<BS: Blockstore, F>(
+        &self,
+        store: &BS,
+        mut f: F,
+    ) -> anyhow::Result<()>
+    where
+        F: FnMut(SectorNumber, &SectorOnChainInfo) -> anyhow::Result<bool>,
+    {
+        let sectors = Sectors::load(store, &self.sectors)?;
+        sectors.amt.for_each_while(|i, v| f(i, v))?;
+        Ok(())
+    }
+
+    /// Returns the sector and partition counts of each deadline, in deadline order.
+    pub fn deadline_summary<BS: Blockstore>(
+        &self,
+        policy: &Policy,
+        store: &BS,
+    ) -> anyhow::Result<Vec<DeadlineSummary>> {
+        let deadlines = self.load_deadlines(store)?;
+        let mut summaries = Vec::with_capacity(deadlines.due.len());
+        deadlines.for_each(policy, store, |_, deadline| {
+            let partitions = deadline.partitions_amt(store)?;
+            let mut faulty_sectors = 0;
+            partitions.for_each(|_, partition| {
+                faulty_sectors += partition.faults_count();
+                Ok(())
+            })?;
+            summaries.push(DeadlineSummary {
+                total_sectors: deadline.total_sectors,
+                live_sectors: deadline.live_sectors,
+                faulty_sectors,
+                partition_count: partitions.count(),
+            });
+            Ok(())
+        })?;
+        Ok(summaries)
+    }
+
+    /// Returns the numbers of all sectors the miner has terminated, across every partition of every
+    /// deadline. Terminated sectors stay in their partition until it is compacted, so sectors
+    /// removed by compaction are not included.
+    pub fn terminated_sectors<BS: Blockstore>(
+        &self,
+        policy: &Policy,
+        store: &BS,
+    ) -> anyhow::Result<BitField> {
+        let mut terminated = BitField::new();
+        self.load_deadlines(store)?
+            .for_each(policy, store, |_, deadline| {
+                deadline.partitions_amt(store)?.for_each(|_, partition| {
+                    terminated |= &partition.terminated;
+                    Ok(())
+                })?;
+                Ok(())
+            })?;
+        Ok(terminated)
+    }
+
+    /// Returns the indices of the partitions of a deadline that have submitted their window PoSt in
+    /// the current proving period, which is empty for a deadline with no submissions yet.
+    pub fn deadline_post_submissions<BS: Blockstore>(
+        &self,
+        policy: &Policy,
+        store: &BS,
+        deadline_idx: u64,
+    ) -> anyhow::Result<BitField> {
+        let deadline = self
+            .load_deadlines(store)?
+            .load_deadline(policy, store, deadline_idx)?;
+        Ok(deadline.partitions_posted)
+    }
+
+    /// Returns the deadline open at `at_epoch`, as by [`State::current_deadline`], with the
+    /// partitions in it that still need a window PoSt: those with live sectors that have not yet
+    /// been proven in this window. The result is empty if there are none.
+    pub fn sectors_due_for_post<BS: Blockstore>(
+        &self,
+        policy: &Policy,
+        store: &BS,
+        at_epoch: ChainEpoch,
+    ) -> anyhow::Result<Vec<(u64, BitField)>> {
+        let (deadline_idx, _) = self.current_deadline(policy, at_epoch);
+        let deadline = self
+            .load_deadlines(store)?
+            .load_deadline(policy, store, deadline_idx)?;
+        let mut due = BitField::new();
+        deadline
+            .partitions_amt(store)?
+            .for_each(|partition_idx, partition| {
+                if !partition.live_sectors().is_empty()
+                    && !deadline.partitions_posted.get(partition_idx)
+                {
+                    due.set(partition_idx);
+                }
+                Ok(())
+            })?;
+        if due.is_empty() {
+            return Ok(Vec::new());
+        }
+        Ok(vec![(deadline_idx, due)])
+    }
+
+    /// Returns a summary of the miner's power, pledge, sectors and active deadlines, which can be
+    /// stored and read back in place of decoding the full state.
+    pub fn export_summary<BS: Blockstore>(
+        &self,
+        policy: &Policy,
+        store: &BS,
+    ) -> anyhow::Result<MinerSummary> {
+        let mut summary = MinerSummary {
+            initial_pledge: self.initial_pledge.clone(),
+            ..Default::default()
+        };
+        let deadlines = self.load_deadlines(store)?;
+        deadlines.for_each(policy, store, |deadline_idx, deadline| {
+            let partitions = deadline.partitions_amt(store)?;
+            partitions.for_each(|_, partition| {
+                summary.live_power += &partition.live_power;
+                summary.faulty_power += &partition.faulty_power;
+                summary.faulty_sectors += partition.faults_count();
+                Ok(())
+            })?;
+            summary.live_sectors += deadline.live_sectors;
+            if deadline.live_sectors > 0 {
+                summary.active_deadlines.push(deadline_idx);
+            }
+            Ok(())
+        })?;
+        Ok(summary)
+    }
+
     /// Returns the deadline and partition index for a sector number.
     pub fn find_sector<BS: Blockstore>(
         &self,
@@ -754,6 +996,24 @@ impl State {
         Ok(Sectors::load(store, &self.sectors)?.load_sector(sectors)?)
     }
 
+    /// Loads the sectors in `sectors`, or every sector if `None`, in sector number order.
+    /// Unlike `load_sector_infos`, the sectors AMT is walked once rather than each sector being
+    /// looked up, and sector numbers not in the state are skipped rather than an error.
+    pub fn load_sectors<BS: Blockstore>(
+        &self,
+        store: &BS,
+        sectors: Option<&BitField>,
+    ) -> anyhow::Result<Vec<SectorOnChainInfo>> {
+        let mut infos = Vec::new();
+        self.for_each_sector_while(store, |sector_number, info| {
+            if sectors.map_or(true, |s| s.get(sector_number)) {
+                infos.push(info.clone());
+            }
+            Ok(true)
+        })?;
+        Ok(infos)
+    }
+
     pub fn load_deadlines<BS: Blockstore>(&self, store: &BS) -> Result<Deadlines, ActorError> {
         store
             .get_cbor::<Deadlines>(&self.deadlines)
@@ -774,6 +1034,45 @@ impl State {
         Ok(())
     }
 
+    /// Loads a single partition, decoding only the requested deadline and the partitions AMT
+    /// nodes on the path to the partition.
+    pub fn load_partition<BS: Blockstore>(
+        &self,
+        policy: &Policy,
+        store: &BS,
+        deadline_idx: u64,
+        partition_idx: u64,
+    ) -> anyhow::Result<Partition> {
+        let deadlines = self.load_deadlines(store)?;
+        let deadline = deadlines.load_deadline(policy, store, deadline_idx)?;
+        deadline.load_partition(store, partition_idx)
+    }
+
+    /// Returns the epoch at which a sector is scheduled to expire: its (quantized) committed
+    /// expiration, or the earlier epoch at which it is terminated for being faulty too long.
+    /// Fails with a not found error if the miner has no such sector.
+    pub fn sector_expiration<BS: Blockstore>(
+        &self,
+        policy: &Policy,
+        store: &BS,
+        sector_number: SectorNumber,
+    ) -> anyhow::Result<ChainEpoch> {
+        if self.get_sector(store, sector_number)?.is_none() {
+            return Err(actor_error!(not_found; "sector {} not found", sector_number).into());
+        }
+        let (deadline_idx, partition_idx) = self.find_sector(policy, store, sector_number)?;
+        let partition = self.load_partition(policy, store, deadline_idx, partition_idx)?;
+        let expiration = partition.sector_expiration(store, sector_number)?;
+        expiration.ok_or_else(|| {
+            anyhow!(
+                "sector {} not scheduled in expiration queue of deadline {} partition {}",
+                sector_number,
+                deadline_idx,
+                partition_idx
+            )
+        })
+    }
+
     /// Loads the vesting funds table from the store.
     pub fn load_vesting_funds<BS: Blockstore>(&self, store: &BS) -> anyhow::Result<VestingFunds> {
         Ok(store
@@ -789,6 +1088,29 @@ impl State {
             )?)
     }
 
+    /// Returns the vesting table as `(epoch, amount)` pairs, sorted by the epoch at which each
+    /// amount unlocks.
+    pub fn vesting_funds<BS: Blockstore>(
+        &self,
+        store: &BS,
+    ) -> anyhow::Result<Vec<(ChainEpoch, TokenAmount)>> {
+        Ok(self
+            .load_vesting_funds(store)?
+            .funds
+            .into_iter()
+            .map(|fund| (fund.epoch, fund.amount))
+            .collect())
+    }
+
+    /// Returns the total of the vesting funds that remain locked at the given epoch.
+    pub fn locked_vesting_at<BS: Blockstore>(
+        &self,
+        store: &BS,
+        epoch: ChainEpoch,
+    ) -> anyhow::Result<TokenAmount> {
+        Ok(self.load_vesting_funds(store)?.locked_at(epoch))
+    }
+
     /// Saves the vesting table to the store.
     pub fn save_vesting_funds<BS: Blockstore>(
         &mut self,
@@ -1305,6 +1627,8 @@ pub struct MinerInfo {
     pub pending_beneficiary_term: Option<PendingBeneficiaryChange>,
 }
 
+impl Cbor for MinerInfo {}
+
 impl MinerInfo {
     pub fn new(
         owner: Address,
@@ -1340,3 +1664,29 @@ impl MinerInfo {
         })
     }
 }
+
+/// The addresses of a miner's owner, worker and control accounts.
+#[derive(Debug, PartialEq, Eq, Clone)]
+pub struct ControlAddresses {
+    pub owner: Address,
+    pub worker: Address,
+    pub control: Vec<Address>,
+    /// The worker key the miner is changing to, which becomes the worker once the change takes
+    /// effect.
+    pub pending_worker: Option<Address>,
+}
+
+/// A summary of a miner's state, as returned by [`State::export_summary`].
+#[derive(Debug, Default, PartialEq, Eq, Clone, Serialize_tuple, Deserialize_tuple)]
+pub struct MinerSummary {
+    /// Power of the miner's live sectors, including faulty ones.
+    pub live_power: PowerPair,
+    pub faulty_power: PowerPair,
+    pub initial_pledge: TokenAmount,
+    /// The number of non-terminated sectors, including faulty ones.
+    pub live_sectors: u64,
+    /// The number of faulty sectors, including those declared as recovering.
+    pub faulty_sectors: u64,
+    /// Indices of the deadlines with live sectors, in order.
+    pub active_deadlines: Vec<u64>,
+}
@@ -70,6 +70,31 @@ impl State {
         TokenAmount::from_atto(numerator.atto().div_ceil(&denominator))
     }
 
+    /// Returns the amount still locked by the vesting schedule at an epoch. The balance unlocks
+    /// linearly from `start_epoch` over `unlock_duration` epochs, with the locked amount rounded
+    /// up, and nothing is locked for a multisig without a schedule.
+    pub fn locked_balance(&self, at_epoch: ChainEpoch) -> TokenAmount {
+        self.amount_locked(at_epoch.saturating_sub(self.start_epoch))
+    }
+
+    /// Returns all pending transactions, keyed by transaction ID.
+    pub fn pending_txns<BS: Blockstore>(
+        &self,
+        store: &BS,
+    ) -> Result<Vec<(TxnID, Transaction)>, ActorError> {
+        let txns = make_map_with_root(&self.pending_txs, store)
+            .context_code(ExitCode::USR_ILLEGAL_STATE, "failed to load txn map")?;
+        let mut pending = Vec::new();
+        txns.for_each(|key, txn: &Transaction| {
+            let id = TxnID::from_key(key)
+                .ok_or_else(|| anyhow::anyhow!("failed to decode key: {:?}", key))?;
+            pending.push((id, txn.clone()));
+            Ok(())
+        })
+        .context_code(ExitCode::USR_ILLEGAL_STATE, "failed to scan txns")?;
+        Ok(pending)
+    }
+
     /// Iterates all pending transactions and removes an address from each list of approvals,
     /// if present.  If an approval list becomes empty, the pending transaction is deleted.
     pub fn purge_approvals<BS: Blockstore>(
@@ -114,3 +139,72 @@ impl State {
 }
 
 impl Cbor for State {}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn vesting(initial: u64, start_epoch: ChainEpoch, unlock_duration: ChainEpoch) -> State {
+        State {
+            signers: vec![Address::new_id(100)],
+            num_approvals_threshold: 1,
+            next_tx_id: TxnID(0),
+            initial_balance: TokenAmount::from_atto(initial),
+            start_epoch,
+            unlock_duration,
+            pending_txs: Cid::default(),
+        }
+    }
+
+    #[test]
+    fn locked_balance_vests_linearly() {
+        let st = vesting(1000, 100, 10);
+        assert_eq!(TokenAmount::from_atto(1000), st.locked_balance(0));
+        assert_eq!(TokenAmount::from_atto(1000), st.locked_balance(100));
+        assert_eq!(TokenAmount::from_atto(500), st.locked_balance(105));
+        assert_eq!(TokenAmount::from_atto(100), st.locked_balance(109));
+        assert!(st.locked_balance(110).is_zero());
+        assert!(st.locked_balance(1000).is_zero());
+
+        // 1000 * 2 / 3 rounds up.
+        let st = vesting(1000, 0, 3);
+        assert_eq!(TokenAmount::from_atto(667), st.locked_balance(1));
+        assert_eq!(TokenAmount::from_atto(334), st.locked_balance(2));
+    }
+
+    #[test]
+    fn locked_balance_without_vesting() {
+        let st = vesting(0, 0, 0);
+        assert!(st.locked_balance(0).is_zero());
+        assert!(st.locked_balance(1000).is_zero());
+    }
+
+    #[test]
+    fn amount_locked_rounding() {
+        // Go's State.AmountLocked computes the locked amount as the quotient of
+        // InitialBalance * (UnlockDuration - elapsed) by UnlockDuration, adding one for any
+        // remainder. Cases are (initial, duration, elapsed, locked).
+        let fil = 1_000_000_000_000_000_000u128;
+        let cases = [
+            (10 * fil, 7, 0, 10 * fil),
+            (10 * fil, 7, -1, 10 * fil),
+            (10 * fil, 7, 7, 0),
+            (10 * fil, 7, 3, 5_714_285_714_285_714_286),
+            (1, 1_000_000, 999_999, 1),
+            (1, 1_000_000, 1, 1),
+        ];
+        for (initial, duration, elapsed, locked) in cases {
+            let st = State {
+                initial_balance: TokenAmount::from_atto(initial),
+                ..vesting(0, 0, duration)
+            };
+            assert_eq!(
+                TokenAmount::from_atto(locked),
+                st.amount_locked(elapsed),
+                "elapsed {} of {}",
+                elapsed,
+                duration
+            );
+        }
+    }
+}
@@ -28,6 +28,15 @@ impl TxnID {
     pub fn key(self) -> BytesKey {
         self.0.encode_var_vec().into()
     }
+
+    /// Decodes a pending transactions HAMT key, the inverse of [`TxnID::key`]. Returns `None`
+    /// unless the whole key is a single varint.
+    pub fn from_key(key: &[u8]) -> Option<Self> {
+        match i64::decode_var(key) {
+            Some((id, read)) if read == key.len() => Some(TxnID(id)),
+            _ => None,
+        }
+    }
 }
 
 impl Display for TxnID {
@@ -163,3 +172,33 @@ pub struct LockBalanceParams {
     pub unlock_duration: ChainEpoch,
     pub amount: TokenAmount,
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn txn_id_key() {
+        // Signed (zigzag) varints, matching Go's abi.IntKey.
+        for (id, key) in [
+            (0, vec![0x00]),
+            (-1, vec![0x01]),
+            (1, vec![0x02]),
+            (63, vec![0x7e]),
+            (64, vec![0x80, 0x01]),
+            (300, vec![0xd8, 0x04]),
+        ] {
+            assert_eq!(BytesKey(key.clone()), TxnID(id).key());
+            assert_eq!(Some(TxnID(id)), TxnID::from_key(&key));
+        }
+    }
+
+    #[test]
+    fn txn_id_from_malformed_key() {
+        assert_eq!(None, TxnID::from_key(&[]));
+        // A truncated varint, and valid varints followed by more bytes.
+        assert_eq!(None, TxnID::from_key(&[0x80]));
+        assert_eq!(None, TxnID::from_key(&[0x02, 0x00]));
+        assert_eq!(None, TxnID::from_key(&[0xd8, 0x04, 0x01]));
+    }
+}
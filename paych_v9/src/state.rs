@@ -2,6 +2,8 @@
 // SPDX-License-Identifier: Apache-2.0, MIT
 
 use cid::Cid;
+use fil_actors_runtime_v9::Array;
+use fvm_ipld_blockstore::Blockstore;
 use fvm_ipld_encoding::tuple::*;
 use fvm_ipld_encoding::Cbor;
 use fvm_shared::address::Address;
@@ -39,6 +41,17 @@ impl State {
             lane_states: empty_arr_cid,
         }
     }
+
+    /// Returns the sum of the amounts redeemed across all lanes.
+    pub fn total_redeemed<BS: Blockstore>(&self, store: &BS) -> anyhow::Result<TokenAmount> {
+        let lanes = Array::<LaneState, _>::load(&self.lane_states, store)?;
+        let mut redeemed = TokenAmount::default();
+        lanes.for_each(|_, lane| {
+            redeemed += &lane.redeemed;
+            Ok(())
+        })?;
+        Ok(redeemed)
+    }
 }
 
 /// The Lane state tracks the latest (highest) voucher nonce used to merge the lane
@@ -59,3 +72,53 @@ pub struct Merge {
 impl Cbor for State {}
 impl Cbor for LaneState {}
 impl Cbor for Merge {}
+
+#[cfg(test)]
+mod tests {
+    use fvm_ipld_blockstore::MemoryBlockstore;
+
+    use super::*;
+    use crate::LANE_STATES_AMT_BITWIDTH;
+
+    fn state_with_lanes<V: serde::Serialize + serde::de::DeserializeOwned>(
+        store: &MemoryBlockstore,
+        lanes: Vec<(u64, V)>,
+    ) -> State {
+        let mut arr = Array::<V, _>::new_with_bit_width(store, LANE_STATES_AMT_BITWIDTH);
+        for (i, lane) in lanes {
+            arr.set(i, lane).unwrap();
+        }
+        State::new(
+            Address::new_id(100),
+            Address::new_id(101),
+            arr.flush().unwrap(),
+        )
+    }
+
+    #[test]
+    fn total_redeemed_sums_lanes() {
+        let store = MemoryBlockstore::new();
+        let lane = |redeemed, nonce| LaneState {
+            redeemed: TokenAmount::from_atto(redeemed),
+            nonce,
+        };
+        let st = state_with_lanes(
+            &store,
+            vec![(0, lane(10, 1)), (3, lane(0, 2)), (7, lane(32, 5))],
+        );
+        assert_eq!(
+            TokenAmount::from_atto(42),
+            st.total_redeemed(&store).unwrap()
+        );
+
+        let st = state_with_lanes::<LaneState>(&store, vec![]);
+        assert_eq!(TokenAmount::default(), st.total_redeemed(&store).unwrap());
+    }
+
+    #[test]
+    fn total_redeemed_fails_on_undecodable_lane() {
+        let store = MemoryBlockstore::new();
+        let st = state_with_lanes(&store, vec![(0, "not a lane".to_string())]);
+        assert!(st.total_redeemed(&store).is_err());
+    }
+}
@@ -1,6 +1,8 @@
 // Copyright 2019-2022 ChainSafe Systems
 // SPDX-License-Identifier: Apache-2.0, MIT
 
+use std::collections::BTreeMap;
+
 use anyhow::{anyhow, Context};
 use cid::Cid;
 use fil_actors_runtime_v8::runtime::Policy;
@@ -17,14 +19,17 @@ use fvm_shared::bigint::bigint_ser;
 use fvm_shared::clock::ChainEpoch;
 use fvm_shared::econ::TokenAmount;
 use fvm_shared::error::ExitCode;
-use fvm_shared::sector::{RegisteredPoStProof, StoragePower};
+use fvm_shared::sector::{RegisteredPoStProof, SealVerifyInfo, StoragePower};
 use fvm_shared::smooth::FilterEstimate;
 use fvm_shared::HAMT_BIT_WIDTH;
 use integer_encoding::VarInt;
 use lazy_static::lazy_static;
 use num_traits::Signed;
 
-use super::{CONSENSUS_MINER_MIN_MINERS, CRON_QUEUE_AMT_BITWIDTH, CRON_QUEUE_HAMT_BITWIDTH};
+use super::{
+    CONSENSUS_MINER_MIN_MINERS, CRON_QUEUE_AMT_BITWIDTH, CRON_QUEUE_HAMT_BITWIDTH,
+    PROOF_VALIDATION_BATCH_AMT_BITWIDTH,
+};
 
 lazy_static! {
     /// genesis power in bytes = 750,000 GiB
@@ -164,6 +169,121 @@ impl State {
         let claim = get_claim(&claims, miner)?;
         Ok(claim.cloned())
     }
+
+    /// Returns the miners whose raw byte power meets `min`, in the order of the claims map.
+    /// The consensus minimum depends on the network version and the miner's proof type, see
+    /// [`consensus_miner_min_power`], so it is left to the caller.
+    pub fn miners_meeting_min_power<BS: Blockstore>(
+        &self,
+        store: &BS,
+        min: &StoragePower,
+    ) -> anyhow::Result<Vec<Address>> {
+        let claims =
+            make_map_with_root_and_bitwidth::<_, Claim>(&self.claims, store, HAMT_BIT_WIDTH)
+                .map_err(|e| anyhow!("failed to load claims: {}", e))?;
+        let mut miners = Vec::new();
+        claims
+            .for_each(|key, claim| {
+                if &claim.raw_byte_power >= min {
+                    miners.push(Address::from_bytes(&key.0)?);
+                }
+                Ok(())
+            })
+            .map_err(|e| anyhow!("failed to iterate claims: {}", e))?;
+        Ok(miners)
+    }
+
+    /// Returns the cron events queued for an epoch, in the order they were enrolled. Empty if
+    /// there are none.
+    pub fn cron_events_at<BS: Blockstore>(
+        &self,
+        store: &BS,
+        epoch: ChainEpoch,
+    ) -> anyhow::Result<Vec<CronEvent>> {
+        let mut events = Vec::new();
+        self.load_cron_event_queue(store)?
+            .for_each::<_, CronEvent>(&epoch_key(epoch), |_, event| {
+                events.push(event.clone());
+                Ok(())
+            })
+            .map_err(|e| anyhow!("failed to load cron events at epoch {}: {}", epoch, e))?;
+        Ok(events)
+    }
+
+    /// Returns every queued cron event, by epoch.
+    pub fn cron_events<BS: Blockstore>(
+        &self,
+        store: &BS,
+    ) -> anyhow::Result<BTreeMap<ChainEpoch, Vec<CronEvent>>> {
+        let mut events = BTreeMap::new();
+        self.load_cron_event_queue(store)?
+            .for_all::<_, CronEvent>(|key, arr| {
+                let (epoch, _) = ChainEpoch::decode_var(&key.0)
+                    .ok_or_else(|| anyhow!("invalid cron event queue key {:?}", key.0))?;
+                let mut at_epoch = Vec::new();
+                arr.for_each(|_, event| {
+                    at_epoch.push(event.clone());
+                    Ok(())
+                })
+                .map_err(|e| anyhow!(e))?;
+                events.insert(epoch, at_epoch);
+                Ok(())
+            })
+            .map_err(|e| anyhow!("failed to load cron events: {}", e))?;
+        Ok(events)
+    }
+
+    /// Returns the number of seal proofs queued for batch verification at the end of the epoch,
+    /// across all miners. Zero when there is no batch.
+    pub fn proof_validation_batch_count<BS: Blockstore>(&self, store: &BS) -> anyhow::Result<u64> {
+        let mut count = 0;
+        self.for_each_queued_proof(store, |_, _| {
+            count += 1;
+            Ok(())
+        })?;
+        Ok(count)
+    }
+
+    /// Calls `f` with the miner and verification info of each seal proof queued for batch
+    /// verification, grouped by miner in the order of the batch HAMT, and in the order each
+    /// miner queued them.
+    pub fn for_each_queued_proof<BS, F>(&self, store: &BS, mut f: F) -> anyhow::Result<()>
+    where
+        BS: Blockstore,
+        F: FnMut(&Address, &SealVerifyInfo) -> anyhow::Result<()>,
+    {
+        let batch = match &self.proof_validation_batch {
+            Some(batch) => batch,
+            None => return Ok(()),
+        };
+        Multimap::from_root(
+            store,
+            batch,
+            HAMT_BIT_WIDTH,
+            PROOF_VALIDATION_BATCH_AMT_BITWIDTH,
+        )
+        .map_err(|e| anyhow!("failed to load proof validation batch: {}", e))?
+        .for_all::<_, SealVerifyInfo>(|key, infos| {
+            let miner = Address::from_bytes(&key.0)?;
+            infos
+                .for_each(|_, info| f(&miner, info))
+                .map_err(|e| anyhow!("failed to iterate proofs of {}: {}", miner, e))
+        })
+        .map_err(|e| anyhow!("failed to iterate proof validation batch: {}", e))
+    }
+
+    fn load_cron_event_queue<'a, BS: Blockstore>(
+        &self,
+        store: &'a BS,
+    ) -> anyhow::Result<Multimap<'a, BS>> {
+        Multimap::from_root(
+            store,
+            &self.cron_event_queue,
+            CRON_QUEUE_HAMT_BITWIDTH,
+            CRON_QUEUE_AMT_BITWIDTH,
+        )
+        .map_err(|e| anyhow!("failed to load cron event queue: {}", e))
+    }
 }
 
 /// Gets claim from claims map by address
@@ -172,7 +292,7 @@ fn get_claim<'m, BS: Blockstore>(
     a: &Address,
 ) -> anyhow::Result<Option<&'m Claim>> {
     claims
-        .get(&a.to_bytes())
+        .get(&claim_key(a))
         .map_err(|e| e.downcast_wrap(format!("failed to get claim for address {}", a)))
 }
 
@@ -197,11 +317,16 @@ pub fn set_claim<BS: Blockstore>(
     }
 
     claims
-        .set(a.to_bytes().into(), claim)
+        .set(claim_key(a), claim)
         .map_err(|e| e.downcast_wrap(format!("failed to set claim for address {}", a)))?;
     Ok(())
 }
 
+/// Key of a miner's entry in the claims map: the miner address bytes.
+pub fn claim_key(a: &Address) -> BytesKey {
+    a.to_bytes().into()
+}
+
 pub fn epoch_key(e: ChainEpoch) -> BytesKey {
     let bz = e.encode_var_vec();
     bz.into()
@@ -221,7 +346,7 @@ pub struct Claim {
     pub quality_adj_power: StoragePower,
 }
 
-#[derive(Clone, Debug, Serialize_tuple, Deserialize_tuple)]
+#[derive(Clone, Debug, PartialEq, Eq, Serialize_tuple, Deserialize_tuple)]
 pub struct CronEvent {
     pub miner_addr: Address,
     pub callback_payload: RawBytes,
@@ -273,4 +398,19 @@ mod test {
         assert_eq!(b3, epoch_key(e3));
         assert_eq!(b4, epoch_key(e4));
     }
+
+    #[test]
+    fn claim_key_test() {
+        let b1: BytesKey = [0x0, 0xe8, 0x7].to_vec().into();
+        let b2: BytesKey = [
+            0x0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x1,
+        ]
+        .to_vec()
+        .into();
+        let b3: BytesKey = [0x2; 21].to_vec().into();
+
+        assert_eq!(b1, claim_key(&Address::new_id(1000)));
+        assert_eq!(b2, claim_key(&Address::new_id(u64::MAX)));
+        assert_eq!(b3, claim_key(&Address::from_bytes(&[0x2; 21]).unwrap()));
+    }
 }
@@ -9,7 +9,7 @@ use fvm_shared::clock::{ChainEpoch, EPOCH_UNDEFINED};
 use fvm_shared::econ::TokenAmount;
 
 use fvm_shared::sector::{Spacetime, StoragePower};
-use fvm_shared::smooth::FilterEstimate;
+use fvm_shared::smooth::{AlphaBetaFilter, FilterEstimate, DEFAULT_ALPHA, DEFAULT_BETA};
 use lazy_static::lazy_static;
 use num_derive::FromPrimitive;
 
@@ -133,6 +133,49 @@ impl State {
         );
     }
 
+    /// Takes in the current realized power at a cron tick and catches up any null rounds
+    /// since the last update, leaving the state tracking the reward for the next epoch.
+    pub(super) fn update_network_kpi(
+        &mut self,
+        curr_epoch: ChainEpoch,
+        curr_realized_power: &StoragePower,
+    ) {
+        let prev = self.epoch;
+        while self.epoch < curr_epoch {
+            self.update_to_next_epoch(curr_realized_power);
+        }
+        self.update_to_next_epoch_with_reward(curr_realized_power);
+        self.update_smoothed_estimates(self.epoch - prev);
+    }
+
+    fn update_smoothed_estimates(&mut self, delta: ChainEpoch) {
+        let filter_reward = AlphaBetaFilter::load(
+            &self.this_epoch_reward_smoothed,
+            &DEFAULT_ALPHA,
+            &DEFAULT_BETA,
+        );
+        self.this_epoch_reward_smoothed =
+            filter_reward.next_estimate(self.this_epoch_reward.atto(), delta);
+    }
+
+    /// Smoothed estimate of the per-epoch block reward, as used for expected reward
+    /// (and so pledge) calculations.
+    pub fn epoch_reward_smoothed(&self) -> FilterEstimate {
+        self.this_epoch_reward_smoothed.clone()
+    }
+
+    /// Returns the reward and its smoothed estimate that the cron tick at `curr_epoch` records
+    /// for the following epoch, given the network's realized power, without modifying the state.
+    pub fn reward_for_cron_tick(
+        &self,
+        curr_epoch: ChainEpoch,
+        curr_realized_power: &StoragePower,
+    ) -> (TokenAmount, FilterEstimate) {
+        let mut st = self.clone();
+        st.update_network_kpi(curr_epoch, curr_realized_power);
+        (st.this_epoch_reward, st.this_epoch_reward_smoothed)
+    }
+
     pub fn into_total_storage_power_reward(self) -> TokenAmount {
         self.total_storage_power_reward
     }
@@ -140,6 +183,37 @@ impl State {
 
 impl Cbor for State {}
 
+#[cfg(test)]
+mod tests {
+    use num_traits::Zero;
+
+    use super::*;
+
+    // Expected values are the simple (zero network power) rewards from
+    // testdata/TestSimpleReward.golden.
+    #[test]
+    fn reward_for_cron_tick_with_zero_power() {
+        let st = State::new(StoragePower::zero());
+        assert_eq!(0, st.epoch);
+        assert_eq!(
+            TokenAmount::from_atto(36266264293777134739u128),
+            st.this_epoch_reward
+        );
+
+        let (reward, smoothed) = st.reward_for_cron_tick(4999, &StoragePower::zero());
+        assert_eq!(TokenAmount::from_atto(36246341860983438171u128), reward);
+        assert!(smoothed.position < st.epoch_reward_smoothed().position);
+
+        // Computing the reward leaves the state untouched.
+        assert_eq!(0, st.epoch);
+        let mut ticked = st.clone();
+        ticked.update_network_kpi(4999, &StoragePower::zero());
+        assert_eq!(5000, ticked.epoch);
+        assert_eq!(reward, ticked.this_epoch_reward);
+        assert_eq!(smoothed, ticked.epoch_reward_smoothed());
+    }
+}
+
 /// Defines vestion function type for reward actor.
 #[derive(Clone, Debug, PartialEq, Eq, Copy, FromPrimitive, Serialize_repr, Deserialize_repr)]
 #[repr(u8)]
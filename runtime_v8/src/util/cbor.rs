@@ -1,4 +1,4 @@
-use fvm_ipld_encoding::{to_vec, RawBytes};
+use fvm_ipld_encoding::{from_slice, to_vec, RawBytes};
 use serde::{de, ser};
 
 use crate::ActorError;
@@ -29,6 +29,176 @@ pub fn deserialize<O: de::DeserializeOwned>(v: &RawBytes, desc: &str) -> Result<
         .map_err(|e| ActorError::serialization(format!("failed to deserialize {}: {}", desc, e)))
 }
 
+/// Deserialises CBOR-encoded bytes as a structure, additionally rejecting any input that is not
+/// the canonical encoding of the decoded value (e.g. non-minimal integer or length prefixes, or
+/// duplicate map keys), matching the decoders generated by cbor-gen for go-state-types.
+/// `desc` is a noun phrase for the object being deserialized, included in any error message.
+pub fn deserialize_strict<O>(v: &[u8], desc: &str) -> Result<O, ActorError>
+where
+    O: de::DeserializeOwned + ser::Serialize,
+{
+    let value: O = from_slice(v)
+        .map_err(|e| ActorError::serialization(format!("failed to deserialize {}: {}", desc, e)))?;
+    if serialize_vec(&value, desc)? != v {
+        return Err(ActorError::serialization(format!(
+            "failed to deserialize {}: non-canonical encoding",
+            desc
+        )));
+    }
+    Ok(value)
+}
+
+/// Deserialises the CBOR-encoded structure at the start of `v`, which may be followed by other
+/// data, returning it with the number of bytes it was encoded in.
+/// `desc` is a noun phrase for the object being deserialized, included in any error message.
+pub fn deserialize_prefix<O: de::DeserializeOwned>(
+    v: &[u8],
+    desc: &str,
+) -> Result<(O, usize), ActorError> {
+    let len = item_len(v)
+        .map_err(|e| ActorError::serialization(format!("failed to deserialize {}: {}", desc, e)))?;
+    let value = from_slice(&v[..len])
+        .map_err(|e| ActorError::serialization(format!("failed to deserialize {}: {}", desc, e)))?;
+    Ok((value, len))
+}
+
+/// Deserialises the CBOR-encoded structure at the start of `cursor`, and advances the cursor
+/// past it. On failure the cursor is left unchanged.
+/// `desc` is a noun phrase for the object being deserialized, included in any error message.
+pub fn deserialize_next<O: de::DeserializeOwned>(
+    cursor: &mut &[u8],
+    desc: &str,
+) -> Result<O, ActorError> {
+    let (value, len) = deserialize_prefix(cursor, desc)?;
+    *cursor = &cursor[len..];
+    Ok(value)
+}
+
+/// Limits on the structure of untrusted CBOR input, checked before it is decoded so that a
+/// malformed input cannot cause deep recursion or large allocations in the decoder.
+#[derive(Clone, Copy, Debug, PartialEq, Eq)]
+pub struct DecodeLimits {
+    /// Maximum number of nested arrays and maps.
+    pub max_depth: usize,
+    /// Maximum number of elements of an array, or of entries of a map.
+    pub max_length: u64,
+}
+
+impl DecodeLimits {
+    pub const UNLIMITED: Self = Self {
+        max_depth: usize::MAX,
+        max_length: u64::MAX,
+    };
+}
+
+impl Default for DecodeLimits {
+    /// Limits well above those of any actor method parameters.
+    fn default() -> Self {
+        Self {
+            max_depth: 64,
+            max_length: 1 << 20,
+        }
+    }
+}
+
+/// Deserialises CBOR-encoded bytes as a structure, first rejecting input whose arrays and maps
+/// exceed `limits`, or that declares more items than it has bytes for.
+/// `desc` is a noun phrase for the object being deserialized, included in any error message.
+pub fn deserialize_limited<O: de::DeserializeOwned>(
+    v: &[u8],
+    limits: &DecodeLimits,
+    desc: &str,
+) -> Result<O, ActorError> {
+    scan_item(v, limits)
+        .map_err(|e| ActorError::serialization(format!("failed to deserialize {}: {}", desc, e)))?;
+    from_slice(v)
+        .map_err(|e| ActorError::serialization(format!("failed to deserialize {}: {}", desc, e)))
+}
+
+/// Returns the length in bytes of the CBOR data item at the start of `v`, without decoding it.
+/// Indefinite-length items, which DAG-CBOR does not allow, are rejected.
+fn item_len(v: &[u8]) -> Result<usize, String> {
+    scan_item(v, &DecodeLimits::UNLIMITED)
+}
+
+/// Returns the length in bytes of the CBOR data item at the start of `v`, checking its arrays and
+/// maps against `limits`. The scan is iterative, so it does not recurse however deep the input.
+fn scan_item(v: &[u8], limits: &DecodeLimits) -> Result<usize, String> {
+    const EOF: &str = "unexpected end of input";
+    let mut offset = 0;
+    // Number of items still to be read in each enclosing array or map, innermost last.
+    let mut open: Vec<u64> = Vec::new();
+    loop {
+        let initial = *v.get(offset).ok_or(EOF)?;
+        offset += 1;
+        let (major, info) = (initial >> 5, initial & 0x1f);
+        let arg = match info {
+            0..=23 => info as u64,
+            24..=27 => {
+                let n = 1 << (info - 24);
+                let bytes = v.get(offset..offset + n).ok_or(EOF)?;
+                offset += n;
+                bytes.iter().fold(0, |acc, b| acc << 8 | *b as u64)
+            }
+            _ => return Err(format!("unsupported additional information {}", info)),
+        };
+        match major {
+            // Integers, simple values and floats carry no content after their argument.
+            0 | 1 | 7 => {}
+            // Byte and text strings.
+            2 | 3 => {
+                offset = usize::try_from(arg)
+                    .ok()
+                    .and_then(|n| offset.checked_add(n))
+                    .filter(|end| *end <= v.len())
+                    .ok_or(EOF)?;
+            }
+            4 | 5 => {
+                if open.len() >= limits.max_depth {
+                    return Err(format!(
+                        "nesting exceeds the maximum depth of {}",
+                        limits.max_depth
+                    ));
+                }
+                if arg > limits.max_length {
+                    return Err(format!(
+                        "length {} exceeds the maximum of {}",
+                        arg, limits.max_length
+                    ));
+                }
+                let items = if major == 4 {
+                    arg
+                } else {
+                    arg.saturating_mul(2)
+                };
+                // Every item takes at least one byte.
+                if items > (v.len() - offset) as u64 {
+                    return Err(EOF.to_string());
+                }
+                if items > 0 {
+                    open.push(items);
+                    continue;
+                }
+            }
+            // A tag is followed by the tagged item, which takes its place.
+            _ => continue,
+        }
+        // The item is complete, as is any enclosing array or map it was the last item of.
+        loop {
+            match open.last_mut() {
+                None => return Ok(offset),
+                Some(pending) => {
+                    *pending -= 1;
+                    if *pending > 0 {
+                        break;
+                    }
+                    open.pop();
+                }
+            }
+        }
+    }
+}
+
 /// Deserialises CBOR-encoded bytes as a method parameters object.
 pub fn deserialize_params<O: de::DeserializeOwned>(params: &RawBytes) -> Result<O, ActorError> {
     deserialize(params, "method parameters")
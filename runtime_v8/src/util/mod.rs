@@ -6,6 +6,11 @@ pub use self::message_accumulator::MessageAccumulator;
 pub use self::multimap::*;
 pub use self::set::Set;
 pub use self::set_multimap::SetMultimap;
+pub use self::tracking::TrackingBlockstore;
+// The AMT and HAMT formats are the same in actors v8 and v9.
+pub use fil_actors_runtime_v9::{
+    load_array_validated, load_map_validated, validate_amt, validate_hamt,
+};
 
 pub mod cbor;
 mod downcast;
@@ -13,3 +18,4 @@ mod message_accumulator;
 mod multimap;
 mod set;
 mod set_multimap;
+mod tracking;
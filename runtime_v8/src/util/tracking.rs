@@ -0,0 +1,48 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::cell::RefCell;
+
+use cid::Cid;
+use fvm_ipld_blockstore::{Blockstore, MemoryBlockstore};
+
+/// A blockstore that records the blocks read through it, to check how much of a state an
+/// operation loads.
+#[derive(Default)]
+pub struct TrackingBlockstore<BS = MemoryBlockstore> {
+    inner: BS,
+    reads: RefCell<Vec<Cid>>,
+}
+
+impl<BS> TrackingBlockstore<BS> {
+    pub fn new(inner: BS) -> Self {
+        Self {
+            inner,
+            reads: Default::default(),
+        }
+    }
+
+    /// The CIDs of the blocks read since the store was created or last cleared, in read order.
+    pub fn reads(&self) -> Vec<Cid> {
+        self.reads.borrow().clone()
+    }
+
+    pub fn read_count(&self) -> usize {
+        self.reads.borrow().len()
+    }
+
+    pub fn clear_reads(&self) {
+        self.reads.borrow_mut().clear();
+    }
+}
+
+impl<BS: Blockstore> Blockstore for TrackingBlockstore<BS> {
+    fn get(&self, k: &Cid) -> anyhow::Result<Option<Vec<u8>>> {
+        self.reads.borrow_mut().push(*k);
+        self.inner.get(k)
+    }
+
+    fn put_keyed(&self, k: &Cid, block: &[u8]) -> anyhow::Result<()> {
+        self.inner.put_keyed(k, block)
+    }
+}
@@ -0,0 +1,31 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use fil_actors_runtime_v8::cbor::{deserialize_next, deserialize_prefix};
+use fvm_shared::error::ExitCode;
+
+#[test]
+fn prefix_reports_consumed_length() {
+    // [1, "a"] followed by two unrelated bytes.
+    let input = hex::decode("82016161ff00").unwrap();
+    let (v, len): ((u64, String), _) = deserialize_prefix(&input, "t").unwrap();
+    assert_eq!((1, "a".to_string()), v);
+    assert_eq!(4, len);
+    assert_eq!(&[0xff, 0x00], &input[len..]);
+
+    // Two items read back to back, with the cursor left at the end of the input.
+    let input = hex::decode("8201616107").unwrap();
+    let mut cursor = &input[..];
+    let _: (u64, String) = deserialize_next(&mut cursor, "t").unwrap();
+    assert_eq!(7u64, deserialize_next::<u64>(&mut cursor, "t").unwrap());
+    assert!(cursor.is_empty());
+}
+
+#[test]
+fn prefix_rejects_truncated_input() {
+    let bytes = hex::decode("820161").unwrap();
+    let mut cursor = &bytes[..];
+    let err = deserialize_next::<(u64, String)>(&mut cursor, "t").unwrap_err();
+    assert_eq!(ExitCode::USR_SERIALIZATION, err.exit_code());
+    assert_eq!(&bytes[..], cursor);
+}
@@ -0,0 +1,44 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use cid::multihash::Code;
+use cid::Cid;
+use fil_actors_runtime_v8::{
+    load_array_validated, load_map_validated, make_empty_map, validate_amt, Array,
+};
+use fvm_ipld_blockstore::MemoryBlockstore;
+use fvm_ipld_encoding::{BytesSer, CborStore};
+use fvm_ipld_hamt::BytesKey;
+
+#[test]
+fn v8_collections_validate() {
+    let store = MemoryBlockstore::default();
+    let mut arr = Array::<u64, _>::new_with_bit_width(&store, 3);
+    for i in 0..100 {
+        arr.set(i, i).unwrap();
+    }
+    let root = arr.flush().unwrap();
+    let arr = load_array_validated::<_, u64>(&root, &store).unwrap();
+    assert_eq!(100, arr.count());
+
+    let mut map = make_empty_map::<_, u64>(&store, 5);
+    for i in 0..100 {
+        map.set(BytesKey(format!("key-{}", i).into_bytes()), i)
+            .unwrap();
+    }
+    let root = map.flush().unwrap();
+    let map = load_map_validated::<_, u64>(&root, &store, 5).unwrap();
+    assert_eq!(Some(&3), map.get(&BytesKey(b"key-3".to_vec())).unwrap());
+}
+
+#[test]
+fn v8_malformed_amt_rejected() {
+    let store = MemoryBlockstore::default();
+    // A root claiming more values than its leaf holds.
+    let node = (BytesSer(&[0b11]), Vec::<Cid>::new(), vec![1u64, 2]);
+    let root = store
+        .put_cbor(&(3u32, 0u32, 5u64, node), Code::Blake2b256)
+        .unwrap();
+    assert!(validate_amt(&store, &root).is_err());
+    assert!(load_array_validated::<_, u64>(&root, &store).is_err());
+}
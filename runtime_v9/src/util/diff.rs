@@ -0,0 +1,406 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::collections::BTreeMap;
+
+use anyhow::anyhow;
+use cid::Cid;
+use fvm_ipld_blockstore::Blockstore;
+use fvm_ipld_encoding::{from_slice, to_vec, BytesDe, CborStore};
+use fvm_ipld_hamt::BytesKey;
+use libipld_core::ipld::Ipld;
+use serde::de::DeserializeOwned;
+
+/// A change to a single entry between two versions of a collection.
+#[derive(Clone, Debug, PartialEq, Eq)]
+pub enum Change<K, V> {
+    Added(K, V),
+    Removed(K, V),
+    Modified(K, V, V),
+}
+
+/// An AMT node as encoded in the store: a bitmap of the occupied slots, and the links (for
+/// internal nodes) or values (for leaves) of those slots in order.
+struct AmtNode<V> {
+    bmap: Vec<u8>,
+    links: Vec<Cid>,
+    values: Vec<V>,
+}
+
+impl<V> AmtNode<V> {
+    fn has(&self, i: u64) -> bool {
+        self.bmap
+            .get((i / 8) as usize)
+            .map_or(false, |b| b & (1 << (i % 8)) != 0)
+    }
+
+    /// The position of slot `i` among the occupied slots.
+    fn position(&self, i: u64) -> usize {
+        (0..i).filter(|&j| self.has(j)).count()
+    }
+
+    fn link(&self, i: u64) -> Option<&Cid> {
+        if !self.has(i) {
+            return None;
+        }
+        self.links.get(self.position(i))
+    }
+
+    fn value(&self, i: u64) -> Option<&V> {
+        if !self.has(i) {
+            return None;
+        }
+        self.values.get(self.position(i))
+    }
+}
+
+type EncodedAmtNode<V> = (BytesDe, Vec<Cid>, Vec<V>);
+
+fn load_amt_root<BS, V>(store: &BS, root: &Cid) -> anyhow::Result<(u32, u32, AmtNode<V>)>
+where
+    BS: Blockstore,
+    V: DeserializeOwned,
+{
+    let (bit_width, height, _count, (BytesDe(bmap), links, values)): (
+        u32,
+        u32,
+        u64,
+        EncodedAmtNode<V>,
+    ) = store
+        .get_cbor(root)?
+        .ok_or_else(|| anyhow!("amt root {} not found", root))?;
+    Ok((
+        bit_width,
+        height,
+        AmtNode {
+            bmap,
+            links,
+            values,
+        },
+    ))
+}
+
+fn load_amt_node<BS, V>(store: &BS, cid: &Cid) -> anyhow::Result<AmtNode<V>>
+where
+    BS: Blockstore,
+    V: DeserializeOwned,
+{
+    let (BytesDe(bmap), links, values): EncodedAmtNode<V> = store
+        .get_cbor(cid)?
+        .ok_or_else(|| anyhow!("amt node {} not found", cid))?;
+    Ok(AmtNode {
+        bmap,
+        links,
+        values,
+    })
+}
+
+/// Returns the entries added, removed or modified from the AMT at `old` to the AMT at `new`, in
+/// index order.
+///
+/// The trees are walked side by side and subtrees with the same CID in both are skipped without
+/// being loaded, so only the blocks on the paths to changed entries are read.
+pub fn amt_diff<BS, V>(store: &BS, old: &Cid, new: &Cid) -> anyhow::Result<Vec<Change<u64, V>>>
+where
+    BS: Blockstore,
+    V: DeserializeOwned + Clone + PartialEq,
+{
+    if old == new {
+        return Ok(Vec::new());
+    }
+    let (old_bit_width, old_height, old_node) = load_amt_root(store, old)?;
+    let (new_bit_width, new_height, new_node) = load_amt_root(store, new)?;
+    if old_bit_width != new_bit_width {
+        return Err(anyhow!(
+            "cannot diff AMTs of bit width {} and {}",
+            old_bit_width,
+            new_bit_width
+        ));
+    }
+    let mut diff = AmtDiff {
+        store,
+        width: 1 << old_bit_width,
+        changes: Vec::new(),
+    };
+    diff.diff(&old_node, old_height, &new_node, new_height, 0)?;
+    Ok(diff.changes)
+}
+
+struct AmtDiff<'a, BS, V> {
+    store: &'a BS,
+    width: u64,
+    changes: Vec<Change<u64, V>>,
+}
+
+impl<'a, BS, V> AmtDiff<'a, BS, V>
+where
+    BS: Blockstore,
+    V: DeserializeOwned + Clone + PartialEq,
+{
+    /// The number of indices covered by each slot of a node at `height`.
+    fn span(&self, height: u32) -> u64 {
+        self.width.saturating_pow(height)
+    }
+
+    /// Records every value under `node` as added (or removed).
+    fn walk(
+        &mut self,
+        node: &AmtNode<V>,
+        height: u32,
+        offset: u64,
+        added: bool,
+    ) -> anyhow::Result<()> {
+        for i in 0..self.width {
+            let idx = offset + i * self.span(height);
+            if height == 0 {
+                if let Some(v) = node.value(i) {
+                    self.changes.push(if added {
+                        Change::Added(idx, v.clone())
+                    } else {
+                        Change::Removed(idx, v.clone())
+                    });
+                }
+            } else if let Some(cid) = node.link(i) {
+                let child = load_amt_node(self.store, cid)?;
+                self.walk(&child, height - 1, idx, added)?;
+            }
+        }
+        Ok(())
+    }
+
+    fn diff(
+        &mut self,
+        old: &AmtNode<V>,
+        old_height: u32,
+        new: &AmtNode<V>,
+        new_height: u32,
+        offset: u64,
+    ) -> anyhow::Result<()> {
+        // An AMT grows by adding levels above its root, so the shorter tree's entries all fall
+        // under the first slot of the taller tree.
+        if old_height > new_height {
+            match old.link(0) {
+                Some(cid) => {
+                    let child = load_amt_node(self.store, cid)?;
+                    self.diff(&child, old_height - 1, new, new_height, offset)?;
+                }
+                None => self.walk(new, new_height, offset, true)?,
+            }
+            for i in 1..self.width {
+                if let Some(cid) = old.link(i) {
+                    let child = load_amt_node(self.store, cid)?;
+                    self.walk(
+                        &child,
+                        old_height - 1,
+                        offset + i * self.span(old_height),
+                        false,
+                    )?;
+                }
+            }
+            return Ok(());
+        }
+        if new_height > old_height {
+            match new.link(0) {
+                Some(cid) => {
+                    let child = load_amt_node(self.store, cid)?;
+                    self.diff(old, old_height, &child, new_height - 1, offset)?;
+                }
+                None => self.walk(old, old_height, offset, false)?,
+            }
+            for i in 1..self.width {
+                if let Some(cid) = new.link(i) {
+                    let child = load_amt_node(self.store, cid)?;
+                    self.walk(
+                        &child,
+                        new_height - 1,
+                        offset + i * self.span(new_height),
+                        true,
+                    )?;
+                }
+            }
+            return Ok(());
+        }
+
+        let height = old_height;
+        for i in 0..self.width {
+            let idx = offset + i * self.span(height);
+            if height == 0 {
+                match (old.value(i), new.value(i)) {
+                    (Some(o), Some(n)) if o != n => {
+                        self.changes
+                            .push(Change::Modified(idx, o.clone(), n.clone()))
+                    }
+                    (Some(o), None) => self.changes.push(Change::Removed(idx, o.clone())),
+                    (None, Some(n)) => self.changes.push(Change::Added(idx, n.clone())),
+                    _ => {}
+                }
+                continue;
+            }
+            match (old.link(i), new.link(i)) {
+                (Some(o), Some(n)) if o == n => {}
+                (Some(o), Some(n)) => {
+                    let old_child = load_amt_node(self.store, o)?;
+                    let new_child = load_amt_node(self.store, n)?;
+                    self.diff(&old_child, height - 1, &new_child, height - 1, idx)?;
+                }
+                (Some(o), None) => {
+                    let child = load_amt_node(self.store, o)?;
+                    self.walk(&child, height - 1, idx, false)?;
+                }
+                (None, Some(n)) => {
+                    let child = load_amt_node(self.store, n)?;
+                    self.walk(&child, height - 1, idx, true)?;
+                }
+                (None, None) => {}
+            }
+        }
+        Ok(())
+    }
+}
+
+/// A HAMT node as encoded in the store: a bitmap of the occupied slots, as a big-endian integer
+/// with leading zero bytes dropped, and a pointer for each occupied slot in order.
+struct HamtNode {
+    bitfield: Vec<u8>,
+    pointers: Vec<Ipld>,
+}
+
+impl HamtNode {
+    fn load<BS: Blockstore>(store: &BS, cid: &Cid) -> anyhow::Result<Self> {
+        let (BytesDe(bitfield), pointers): (BytesDe, Vec<Ipld>) = store
+            .get_cbor(cid)?
+            .ok_or_else(|| anyhow!("hamt node {} not found", cid))?;
+        Ok(Self { bitfield, pointers })
+    }
+
+    fn slots(&self) -> u64 {
+        self.bitfield.len() as u64 * 8
+    }
+
+    fn has(&self, i: u64) -> bool {
+        let len = self.bitfield.len() as u64;
+        i < len * 8 && self.bitfield[(len - 1 - i / 8) as usize] & (1 << (i % 8)) != 0
+    }
+
+    /// The pointer of slot `i`: either a link to a child node, or a bucket of key-value pairs.
+    fn pointer(&self, i: u64) -> Option<&Ipld> {
+        if !self.has(i) {
+            return None;
+        }
+        let position = (0..i).filter(|&j| self.has(j)).count();
+        self.pointers.get(position)
+    }
+}
+
+/// Returns the entries added, removed or modified from the HAMT at `old` to the HAMT at `new`.
+/// Changes are ordered by key hash, as the HAMT itself.
+///
+/// The trees are walked side by side and subtrees with the same CID in both are skipped without
+/// being loaded, so only the blocks on the paths to changed entries are read. Both maps must use
+/// the same hash function, as all maps of the builtin actors do.
+pub fn hamt_diff<BS, V>(
+    store: &BS,
+    old: &Cid,
+    new: &Cid,
+) -> anyhow::Result<Vec<Change<BytesKey, V>>>
+where
+    BS: Blockstore,
+    V: DeserializeOwned,
+{
+    let mut changes = Vec::new();
+    if old != new {
+        let old = HamtNode::load(store, old)?;
+        let new = HamtNode::load(store, new)?;
+        diff_hamt_nodes(store, &old, &new, &mut changes)?;
+    }
+    Ok(changes)
+}
+
+fn diff_hamt_nodes<BS, V>(
+    store: &BS,
+    old: &HamtNode,
+    new: &HamtNode,
+    changes: &mut Vec<Change<BytesKey, V>>,
+) -> anyhow::Result<()>
+where
+    BS: Blockstore,
+    V: DeserializeOwned,
+{
+    for i in 0..old.slots().max(new.slots()) {
+        match (old.pointer(i), new.pointer(i)) {
+            (None, None) => {}
+            (Some(Ipld::Link(o)), Some(Ipld::Link(n))) if o == n => {}
+            (Some(Ipld::Link(o)), Some(Ipld::Link(n))) => {
+                let old_child = HamtNode::load(store, o)?;
+                let new_child = HamtNode::load(store, n)?;
+                diff_hamt_nodes(store, &old_child, &new_child, changes)?;
+            }
+            // A bucket on either side, or a slot occupied on one side only: compare the entries
+            // under each slot. Buckets hold at most a few entries, and a bucket is only replaced
+            // by a link (or the reverse) when entries are added or removed there.
+            (o, n) => {
+                let mut old_entries = BTreeMap::new();
+                if let Some(pointer) = o {
+                    collect_hamt_entries(store, pointer, &mut old_entries)?;
+                }
+                let mut new_entries = BTreeMap::new();
+                if let Some(pointer) = n {
+                    collect_hamt_entries(store, pointer, &mut new_entries)?;
+                }
+                for (key, value) in &old_entries {
+                    match new_entries.get(key) {
+                        None => changes
+                            .push(Change::Removed(BytesKey(key.clone()), decode_ipld(value)?)),
+                        Some(new_value) if new_value != value => changes.push(Change::Modified(
+                            BytesKey(key.clone()),
+                            decode_ipld(value)?,
+                            decode_ipld(new_value)?,
+                        )),
+                        Some(_) => {}
+                    }
+                }
+                for (key, value) in new_entries {
+                    if !old_entries.contains_key(&key) {
+                        changes.push(Change::Added(BytesKey(key), decode_ipld(&value)?));
+                    }
+                }
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Collects every key-value pair under a HAMT pointer.
+fn collect_hamt_entries<BS: Blockstore>(
+    store: &BS,
+    pointer: &Ipld,
+    entries: &mut BTreeMap<Vec<u8>, Ipld>,
+) -> anyhow::Result<()> {
+    match pointer {
+        Ipld::Link(cid) => {
+            let node = HamtNode::load(store, cid)?;
+            for pointer in &node.pointers {
+                collect_hamt_entries(store, pointer, entries)?;
+            }
+        }
+        Ipld::List(bucket) => {
+            for entry in bucket {
+                match entry {
+                    Ipld::List(kv) => match kv.as_slice() {
+                        [Ipld::Bytes(key), value] => {
+                            entries.insert(key.clone(), value.clone());
+                        }
+                        _ => return Err(anyhow!("malformed hamt bucket entry")),
+                    },
+                    _ => return Err(anyhow!("malformed hamt bucket entry")),
+                }
+            }
+        }
+        _ => return Err(anyhow!("malformed hamt pointer")),
+    }
+    Ok(())
+}
+
+fn decode_ipld<V: DeserializeOwned>(value: &Ipld) -> anyhow::Result<V> {
+    Ok(from_slice(&to_vec(value)?)?)
+}
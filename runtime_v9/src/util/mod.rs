@@ -4,18 +4,24 @@
 pub use self::batch_return::BatchReturn;
 pub use self::batch_return::BatchReturnGen;
 pub use self::batch_return::FailCode;
+pub use self::diff::{amt_diff, hamt_diff, Change};
 pub use self::downcast::*;
 pub use self::mapmap::MapMap;
 pub use self::message_accumulator::MessageAccumulator;
 pub use self::multimap::*;
 pub use self::set::Set;
 pub use self::set_multimap::SetMultimap;
+pub use self::tracking::TrackingBlockstore;
+pub use self::validate::{load_array_validated, load_map_validated, validate_amt, validate_hamt};
 
 mod batch_return;
 pub mod cbor;
+mod diff;
 mod downcast;
 mod mapmap;
 mod message_accumulator;
 mod multimap;
 mod set;
 mod set_multimap;
+mod tracking;
+mod validate;
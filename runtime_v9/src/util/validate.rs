@@ -0,0 +1,289 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use anyhow::anyhow;
+use cid::Cid;
+use fvm_ipld_blockstore::Blockstore;
+use fvm_ipld_encoding::{BytesDe, CborStore};
+use libipld_core::ipld::Ipld;
+use serde::de::DeserializeOwned;
+use serde::Serialize;
+use sha2::{Digest, Sha256};
+
+use crate::{make_map_with_root_and_bitwidth, Array, Map};
+
+/// The widest AMT node accepted, which takes a 32 KiB bitmap.
+const MAX_AMT_BIT_WIDTH: u32 = 18;
+
+/// The widest HAMT node accepted, indexed by a byte of the key hash.
+const MAX_HAMT_BIT_WIDTH: u32 = 8;
+
+/// Maximum number of entries in a HAMT bucket, before it is split into a child node.
+const MAX_BUCKET_SIZE: usize = 3;
+
+type AmtNode = (BytesDe, Vec<Cid>, Vec<Ipld>);
+
+/// Checks every node of the AMT at `root` against the invariants of the AMT format, rather than
+/// trusting the blocks to have been written by a correct implementation:
+/// - each node's bitmap covers exactly its slots, and its links or values match the bitmap
+/// - leaves hold only values and internal nodes only links, and no node below the root is empty
+/// - the root is no higher than needed to hold its entries, and its count is the number of
+///   values in the tree
+///
+/// All nodes are loaded, so this is meant for data from an untrusted source, such as a snapshot
+/// being imported, before it is relied upon.
+pub fn validate_amt<BS: Blockstore>(store: &BS, root: &Cid) -> anyhow::Result<()> {
+    let (bit_width, height, count, node): (u32, u32, u64, AmtNode) = store
+        .get_cbor(root)?
+        .ok_or_else(|| anyhow!("amt root {} not found", root))?;
+    if bit_width == 0 || bit_width > MAX_AMT_BIT_WIDTH {
+        return Err(anyhow!("invalid amt bit width {}", bit_width));
+    }
+    // Every index in the tree must fit in a u64.
+    if (height as u64 + 1) * bit_width as u64 > 64 {
+        return Err(anyhow!(
+            "amt height {} is too large for bit width {}",
+            height,
+            bit_width
+        ));
+    }
+    // A level is only added above the root when an entry falls outside it, and removed once all
+    // entries fall under its first slot again.
+    let BytesDe(bmap) = &node.0;
+    if height > 0
+        && bmap.iter().enumerate().all(|(i, b)| match i {
+            0 => b & !1 == 0,
+            _ => *b == 0,
+        })
+    {
+        return Err(anyhow!(
+            "amt root of height {} has no entries beyond its first slot",
+            height
+        ));
+    }
+    let values = validate_amt_node(store, &node, bit_width, height, true)?;
+    if values != count {
+        return Err(anyhow!(
+            "amt root records {} values, but the tree holds {}",
+            count,
+            values
+        ));
+    }
+    Ok(())
+}
+
+/// Checks an AMT node and the nodes below it, returning the number of values they hold.
+fn validate_amt_node<BS: Blockstore>(
+    store: &BS,
+    node: &AmtNode,
+    bit_width: u32,
+    height: u32,
+    root: bool,
+) -> anyhow::Result<u64> {
+    let (BytesDe(bmap), links, values) = node;
+    let width = 1_usize << bit_width;
+    if bmap.len() != (width + 7) / 8 {
+        return Err(anyhow!(
+            "amt node bitmap of {} bytes, expected {}",
+            bmap.len(),
+            (width + 7) / 8
+        ));
+    }
+    if width < 8 && bmap[0] >> width != 0 {
+        return Err(anyhow!(
+            "amt node bitmap has bits beyond its {} slots",
+            width
+        ));
+    }
+    let occupied: usize = bmap.iter().map(|b| b.count_ones() as usize).sum();
+    if !root && occupied == 0 {
+        return Err(anyhow!("empty amt node below the root"));
+    }
+    if height == 0 {
+        if !links.is_empty() || values.len() != occupied {
+            return Err(anyhow!(
+                "amt leaf has {} links and {} values for {} occupied slots",
+                links.len(),
+                values.len(),
+                occupied
+            ));
+        }
+        return Ok(values.len() as u64);
+    }
+    if !values.is_empty() || links.len() != occupied {
+        return Err(anyhow!(
+            "amt node at height {} has {} links and {} values for {} occupied slots",
+            height,
+            links.len(),
+            values.len(),
+            occupied
+        ));
+    }
+    let mut total = 0;
+    for cid in links {
+        let child: AmtNode = store
+            .get_cbor(cid)?
+            .ok_or_else(|| anyhow!("amt node {} not found", cid))?;
+        total += validate_amt_node(store, &child, bit_width, height - 1, false)?;
+    }
+    Ok(total)
+}
+
+/// Checks every node of the HAMT at `root` against the invariants of the HAMT format, rather than
+/// trusting the blocks to have been written by a correct implementation:
+/// - each node's bitmap is minimally encoded, covers only its slots, and matches its pointers
+/// - buckets are non-empty, no larger than the maximum, and sorted by key
+/// - every key is in the slot its hash selects at each level
+/// - no node below the root holds few enough entries to have been collapsed into a bucket
+///
+/// All nodes are loaded, so this is meant for data from an untrusted source, such as a snapshot
+/// being imported, before it is relied upon. The hash is SHA-256, as for all builtin actor maps.
+pub fn validate_hamt<BS: Blockstore>(store: &BS, root: &Cid, bit_width: u32) -> anyhow::Result<()> {
+    if bit_width == 0 || bit_width > MAX_HAMT_BIT_WIDTH {
+        return Err(anyhow!("invalid hamt bit width {}", bit_width));
+    }
+    validate_hamt_node(store, root, bit_width, &mut Vec::new())
+}
+
+/// Checks the HAMT node `cid`, reached through the slots in `path`, and the nodes below it.
+fn validate_hamt_node<BS: Blockstore>(
+    store: &BS,
+    cid: &Cid,
+    bit_width: u32,
+    path: &mut Vec<u64>,
+) -> anyhow::Result<()> {
+    let depth = path.len() as u32;
+    if (depth + 1) * bit_width > 256 {
+        return Err(anyhow!(
+            "hamt node {} is deeper than the key hash allows",
+            cid
+        ));
+    }
+    let (BytesDe(bitfield), pointers): (BytesDe, Vec<Ipld>) = store
+        .get_cbor(cid)?
+        .ok_or_else(|| anyhow!("hamt node {} not found", cid))?;
+    let slots = 1_usize << bit_width;
+    if bitfield.first() == Some(&0) {
+        return Err(anyhow!(
+            "hamt node {} bitfield has a leading zero byte",
+            cid
+        ));
+    }
+    if bitfield.len() > (slots + 7) / 8
+        || (slots < 8 && bitfield.first().map_or(0, |b| b >> slots) != 0)
+    {
+        return Err(anyhow!(
+            "hamt node {} bitfield has bits beyond its {} slots",
+            cid,
+            slots
+        ));
+    }
+    // The bitfield is a big-endian integer with bit i set for slot i.
+    let occupied: Vec<u64> = (0..bitfield.len() as u64 * 8)
+        .filter(|i| bitfield[bitfield.len() - 1 - (i / 8) as usize] & (1 << (i % 8)) != 0)
+        .collect();
+    if occupied.len() != pointers.len() {
+        return Err(anyhow!(
+            "hamt node {} has {} pointers for {} occupied slots",
+            cid,
+            pointers.len(),
+            occupied.len()
+        ));
+    }
+
+    let mut has_link = false;
+    let mut entries = 0;
+    for (slot, pointer) in occupied.into_iter().zip(&pointers) {
+        path.push(slot);
+        match pointer {
+            Ipld::Link(child) => {
+                has_link = true;
+                validate_hamt_node(store, child, bit_width, path)?;
+            }
+            Ipld::List(bucket) => {
+                if bucket.is_empty() || bucket.len() > MAX_BUCKET_SIZE {
+                    return Err(anyhow!(
+                        "hamt node {} has a bucket of {} entries",
+                        cid,
+                        bucket.len()
+                    ));
+                }
+                let mut previous: Option<&Vec<u8>> = None;
+                for entry in bucket {
+                    let key = match entry {
+                        Ipld::List(kv) => match kv.as_slice() {
+                            [Ipld::Bytes(key), _] => key,
+                            _ => return Err(anyhow!("malformed hamt bucket entry in {}", cid)),
+                        },
+                        _ => return Err(anyhow!("malformed hamt bucket entry in {}", cid)),
+                    };
+                    if previous.map_or(false, |p| p >= key) {
+                        return Err(anyhow!("hamt node {} has a bucket out of key order", cid));
+                    }
+                    let digest = Sha256::digest(key);
+                    let in_place = path
+                        .iter()
+                        .enumerate()
+                        .all(|(d, slot)| hash_index(&digest, d as u32, bit_width) == *slot);
+                    if !in_place {
+                        return Err(anyhow!(
+                            "hamt node {} holds key {:?} outside the slot of its hash",
+                            cid,
+                            key
+                        ));
+                    }
+                    previous = Some(key);
+                }
+                entries += bucket.len();
+            }
+            _ => return Err(anyhow!("malformed hamt pointer in {}", cid)),
+        }
+        path.pop();
+    }
+    // Removing entries from a node collapses it into a bucket of its parent once it has no
+    // children and no more entries than fit in a bucket.
+    if depth > 0 && !has_link && entries <= MAX_BUCKET_SIZE {
+        return Err(anyhow!(
+            "hamt node {} holds {} entries and should have been collapsed",
+            cid,
+            entries
+        ));
+    }
+    Ok(())
+}
+
+/// The slot selected by a key hash at `depth`: the `bit_width` bits after the first
+/// `depth * bit_width`, most significant first.
+fn hash_index(digest: &[u8], depth: u32, bit_width: u32) -> u64 {
+    (depth * bit_width..(depth + 1) * bit_width).fold(0, |index, bit| {
+        let b = (digest[(bit / 8) as usize] >> (7 - bit % 8)) & 1;
+        index << 1 | b as u64
+    })
+}
+
+/// Loads the AMT at `root` after checking it with [`validate_amt`].
+pub fn load_array_validated<'bs, BS, V>(
+    root: &Cid,
+    store: &'bs BS,
+) -> anyhow::Result<Array<'bs, V, BS>>
+where
+    BS: Blockstore,
+    V: DeserializeOwned + Serialize,
+{
+    validate_amt(store, root)?;
+    Ok(Array::load(root, store)?)
+}
+
+/// Loads the HAMT at `root` after checking it with [`validate_hamt`].
+pub fn load_map_validated<'bs, BS, V>(
+    root: &Cid,
+    store: &'bs BS,
+    bit_width: u32,
+) -> anyhow::Result<Map<'bs, BS, V>>
+where
+    BS: Blockstore,
+    V: DeserializeOwned + Serialize,
+{
+    validate_hamt(store, root, bit_width)?;
+    Ok(make_map_with_root_and_bitwidth(root, store, bit_width)?)
+}
@@ -200,3 +200,30 @@ fn values_in_range() {
         assert_err_bound(&fast_money, &power, delta, t0, err_bound.clone());
     }
 }
+
+// Values computed with the integer math of go-state-types' `smoothing.Extrapolate`.
+#[test]
+fn extrapolate_matches_fixed_point_math() {
+    // Q.128 position and (declining) velocity with non-zero fractional bits.
+    let estimate = FilterEstimate {
+        position: (BigInt::from(36266260308195979333u128) << PRECISION) + 12345,
+        velocity: -(BigInt::from(109897758509u64) << PRECISION) - 777,
+    };
+    let cases: [(ChainEpoch, &str, u128); 2] = [
+        (
+            0,
+            "4199346049910367186950829367930250401858762388273538356683391010152937505823891099074243757867008",
+            36266260308195979333,
+        ),
+        (
+            2_880_000,
+            "4162697211656832345631338713308766758121845913256180310942173776646745078359650707088755558187008",
+            35949754763690059332,
+        ),
+    ];
+    for (delta, q256, whole) in cases {
+        let extrapolated = estimate.extrapolate(delta); // Q.256
+        assert_eq!(q256.parse::<BigInt>().unwrap(), extrapolated);
+        assert_eq!(BigInt::from(whole), extrapolated >> (2 * PRECISION));
+    }
+}
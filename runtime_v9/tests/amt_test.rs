@@ -0,0 +1,40 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use fil_actors_runtime_v9::Array;
+use fvm_ipld_blockstore::MemoryBlockstore;
+
+// Expected roots are the DAG-CBOR encodings of go-amt-ipld (v3) nodes with bit width 3.
+fn root(entries: &[(u64, u64)]) -> String {
+    let store = MemoryBlockstore::default();
+    let mut arr = Array::<u64, _>::new_with_bit_width(&store, 3);
+    for &(i, v) in entries {
+        arr.set(i, v).unwrap();
+    }
+    arr.flush().unwrap().to_string()
+}
+
+#[test]
+fn empty_amt_root() {
+    assert_eq!(
+        "bafy2bzacedijw74yui7otvo63nfl3hdq2vdzuy7wx2tnptwed6zml4vvz7wee",
+        root(&[])
+    );
+}
+
+#[test]
+fn dense_amt_root() {
+    assert_eq!(
+        "bafy2bzaceduh5wonxk3kkkvxcbfljnmymoc4iuh7fgsbny7zdg5ppovaxmjga",
+        root(&[(0, 10), (1, 20), (2, 30)])
+    );
+}
+
+#[test]
+fn sparse_amt_root() {
+    // Index 100 needs a height 2 tree: 8^3 > 100.
+    assert_eq!(
+        "bafy2bzacebxuqajlk2kcd5h27ecplsi6ljldcqjbbo56226nv5exlazja5lfs",
+        root(&[(0, 5), (100, 1)])
+    );
+}
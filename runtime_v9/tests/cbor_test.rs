@@ -0,0 +1,174 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::collections::BTreeMap;
+
+use fil_actors_runtime_v9::cbor::{
+    deserialize_limited, deserialize_next, deserialize_prefix, deserialize_strict, DecodeLimits,
+};
+use fvm_ipld_encoding::{from_slice, to_vec};
+use fvm_shared::commcid::data_commitment_v1_to_cid;
+use fvm_shared::error::ExitCode;
+use fvm_shared::piece::{PaddedPieceSize, PieceInfo};
+
+fn assert_rejected<O>(input: &str)
+where
+    O: serde::de::DeserializeOwned + serde::Serialize + std::fmt::Debug,
+{
+    let err = deserialize_strict::<O>(&hex::decode(input).unwrap(), "test value").unwrap_err();
+    assert_eq!(ExitCode::USR_SERIALIZATION, err.exit_code(), "{}", input);
+}
+
+#[test]
+fn strict_accepts_canonical_encoding() {
+    // [1, "a"]
+    let v: (u64, String) = deserialize_strict(&hex::decode("82016161").unwrap(), "t").unwrap();
+    assert_eq!((1, "a".to_string()), v);
+    // {"a": 1, "b": 2}
+    let m: BTreeMap<String, u64> =
+        deserialize_strict(&hex::decode("a2616101616202").unwrap(), "t").unwrap();
+    assert_eq!(2, m.len());
+}
+
+#[test]
+fn strict_rejects_non_minimal_prefixes() {
+    // Integer 1 encoded in a one byte argument.
+    assert_rejected::<(u64, String)>("8218016161");
+    // Array length encoded in a one byte argument.
+    assert_rejected::<(u64, String)>("9802016161");
+    // String length encoded in a one byte argument.
+    assert_rejected::<(u64, String)>("8201780161");
+}
+
+#[test]
+fn strict_rejects_duplicate_map_keys() {
+    // {"a": 1, "a": 2}
+    assert_rejected::<BTreeMap<String, u64>>("a2616101616102");
+}
+
+#[test]
+fn piece_info_encoding() {
+    // PieceInfo is the tuple [size, cid], as go-state-types abi.PieceInfo.
+    let comm_p: Vec<u8> = (0..32).collect();
+    let cid = data_commitment_v1_to_cid(&comm_p).unwrap();
+    let cases = [(128, "821880"), (32 << 30, "821b0000000800000000")];
+    for (size, prefix) in cases {
+        let info = PieceInfo {
+            size: PaddedPieceSize(size),
+            cid,
+        };
+        let bytes = to_vec(&info).unwrap();
+        assert_eq!(
+            format!("{}d82a5828000181e203922020{}", prefix, hex::encode(&comm_p)),
+            hex::encode(&bytes)
+        );
+        let decoded: PieceInfo = from_slice(&bytes).unwrap();
+        assert_eq!(info.size, decoded.size);
+        assert_eq!(info.cid, decoded.cid);
+    }
+}
+
+#[test]
+fn prefix_reports_consumed_length() {
+    // [1, "a"] followed by two unrelated bytes.
+    let input = hex::decode("82016161ff00").unwrap();
+    let (v, len): ((u64, String), _) = deserialize_prefix(&input, "t").unwrap();
+    assert_eq!((1, "a".to_string()), v);
+    assert_eq!(4, len);
+    assert_eq!(&[0xff, 0x00], &input[len..]);
+
+    // A piece info, whose CID is a tagged byte string, followed by an integer.
+    let comm_p: Vec<u8> = (0..32).collect();
+    let info = PieceInfo {
+        size: PaddedPieceSize(2048),
+        cid: data_commitment_v1_to_cid(&comm_p).unwrap(),
+    };
+    let mut input = to_vec(&info).unwrap();
+    let info_len = input.len();
+    input.extend(to_vec(&7u64).unwrap());
+    let (decoded, len): (PieceInfo, _) = deserialize_prefix(&input, "t").unwrap();
+    assert_eq!(info.cid, decoded.cid);
+    assert_eq!(info_len, len);
+
+    let mut cursor = &input[..];
+    let _: PieceInfo = deserialize_next(&mut cursor, "t").unwrap();
+    assert_eq!(7u64, deserialize_next::<u64>(&mut cursor, "t").unwrap());
+    assert!(cursor.is_empty());
+}
+
+#[test]
+fn prefix_rejects_truncated_input() {
+    // [1, "a"] missing its last byte, and a map missing its value.
+    for input in ["820161", "a16161"] {
+        let bytes = hex::decode(input).unwrap();
+        let mut cursor = &bytes[..];
+        let before = cursor;
+        let err = deserialize_next::<(u64, String)>(&mut cursor, "t").unwrap_err();
+        assert_eq!(ExitCode::USR_SERIALIZATION, err.exit_code(), "{}", input);
+        assert_eq!(before, cursor);
+    }
+    // An indefinite-length array.
+    assert!(deserialize_prefix::<Vec<u64>>(&hex::decode("9f01ff").unwrap(), "t").is_err());
+}
+
+#[test]
+fn limited_accepts_input_within_limits() {
+    // [[1, 2], {"a": []}]
+    let input = hex::decode("82820102a1616180").unwrap();
+    let limits = DecodeLimits {
+        max_depth: 3,
+        max_length: 2,
+    };
+    let v: (Vec<u64>, BTreeMap<String, Vec<u64>>) =
+        deserialize_limited(&input, &limits, "t").unwrap();
+    assert_eq!(vec![1, 2], v.0);
+    assert_eq!(Some(&vec![]), v.1.get("a"));
+
+    let limits = DecodeLimits {
+        max_depth: 2,
+        ..limits
+    };
+    assert!(
+        deserialize_limited::<(Vec<u64>, BTreeMap<String, Vec<u64>>)>(&input, &limits, "t")
+            .is_err()
+    );
+    let limits = DecodeLimits {
+        max_depth: 3,
+        max_length: 1,
+    };
+    assert!(
+        deserialize_limited::<(Vec<u64>, BTreeMap<String, Vec<u64>>)>(&input, &limits, "t")
+            .is_err()
+    );
+}
+
+#[test]
+fn limited_rejects_deep_nesting() {
+    // 100,000 nested single element arrays around an integer.
+    let mut input = vec![0x81; 100_000];
+    input.push(0x00);
+    let err =
+        deserialize_limited::<Vec<u64>>(&input, &DecodeLimits::default(), "params").unwrap_err();
+    assert_eq!(ExitCode::USR_SERIALIZATION, err.exit_code());
+    assert!(err.msg().contains("maximum depth"), "{}", err.msg());
+}
+
+#[test]
+fn limited_rejects_absurd_lengths() {
+    // An array, a map and a byte string each claiming 2^64 - 1 items, with nothing after.
+    for input in [
+        "9bffffffffffffffff",
+        "bbffffffffffffffff",
+        "5bffffffffffffffff",
+    ] {
+        let input = hex::decode(input).unwrap();
+        let err =
+            deserialize_limited::<Vec<u8>>(&input, &DecodeLimits::UNLIMITED, "params").unwrap_err();
+        assert_eq!(ExitCode::USR_SERIALIZATION, err.exit_code());
+    }
+    // An array of 2^21 integers, above the default maximum length, in a 5 byte input.
+    let input = hex::decode("9a00200000").unwrap();
+    let err =
+        deserialize_limited::<Vec<u64>>(&input, &DecodeLimits::default(), "params").unwrap_err();
+    assert!(err.msg().contains("exceeds the maximum"), "{}", err.msg());
+}
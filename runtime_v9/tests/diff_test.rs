@@ -0,0 +1,106 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::collections::BTreeSet;
+
+use cid::Cid;
+use fil_actors_runtime_v9::{
+    amt_diff, hamt_diff, make_empty_map, Array, Change, TrackingBlockstore,
+};
+use fvm_ipld_encoding::{BytesDe, CborStore};
+use fvm_ipld_hamt::BytesKey;
+use libipld_core::ipld::Ipld;
+
+/// Returns the links of the root node of an AMT.
+fn amt_root_links(store: &TrackingBlockstore, root: &Cid) -> BTreeSet<Cid> {
+    let (_, _, _, (_, links, _)): (u32, u32, u64, (BytesDe, Vec<Cid>, Vec<u64>)) =
+        store.get_cbor(root).unwrap().unwrap();
+    links.into_iter().collect()
+}
+
+/// Returns the links of the root node of a HAMT.
+fn hamt_root_links(store: &TrackingBlockstore, root: &Cid) -> BTreeSet<Cid> {
+    let (_, pointers): (BytesDe, Vec<Ipld>) = store.get_cbor(root).unwrap().unwrap();
+    pointers
+        .into_iter()
+        .filter_map(|p| match p {
+            Ipld::Link(cid) => Some(cid),
+            _ => None,
+        })
+        .collect()
+}
+
+#[test]
+fn amt_diff_skips_unchanged_subtrees() {
+    let store = TrackingBlockstore::default();
+    let mut arr = Array::<u64, _>::new_with_bit_width(&store, 3);
+    for i in 0..1000 {
+        arr.set(i, i).unwrap();
+    }
+    let old = arr.flush().unwrap();
+    arr.set(500, 9999).unwrap();
+    arr.delete(501).unwrap();
+    arr.set(1000, 1000).unwrap();
+    let new = arr.flush().unwrap();
+
+    let shared: BTreeSet<_> = amt_root_links(&store, &old)
+        .intersection(&amt_root_links(&store, &new))
+        .copied()
+        .collect();
+    assert!(!shared.is_empty());
+
+    store.clear_reads();
+    let changes = amt_diff::<_, u64>(&store, &old, &new).unwrap();
+    assert_eq!(
+        vec![
+            Change::Modified(500, 500, 9999),
+            Change::Removed(501, 501),
+            Change::Added(1000, 1000),
+        ],
+        changes
+    );
+    let reads = store.reads();
+    assert!(reads.iter().all(|cid| !shared.contains(cid)));
+    // Two roots, and two paths of three nodes below each.
+    assert!(reads.len() <= 2 + 2 * 2 * 3, "{} reads", reads.len());
+
+    assert!(amt_diff::<_, u64>(&store, &old, &old).unwrap().is_empty());
+}
+
+#[test]
+fn hamt_diff_skips_unchanged_subtrees() {
+    let store = TrackingBlockstore::default();
+    let mut map = make_empty_map::<_, u64>(&store, 5);
+    for i in 0..2000 {
+        map.set(BytesKey(format!("key-{}", i).into_bytes()), i)
+            .unwrap();
+    }
+    let old = map.flush().unwrap();
+    map.set(BytesKey(b"key-7".to_vec()), 7777).unwrap();
+    map.delete(&BytesKey(b"key-8".to_vec())).unwrap();
+    map.set(BytesKey(b"new".to_vec()), 1).unwrap();
+    let new = map.flush().unwrap();
+
+    let shared: BTreeSet<_> = hamt_root_links(&store, &old)
+        .intersection(&hamt_root_links(&store, &new))
+        .copied()
+        .collect();
+    assert!(!shared.is_empty());
+
+    store.clear_reads();
+    let mut changes = hamt_diff::<_, u64>(&store, &old, &new).unwrap();
+    changes.sort_by_key(|c| match c {
+        Change::Added(k, _) | Change::Removed(k, _) | Change::Modified(k, _, _) => k.0.clone(),
+    });
+    assert_eq!(
+        vec![
+            Change::Modified(BytesKey(b"key-7".to_vec()), 7, 7777),
+            Change::Removed(BytesKey(b"key-8".to_vec()), 8),
+            Change::Added(BytesKey(b"new".to_vec()), 1),
+        ],
+        changes
+    );
+    assert!(store.reads().iter().all(|cid| !shared.contains(cid)));
+
+    assert!(hamt_diff::<_, u64>(&store, &old, &old).unwrap().is_empty());
+}
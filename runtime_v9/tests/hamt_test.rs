@@ -0,0 +1,88 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use fil_actors_runtime_v9::{make_empty_map, make_map_with_root_and_bitwidth};
+use fvm_ipld_blockstore::MemoryBlockstore;
+use fvm_ipld_hamt::BytesKey;
+
+// Expected roots are the DAG-CBOR encodings of go-hamt-ipld (v3) nodes: SHA-256 keyed, with
+// buckets of at most 3 entries before they are pushed down into a child node.
+fn root(bit_width: u32, keys: usize) -> String {
+    let store = MemoryBlockstore::default();
+    let mut map = make_empty_map::<_, u64>(&store, bit_width);
+    for i in 0..keys {
+        map.set(BytesKey(format!("key-{}", i).into_bytes()), i as u64)
+            .unwrap();
+    }
+    map.flush().unwrap().to_string()
+}
+
+#[test]
+fn empty_hamt_root() {
+    assert_eq!(
+        "bafy2bzaceamp42wmmgr2g2ymg46euououzfyck7szknvfacqscohrvaikwfay",
+        root(5, 0)
+    );
+}
+
+#[test]
+fn hamt_root_single_node() {
+    // 35 keys still fit in the buckets of the root node.
+    assert_eq!(
+        "bafy2bzaceao4i2d3poom7aarkvw7oul5j64raxub6mgogaqumwp6odz54pqpa",
+        root(5, 35)
+    );
+}
+
+#[test]
+fn hamt_root_bucket_overflow() {
+    // The 36th key overflows a bucket of the root node into a child node.
+    assert_eq!(
+        "bafy2bzacebcgf3ymwozw3mef2hw3bxybfkacvwr5l4pkawq7bff656wrqypae",
+        root(5, 36)
+    );
+    assert_eq!(
+        "bafy2bzaceclhu4cbbdxgsgfriyulaqob2sdf3tpvb6x7lcnh7fqiaek7ivyjq",
+        root(1, 5)
+    );
+    assert_eq!(
+        "bafy2bzacebug7shhtynz5r6fry6hmxtqd56wy2fyplfefnohy67fcqeyvvvac",
+        root(3, 13)
+    );
+}
+
+#[test]
+fn hamt_loaded_at_its_bitwidth() {
+    // The bit width is not recorded in the root, so a map must be loaded at the width it was
+    // built with, as the market balance tables at width 6.
+    let store = MemoryBlockstore::default();
+    let mut map = make_empty_map::<_, u64>(&store, 6);
+    for i in 0..200 {
+        map.set(BytesKey(format!("key-{}", i).into_bytes()), i)
+            .unwrap();
+    }
+    let root = map.flush().unwrap();
+
+    let mut map = make_map_with_root_and_bitwidth::<_, u64>(&root, &store, 6).unwrap();
+    for i in 0..200 {
+        assert_eq!(
+            Some(&i),
+            map.get(&BytesKey(format!("key-{}", i).into_bytes()))
+                .unwrap()
+        );
+    }
+    map.set(BytesKey(b"key-7".to_vec()), 7).unwrap();
+    assert_eq!(root, map.flush().unwrap());
+
+    // At the default width the lookups walk the wrong slots.
+    let map = make_map_with_root_and_bitwidth::<_, u64>(&root, &store, 5).unwrap();
+    let found = (0..200)
+        .filter(|i| {
+            matches!(
+                map.get(&BytesKey(format!("key-{}", i).into_bytes())),
+                Ok(Some(v)) if v == i
+            )
+        })
+        .count();
+    assert!(found < 200);
+}
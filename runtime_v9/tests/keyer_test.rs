@@ -0,0 +1,50 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use std::str::FromStr;
+
+use fil_actors_runtime_v9::{u64_key, Keyer};
+use fvm_shared::address::Address;
+
+// Expected keys match go-state-types `abi.IdAddrKey(addr).Key()`, which is the
+// raw address bytes (protocol byte followed by the payload).
+#[test]
+fn address_keys() {
+    let cases = [
+        (Address::new_id(0), "0000"),
+        (Address::new_id(1234567), "0087ad4b"),
+        (Address::new_id(u64::MAX), "00ffffffffffffffffff01"),
+        (
+            Address::from_str("f1aaaqeayeaudaocajbifqydiob4ibceqt2oc2pvy").unwrap(),
+            "01000102030405060708090a0b0c0d0e0f10111213",
+        ),
+        (
+            Address::from_str("f2mrswmz3infvgw3dnnzxxa4lson2hk5txvrfaxby").unwrap(),
+            "026465666768696a6b6c6d6e6f7071727374757677",
+        ),
+        (
+            Address::from_str(
+                "f3aaaqeayeaudaocajbifqydiob4ibceqtcqkrmfyydenbwha5dypsaijcemsckjrhfausukzmfuxc7xayzmkq",
+            )
+            .unwrap(),
+            "03000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f",
+        ),
+    ];
+    for (addr, expected) in cases {
+        assert_eq!(hex::encode(addr.key().0), expected, "key for {}", addr);
+    }
+}
+
+#[test]
+fn uint_keys() {
+    let cases = [
+        (0u64, "00"),
+        (127, "7f"),
+        (128, "8001"),
+        (u64::MAX, "ffffffffffffffffff01"),
+    ];
+    for (n, expected) in cases {
+        assert_eq!(hex::encode(n.key().0), expected);
+        assert_eq!(n.key(), u64_key(n));
+    }
+}
@@ -0,0 +1,149 @@
+// Copyright 2019-2022 ChainSafe Systems
+// SPDX-License-Identifier: Apache-2.0, MIT
+
+use cid::multihash::Code;
+use cid::Cid;
+use fil_actors_runtime_v9::{
+    load_array_validated, load_map_validated, make_empty_map, make_map_with_root_and_bitwidth,
+    validate_amt, validate_hamt, Array,
+};
+use fvm_ipld_blockstore::MemoryBlockstore;
+use fvm_ipld_encoding::CborStore;
+use fvm_ipld_hamt::BytesKey;
+use libipld_core::ipld::Ipld;
+
+fn put(store: &MemoryBlockstore, node: Ipld) -> Cid {
+    store.put_cbor(&node, Code::Blake2b256).unwrap()
+}
+
+fn amt_node(bmap: u8, links: Vec<Cid>, values: Vec<i128>) -> Ipld {
+    Ipld::List(vec![
+        Ipld::Bytes(vec![bmap]),
+        Ipld::List(links.into_iter().map(Ipld::Link).collect()),
+        Ipld::List(values.into_iter().map(Ipld::Integer).collect()),
+    ])
+}
+
+fn amt_root(height: u64, count: u64, node: Ipld) -> Ipld {
+    Ipld::List(vec![
+        Ipld::Integer(3),
+        Ipld::Integer(height as i128),
+        Ipld::Integer(count as i128),
+        node,
+    ])
+}
+
+fn hamt_node(bitfield: &[u8], pointers: Vec<Ipld>) -> Ipld {
+    Ipld::List(vec![Ipld::Bytes(bitfield.to_vec()), Ipld::List(pointers)])
+}
+
+fn bucket(entries: &[(&[u8], i128)]) -> Ipld {
+    Ipld::List(
+        entries
+            .iter()
+            .map(|(k, v)| Ipld::List(vec![Ipld::Bytes(k.to_vec()), Ipld::Integer(*v)]))
+            .collect(),
+    )
+}
+
+#[test]
+fn well_formed_collections_validate() {
+    let store = MemoryBlockstore::default();
+    let mut arr = Array::<u64, _>::new_with_bit_width(&store, 3);
+    let empty = arr.flush().unwrap();
+    validate_amt(&store, &empty).unwrap();
+    for i in 0..1000 {
+        arr.set(i, i).unwrap();
+    }
+    let root = arr.flush().unwrap();
+    validate_amt(&store, &root).unwrap();
+    // Deleting all but the first entries lowers the tree.
+    for i in 10..1000 {
+        arr.delete(i).unwrap();
+    }
+    let root = arr.flush().unwrap();
+    validate_amt(&store, &root).unwrap();
+    let arr = load_array_validated::<_, u64>(&root, &store).unwrap();
+    assert_eq!(10, arr.count());
+
+    for bit_width in [3, 5] {
+        let mut map = make_empty_map::<_, u64>(&store, bit_width);
+        for i in 0..2000 {
+            map.set(BytesKey(format!("key-{}", i).into_bytes()), i)
+                .unwrap();
+        }
+        let root = map.flush().unwrap();
+        validate_hamt(&store, &root, bit_width).unwrap();
+        // Deleting most entries collapses nodes back into buckets.
+        for i in 5..2000 {
+            map.delete(&BytesKey(format!("key-{}", i).into_bytes()))
+                .unwrap();
+        }
+        let root = map.flush().unwrap();
+        let map = load_map_validated::<_, u64>(&root, &store, bit_width).unwrap();
+        assert_eq!(Some(&3), map.get(&BytesKey(b"key-3".to_vec())).unwrap());
+    }
+}
+
+#[test]
+fn malformed_amt_rejected() {
+    let store = MemoryBlockstore::default();
+
+    // A root claiming more values than its leaf holds. It loads, and reports the wrong count.
+    let root = put(&store, amt_root(0, 5, amt_node(0b11, vec![], vec![1, 2])));
+    assert_eq!(5, Array::<u64, _>::load(&root, &store).unwrap().count());
+    assert!(validate_amt(&store, &root).is_err());
+    assert!(load_array_validated::<_, u64>(&root, &store).is_err());
+
+    // A leaf with three occupied slots and two values.
+    let root = put(&store, amt_root(0, 2, amt_node(0b111, vec![], vec![1, 2])));
+    assert!(validate_amt(&store, &root).is_err());
+
+    // A root one level higher than its entries need. It loads, and its entry can be read.
+    let leaf = put(&store, amt_node(0b1, vec![], vec![7]));
+    let root = put(&store, amt_root(1, 1, amt_node(0b1, vec![leaf], vec![])));
+    let arr = Array::<u64, _>::load(&root, &store).unwrap();
+    assert_eq!(Some(&7), arr.get(0).unwrap());
+    assert!(validate_amt(&store, &root).is_err());
+
+    // An internal node holding values.
+    let root = put(&store, amt_root(1, 1, amt_node(0b10, vec![], vec![7])));
+    assert!(validate_amt(&store, &root).is_err());
+}
+
+#[test]
+fn malformed_hamt_rejected() {
+    let store = MemoryBlockstore::default();
+    // With bit width 5, the hash of "a" selects slot 25 at the root and slot 10 below it.
+    let slot_25 = [0x02, 0, 0, 0];
+    let root = put(&store, hamt_node(&slot_25, vec![bucket(&[(b"a", 1)])]));
+    validate_hamt(&store, &root, 5).unwrap();
+
+    // The key in slot 0. The map loads, but the key cannot be found.
+    let root = put(&store, hamt_node(&[0x01], vec![bucket(&[(b"a", 1)])]));
+    let map = make_map_with_root_and_bitwidth::<_, u64>(&root, &store, 5).unwrap();
+    assert_eq!(None, map.get(&BytesKey(b"a".to_vec())).unwrap());
+    assert!(validate_hamt(&store, &root, 5).is_err());
+    assert!(load_map_validated::<_, u64>(&root, &store, 5).is_err());
+
+    // An empty bucket, a bucket out of key order, and a bitfield with a leading zero byte.
+    let root = put(&store, hamt_node(&slot_25, vec![bucket(&[])]));
+    assert!(validate_hamt(&store, &root, 5).is_err());
+    let root = put(
+        &store,
+        hamt_node(&slot_25, vec![bucket(&[(b"a", 1), (b"a", 2)])]),
+    );
+    assert!(validate_hamt(&store, &root, 5).is_err());
+    let root = put(
+        &store,
+        hamt_node(&[0, 0x02, 0, 0, 0], vec![bucket(&[(b"a", 1)])]),
+    );
+    assert!(validate_hamt(&store, &root, 5).is_err());
+
+    // A child node holding a single entry, which should have been collapsed into the root.
+    let child = put(&store, hamt_node(&[0x04, 0], vec![bucket(&[(b"a", 1)])]));
+    let root = put(&store, hamt_node(&slot_25, vec![Ipld::Link(child)]));
+    let map = make_map_with_root_and_bitwidth::<_, u64>(&root, &store, 5).unwrap();
+    assert_eq!(Some(&1), map.get(&BytesKey(b"a".to_vec())).unwrap());
+    assert!(validate_hamt(&store, &root, 5).is_err());
+}
@@ -2,13 +2,15 @@
 // SPDX-License-Identifier: Apache-2.0, MIT
 
 use cid::Cid;
-use fil_actors_runtime_v8::make_empty_map;
+use fil_actors_runtime_v8::{make_empty_map, make_map_with_root_and_bitwidth};
 use fvm_ipld_blockstore::Blockstore;
 use fvm_ipld_encoding::tuple::*;
 use fvm_ipld_encoding::Cbor;
 use fvm_shared::address::Address;
 use fvm_shared::HAMT_BIT_WIDTH;
 
+use crate::{AddrPairKey, RemoveDataCapProposalID};
+
 #[derive(Serialize_tuple, Deserialize_tuple)]
 pub struct State {
     pub root_key: Address,
@@ -30,6 +32,32 @@ impl State {
             remove_data_cap_proposal_ids: empty_map,
         })
     }
+
+    /// The root key holder, which adds and removes verifiers.
+    pub fn root_key(&self) -> Address {
+        self.root_key
+    }
+
+    /// Returns the ID of the last proposal to remove data cap from each client, keyed by the
+    /// verifier that signed it and the client, in HAMT order.
+    pub fn remove_data_cap_proposals<BS: Blockstore>(
+        &self,
+        store: &BS,
+    ) -> anyhow::Result<Vec<(AddrPairKey, RemoveDataCapProposalID)>> {
+        let ids = make_map_with_root_and_bitwidth::<_, RemoveDataCapProposalID>(
+            &self.remove_data_cap_proposal_ids,
+            store,
+            HAMT_BIT_WIDTH,
+        )
+        .map_err(|e| anyhow::anyhow!("failed to load remove data cap proposal ids: {}", e))?;
+        let mut proposals = Vec::new();
+        ids.for_each(|k, id| {
+            proposals.push((AddrPairKey::from_bytes(k)?, id.clone()));
+            Ok(())
+        })
+        .map_err(|e| anyhow::anyhow!("failed to iterate remove data cap proposal ids: {}", e))?;
+        Ok(proposals)
+    }
 }
 
 impl Cbor for State {}
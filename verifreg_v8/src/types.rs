@@ -78,6 +78,7 @@ pub struct RemoveDataCapProposal {
     pub removal_proposal_id: RemoveDataCapProposalID,
 }
 
+#[derive(Clone, Debug, PartialEq, Eq)]
 pub struct AddrPairKey {
     pub first: Address,
     pub second: Address,
@@ -94,4 +95,31 @@ impl AddrPairKey {
         first.append(&mut second);
         first
     }
+
+    /// Splits a key encoded by [`AddrPairKey::to_bytes`] back into its two addresses.
+    pub fn from_bytes(bytes: &[u8]) -> anyhow::Result<Self> {
+        let first_len = match bytes.first() {
+            // The payload of an ID address is a varint, which ends at the first byte below 0x80.
+            Some(0) => bytes[1..]
+                .iter()
+                .position(|b| b & 0x80 == 0)
+                .map(|i| i + 2)
+                .ok_or_else(|| anyhow::anyhow!("truncated ID address in key"))?,
+            Some(1) | Some(2) => 21,
+            Some(3) => 49,
+            Some(p) => return Err(anyhow::anyhow!("unknown address protocol {} in key", p)),
+            None => return Err(anyhow::anyhow!("empty address pair key")),
+        };
+        if first_len >= bytes.len() {
+            return Err(anyhow::anyhow!(
+                "address pair key of {} bytes is truncated",
+                bytes.len()
+            ));
+        }
+        let (first, second) = bytes.split_at(first_len);
+        Ok(AddrPairKey {
+            first: Address::from_bytes(first)?,
+            second: Address::from_bytes(second)?,
+        })
+    }
 }
@@ -8,17 +8,20 @@ use fvm_ipld_encoding::Cbor;
 use fvm_shared::address::Address;
 use fvm_shared::bigint::bigint_ser::BigIntDe;
 use fvm_shared::clock::ChainEpoch;
+use fvm_shared::econ::TokenAmount;
 use fvm_shared::error::ExitCode;
 use fvm_shared::piece::PaddedPieceSize;
 use fvm_shared::sector::SectorNumber;
 use fvm_shared::{ActorID, HAMT_BIT_WIDTH};
 
 use fil_actors_runtime_v9::{
-    actor_error, make_empty_map, make_map_with_root_and_bitwidth, ActorError, AsActorError, Map,
-    MapMap,
+    actor_error, make_empty_map, make_map_with_root_and_bitwidth, parse_uint_key, ActorError,
+    AsActorError, Map, MapMap,
 };
 
+use crate::expiration::Expires;
 use crate::DataCap;
+use crate::{AddrPairKey, RemoveDataCapProposalID};
 use crate::{AllocationID, ClaimID};
 
 #[derive(Serialize_tuple, Deserialize_tuple, Debug, Clone)]
@@ -59,6 +62,38 @@ impl State {
         })
     }
 
+    /// The root key holder, which adds and removes verifiers.
+    pub fn root_key(&self) -> Address {
+        self.root_key
+    }
+
+    /// Returns the ID of the last proposal to remove data cap from each client, keyed by the
+    /// verifier that signed it and the client, in HAMT order.
+    pub fn remove_data_cap_proposals<BS: Blockstore>(
+        &self,
+        store: &BS,
+    ) -> Result<Vec<(AddrPairKey, RemoveDataCapProposalID)>, ActorError> {
+        let ids = make_map_with_root_and_bitwidth::<_, RemoveDataCapProposalID>(
+            &self.remove_data_cap_proposal_ids,
+            store,
+            HAMT_BIT_WIDTH,
+        )
+        .context_code(
+            ExitCode::USR_ILLEGAL_STATE,
+            "failed to load remove data cap proposal ids",
+        )?;
+        let mut proposals = Vec::new();
+        ids.for_each(|k, id| {
+            proposals.push((AddrPairKey::from_bytes(k)?, id.clone()));
+            Ok(())
+        })
+        .context_code(
+            ExitCode::USR_ILLEGAL_STATE,
+            "failed to iterate remove data cap proposal ids",
+        )?;
+        Ok(proposals)
+    }
+
     // Adds a verifier and cap, overwriting any existing cap for that verifier.
     pub fn put_verifier(
         &mut self,
@@ -180,6 +215,94 @@ impl State {
         Ok(allocated_ids)
     }
 
+    /// Returns all outstanding allocations made by a single client, without loading
+    /// any other client's allocations. A client with no allocations yields an empty list.
+    pub fn allocations_for_client<BS: Blockstore>(
+        &self,
+        store: &BS,
+        client: ActorID,
+    ) -> Result<Vec<(AllocationID, Allocation)>, ActorError> {
+        let mut allocs = self.load_allocs(store)?;
+        let mut found = Vec::new();
+        allocs
+            .for_each(client, |key, alloc| {
+                let id = parse_uint_key(key)
+                    .context_code(ExitCode::USR_ILLEGAL_STATE, "failed to parse uint key")?;
+                found.push((id, alloc.clone()));
+                Ok(())
+            })
+            .context_code(
+                ExitCode::USR_ILLEGAL_STATE,
+                "failed to iterate over allocations",
+            )?;
+        Ok(found)
+    }
+
+    /// Returns the client and ID of every allocation with an expiration epoch before
+    /// `at_epoch`, across all clients.
+    pub fn expired_allocations<BS: Blockstore>(
+        &self,
+        store: &BS,
+        at_epoch: ChainEpoch,
+    ) -> Result<Vec<(ActorID, AllocationID)>, ActorError> {
+        let clients =
+            make_map_with_root_and_bitwidth::<_, Cid>(&self.allocations, store, HAMT_BIT_WIDTH)
+                .context_code(
+                    ExitCode::USR_ILLEGAL_STATE,
+                    "failed to load allocations table",
+                )?;
+        let mut expired = Vec::new();
+        clients
+            .for_each(|client_key, root| {
+                let client = parse_uint_key(client_key)
+                    .context_code(ExitCode::USR_ILLEGAL_STATE, "failed to parse uint key")?;
+                let allocs =
+                    make_map_with_root_and_bitwidth::<_, Allocation>(root, store, HAMT_BIT_WIDTH)?;
+                allocs.for_each(|id_key, alloc| {
+                    if alloc.expiration < at_epoch {
+                        let id = parse_uint_key(id_key).context_code(
+                            ExitCode::USR_ILLEGAL_STATE,
+                            "failed to parse uint key",
+                        )?;
+                        expired.push((client, id));
+                    }
+                    Ok(())
+                })?;
+                Ok(())
+            })
+            .context_code(
+                ExitCode::USR_ILLEGAL_STATE,
+                "failed to iterate over allocations",
+            )?;
+        Ok(expired)
+    }
+
+    /// Returns the IDs of a client's allocations that `RemoveExpiredAllocations` would remove at
+    /// `at_epoch`, in ID order, and the datacap tokens that would be returned to the client,
+    /// without modifying the state. As in the actor, an allocation can be removed from its
+    /// expiration epoch onwards, and each byte of allocated size returns one whole token.
+    pub fn simulate_remove_expired<BS: Blockstore>(
+        &self,
+        store: &BS,
+        client: ActorID,
+        at_epoch: ChainEpoch,
+    ) -> Result<(Vec<AllocationID>, TokenAmount), ActorError> {
+        let mut expired: Vec<_> = self
+            .allocations_for_client(store, client)?
+            .into_iter()
+            .filter(|(_, alloc)| at_epoch >= alloc.expiration())
+            .collect();
+        expired.sort_by_key(|(id, _)| *id);
+        let datacap: DataCap = expired
+            .iter()
+            .map(|(_, alloc)| DataCap::from(alloc.size.0))
+            .sum();
+        Ok((
+            expired.into_iter().map(|(id, _)| id).collect(),
+            TokenAmount::from_whole(datacap),
+        ))
+    }
+
     pub fn load_claims<'a, BS: Blockstore>(
         &self,
         store: &'a BS,
@@ -203,6 +326,37 @@ impl State {
         Ok(())
     }
 
+    /// Returns a provider's claim, or `None` if the provider has no claim with that ID.
+    pub fn claim<BS: Blockstore>(
+        &self,
+        store: &BS,
+        provider: ActorID,
+        claim_id: ClaimID,
+    ) -> Result<Option<Claim>, ActorError> {
+        let mut claims = self.load_claims(store)?;
+        Ok(get_claim(&mut claims, provider, claim_id)?.cloned())
+    }
+
+    /// Returns all claims held by a single provider, without loading any other provider's
+    /// claims. A provider with no claims yields an empty list.
+    pub fn claims_for_provider<BS: Blockstore>(
+        &self,
+        store: &BS,
+        provider: ActorID,
+    ) -> Result<Vec<(ClaimID, Claim)>, ActorError> {
+        let mut claims = self.load_claims(store)?;
+        let mut found = Vec::new();
+        claims
+            .for_each(provider, |key, claim| {
+                let id = parse_uint_key(key)
+                    .context_code(ExitCode::USR_ILLEGAL_STATE, "failed to parse uint key")?;
+                found.push((id, claim.clone()));
+                Ok(())
+            })
+            .context_code(ExitCode::USR_ILLEGAL_STATE, "failed to iterate over claims")?;
+        Ok(found)
+    }
+
     pub fn put_claims<BS: Blockstore, I>(&mut self, store: &BS, claims: I) -> Result<(), ActorError>
     where
         I: Iterator<Item = (ClaimID, Claim)>,
@@ -286,3 +440,279 @@ where
         "HAMT lookup failure getting claim",
     )
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use fvm_ipld_blockstore::MemoryBlockstore;
+    use serde::de::DeserializeOwned;
+    use serde::Serialize;
+
+    fn allocation(client: ActorID, provider: ActorID) -> Allocation {
+        Allocation {
+            client,
+            provider,
+            data: Cid::default(),
+            size: PaddedPieceSize(128),
+            term_min: 1000,
+            term_max: 2000,
+            expiration: 100,
+        }
+    }
+
+    fn expiring(client: ActorID, expiration: ChainEpoch) -> Allocation {
+        Allocation {
+            expiration,
+            ..allocation(client, 200)
+        }
+    }
+
+    #[test]
+    fn allocations_for_client_is_scoped_to_client() {
+        let store = MemoryBlockstore::new();
+        let mut st = State::new(&store, Address::new_id(80)).unwrap();
+        let a_ids = st
+            .insert_allocations(
+                &store,
+                101,
+                vec![allocation(101, 200), allocation(101, 201)].into_iter(),
+            )
+            .unwrap();
+        let b_ids = st
+            .insert_allocations(&store, 102, vec![allocation(102, 200)].into_iter())
+            .unwrap();
+
+        let mut a = st.allocations_for_client(&store, 101).unwrap();
+        a.sort_by_key(|(id, _)| *id);
+        assert_eq!(
+            vec![
+                (a_ids[0], allocation(101, 200)),
+                (a_ids[1], allocation(101, 201))
+            ],
+            a
+        );
+        let b = st.allocations_for_client(&store, 102).unwrap();
+        assert_eq!(vec![(b_ids[0], allocation(102, 200))], b);
+
+        assert!(st.allocations_for_client(&store, 103).unwrap().is_empty());
+    }
+
+    #[test]
+    fn expired_allocations_excludes_live_and_boundary() {
+        let store = MemoryBlockstore::new();
+        let mut st = State::new(&store, Address::new_id(80)).unwrap();
+        // Client 101 has one expired, one expiring exactly at the query epoch, one live.
+        let a_ids = st
+            .insert_allocations(
+                &store,
+                101,
+                vec![expiring(101, 50), expiring(101, 100), expiring(101, 150)].into_iter(),
+            )
+            .unwrap();
+        let b_ids = st
+            .insert_allocations(&store, 102, vec![expiring(102, 99)].into_iter())
+            .unwrap();
+        st.insert_allocations(&store, 103, vec![expiring(103, 500)].into_iter())
+            .unwrap();
+
+        let mut expired = st.expired_allocations(&store, 100).unwrap();
+        expired.sort();
+        assert_eq!(vec![(101, a_ids[0]), (102, b_ids[0])], expired);
+        assert!(st.expired_allocations(&store, 0).unwrap().is_empty());
+    }
+
+    #[test]
+    fn simulate_remove_expired_counts_only_expired() {
+        let store = MemoryBlockstore::new();
+        let mut st = State::new(&store, Address::new_id(80)).unwrap();
+        let large = Allocation {
+            size: PaddedPieceSize(2048),
+            ..expiring(101, 90)
+        };
+        let ids = st
+            .insert_allocations(
+                &store,
+                101,
+                vec![
+                    expiring(101, 50),
+                    large,
+                    expiring(101, 100),
+                    expiring(101, 150),
+                ]
+                .into_iter(),
+            )
+            .unwrap();
+        st.insert_allocations(&store, 102, vec![expiring(102, 10)].into_iter())
+            .unwrap();
+        let before = st.allocations;
+
+        // The allocation expiring at epoch 100 is removable at that epoch.
+        let (removed, refund) = st.simulate_remove_expired(&store, 101, 100).unwrap();
+        assert_eq!(ids[..3].to_vec(), removed);
+        assert_eq!(TokenAmount::from_whole(128 + 2048 + 128), refund);
+        assert_eq!(before, st.allocations);
+
+        let (removed, refund) = st.simulate_remove_expired(&store, 101, 10).unwrap();
+        assert!(removed.is_empty());
+        assert_eq!(TokenAmount::default(), refund);
+        assert!(st
+            .simulate_remove_expired(&store, 103, 1000)
+            .unwrap()
+            .0
+            .is_empty());
+    }
+
+    fn claim(provider: ActorID, term_max: ChainEpoch) -> Claim {
+        Claim {
+            provider,
+            client: 101,
+            data: Cid::default(),
+            size: PaddedPieceSize(128),
+            term_min: 1000,
+            term_max,
+            term_start: 10,
+            sector: 7,
+        }
+    }
+
+    #[test]
+    fn claims_by_provider() {
+        let store = MemoryBlockstore::new();
+        let mut st = State::new(&store, Address::new_id(80)).unwrap();
+        let short = claim(200, 2000);
+        let long = claim(200, 5000);
+        st.put_claims(
+            &store,
+            vec![(1, short.clone()), (2, long.clone()), (3, claim(201, 2000))].into_iter(),
+        )
+        .unwrap();
+
+        assert_eq!(Some(short.clone()), st.claim(&store, 200, 1).unwrap());
+        assert_eq!(Some(long.clone()), st.claim(&store, 200, 2).unwrap());
+        // Claim 3 belongs to another provider.
+        assert_eq!(None, st.claim(&store, 200, 3).unwrap());
+        assert_eq!(None, st.claim(&store, 202, 1).unwrap());
+
+        let mut claims = st.claims_for_provider(&store, 200).unwrap();
+        claims.sort_by_key(|(id, _)| *id);
+        assert_eq!(vec![(1, short), (2, long)], claims);
+        assert!(st.claims_for_provider(&store, 202).unwrap().is_empty());
+    }
+
+    /// Returns the raw outer and inner HAMT keys of a nested actor-to-id map, sorted.
+    fn nested_keys<V>(store: &MemoryBlockstore, root: &Cid) -> Vec<(Vec<u8>, Vec<Vec<u8>>)>
+    where
+        V: Serialize + DeserializeOwned,
+    {
+        let outer = make_map_with_root_and_bitwidth::<_, Cid>(root, store, HAMT_BIT_WIDTH).unwrap();
+        let mut keys = Vec::new();
+        outer
+            .for_each(|outer_key, inner_root| {
+                let inner =
+                    make_map_with_root_and_bitwidth::<_, V>(inner_root, store, HAMT_BIT_WIDTH)?;
+                let mut inner_keys = Vec::new();
+                inner.for_each(|inner_key, _| {
+                    inner_keys.push(inner_key.0.clone());
+                    Ok(())
+                })?;
+                inner_keys.sort();
+                keys.push((outer_key.0.clone(), inner_keys));
+                Ok(())
+            })
+            .unwrap();
+        keys.sort();
+        keys
+    }
+
+    #[test]
+    fn nested_map_key_encoding() {
+        // Both levels are keyed by the unsigned varint of the actor or allocation/claim ID, as
+        // abi.UIntKey in Go's verifreg actor.
+        let store = MemoryBlockstore::new();
+        let mut st = State::new(&store, Address::new_id(80)).unwrap();
+
+        st.next_allocation_id = 0;
+        st.insert_allocations(&store, 101, vec![allocation(101, 200)].into_iter())
+            .unwrap();
+        st.next_allocation_id = 1 << 40;
+        st.insert_allocations(&store, 101, vec![allocation(101, 201)].into_iter())
+            .unwrap();
+        assert_eq!(
+            vec![(
+                vec![0x65],
+                vec![vec![0x00], vec![0x80, 0x80, 0x80, 0x80, 0x80, 0x20]]
+            )],
+            nested_keys::<Allocation>(&store, &st.allocations)
+        );
+        let mut ids: Vec<_> = st
+            .allocations_for_client(&store, 101)
+            .unwrap()
+            .into_iter()
+            .map(|(id, _)| id)
+            .collect();
+        ids.sort();
+        assert_eq!(vec![0, 1 << 40], ids);
+
+        st.put_claims(
+            &store,
+            vec![
+                (0, claim(200, 2000)),
+                (1 << 40, claim(200, 2000)),
+                (u64::MAX, claim(200, 2000)),
+            ]
+            .into_iter(),
+        )
+        .unwrap();
+        let mut max = vec![0xff; 9];
+        max.push(0x01);
+        assert_eq!(
+            vec![(
+                vec![0xc8, 0x01],
+                vec![vec![0x00], vec![0x80, 0x80, 0x80, 0x80, 0x80, 0x20], max]
+            )],
+            nested_keys::<Claim>(&store, &st.claims)
+        );
+        assert!(st.claim(&store, 200, u64::MAX).unwrap().is_some());
+    }
+
+    #[test]
+    fn remove_data_cap_proposals() {
+        let store = MemoryBlockstore::default();
+        let mut st = State::new(&store, Address::new_id(80)).unwrap();
+        assert_eq!(Address::new_id(80), st.root_key());
+        assert!(st.remove_data_cap_proposals(&store).unwrap().is_empty());
+
+        let verifier = Address::new_id(1000);
+        let clients = [Address::new_id(200), Address::new_actor(b"client")];
+        let mut ids = make_map_with_root_and_bitwidth::<_, RemoveDataCapProposalID>(
+            &st.remove_data_cap_proposal_ids,
+            &store,
+            HAMT_BIT_WIDTH,
+        )
+        .unwrap();
+        for (i, client) in clients.iter().enumerate() {
+            ids.set(
+                AddrPairKey::new(verifier, *client).to_bytes().into(),
+                RemoveDataCapProposalID { id: i as u64 + 3 },
+            )
+            .unwrap();
+        }
+        st.remove_data_cap_proposal_ids = ids.flush().unwrap();
+
+        let mut proposals = st.remove_data_cap_proposals(&store).unwrap();
+        proposals.sort_by_key(|(_, id)| id.id);
+        assert_eq!(
+            vec![
+                (
+                    AddrPairKey::new(verifier, clients[0]),
+                    RemoveDataCapProposalID { id: 3 }
+                ),
+                (
+                    AddrPairKey::new(verifier, clients[1]),
+                    RemoveDataCapProposalID { id: 4 }
+                ),
+            ],
+            proposals
+        );
+    }
+}